@@ -0,0 +1,95 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cs pushes container images to Lightsail container services
+// for programs that embed lightsailctl, rather than run it.
+//
+// A Pusher wires up the Lightsail client and the container engine,
+// so that pushing an image takes an AWS config and a PushImageInput:
+//
+//	p, err := cs.NewPusher(ctx, awsCfg)
+//	if err != nil {
+//		return err
+//	}
+//	defer p.Close()
+//	res, err := p.Push(ctx, &cs.PushImageInput{Service: "hello", Image: "hello:latest", Label: "www"})
+//
+// The fields of the inputs that take lightsailctl's own types,
+// such as PushImageInput.Events, are meant to be left unset.
+package cs
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/lightsailctl/internal"
+	"github.com/aws/lightsailctl/internal/cs"
+)
+
+type (
+	// Pusher pushes and registers images, see NewPusher.
+	Pusher = cs.Pusher
+	// PusherOption customizes what NewPusher creates.
+	PusherOption = cs.PusherOption
+	// DockerEngineConfig tells how to connect to the container engine.
+	DockerEngineConfig = cs.DockerEngineConfig
+
+	// PushImageInput describes an image for Pusher.Push.
+	PushImageInput = cs.PushImageInput
+	// PushImagesInput describes a batch of images for Pusher.PushAll.
+	PushImagesInput = cs.PushImagesInput
+	// PushImageResult describes an image that is registered with a service.
+	PushImageResult = cs.PushImageResult
+
+	// BatchPushError is returned by Pusher.PushAll when one of the images fails.
+	BatchPushError = cs.BatchPushError
+	// PushImagesError is returned by Pusher.PushAll when some of the images
+	// fail with PushImagesInput.ContinueOnError.
+	PushImagesError = cs.PushImagesError
+)
+
+// AutoLabel is the label for the next free numeric label of the service.
+const AutoLabel = cs.AutoLabel
+
+// These are the values of PushImageInput.SourceType.
+const (
+	SourceDaemon = cs.SourceDaemon
+	SourceOCI    = cs.SourceOCI
+	SourceTar    = cs.SourceTar
+)
+
+// NewPusher returns a pusher that calls Lightsail with awsCfg, and
+// pushes images of Docker Engine, or of Podman with WithPodman.
+// It logs nothing unless WithLogger is given.
+func NewPusher(ctx context.Context, awsCfg aws.Config, opts ...PusherOption) (*Pusher, error) {
+	return cs.NewPusher(ctx, awsCfg, opts...)
+}
+
+// WithEngineConfig makes the pusher connect to the container engine
+// with cfg instead of the zero DockerEngineConfig.
+func WithEngineConfig(cfg DockerEngineConfig) PusherOption {
+	return cs.WithEngineConfig(cfg)
+}
+
+// WithPodman makes the pusher use Podman instead of Docker Engine.
+func WithPodman() PusherOption {
+	return cs.WithPodman()
+}
+
+// WithLightsailOptions customizes the Lightsail client of the pusher,
+// e.g. its endpoint.
+func WithLightsailOptions(optFns ...func(*lightsail.Options)) PusherOption {
+	return cs.WithLightsailOptions(optFns...)
+}
+
+// WithLogger makes the pusher log warnings about the images it pushes
+// to out, and diagnostics too when debug is set.
+func WithLogger(out *log.Logger, debug bool) PusherOption {
+	level := internal.LevelInfo
+	if debug {
+		level = internal.LevelDebug
+	}
+	return cs.WithLogger(internal.NewLogger(out, level))
+}
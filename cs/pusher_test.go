@@ -0,0 +1,28 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestNewPusherInvalidEngineConfig(t *testing.T) {
+	ctx := context.Background()
+	for _, cfg := range []DockerEngineConfig{
+		{Host: "bogus host"},
+		{APIVersion: "latest"},
+	} {
+		buf := new(bytes.Buffer)
+		p, err := NewPusher(ctx, aws.Config{}, WithEngineConfig(cfg), WithLogger(log.New(buf, "", 0), true))
+		if err == nil || p != nil || !strings.Contains(err.Error(), "invalid Docker") {
+			t.Errorf("%+v: got pusher %v and err: %v", cfg, p, err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+)
+
+// WriteContainerAPIMetadata writes all entries returned by
+// GetContainerAPIMetadata to w as indented JSON.
+func WriteContainerAPIMetadata(ctx context.Context, w io.Writer, g ContainerAPIMetadataGetter) error {
+	res, err := g.GetContainerAPIMetadata(ctx, &lightsail.GetContainerAPIMetadataInput{})
+	if err != nil {
+		return fmt.Errorf("could not get container API metadata: %w", err)
+	}
+
+	md := res.Metadata
+	if md == nil {
+		md = []map[string]string{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(md)
+}
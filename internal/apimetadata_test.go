@@ -0,0 +1,59 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+)
+
+type fakeMetadata []map[string]string
+
+func (f fakeMetadata) GetContainerAPIMetadata(
+	context.Context,
+	*lightsail.GetContainerAPIMetadataInput,
+	...func(*lightsail.Options),
+) (*lightsail.GetContainerAPIMetadataOutput, error) {
+	if f == nil {
+		return nil, errors.New("no metadata for you")
+	}
+	return &lightsail.GetContainerAPIMetadataOutput{Metadata: f}, nil
+}
+
+func ExampleWriteContainerAPIMetadata() {
+	ctx := context.Background()
+
+	if err := WriteContainerAPIMetadata(ctx, os.Stdout, fakeMetadata{
+		{"name": "lightsailctlVersion", "value": "v1.0.6"},
+		{"name": "someFutureKey", "value": "42"},
+	}); err != nil {
+		fmt.Println(err)
+	}
+
+	if err := WriteContainerAPIMetadata(ctx, os.Stdout, fakeMetadata{}); err != nil {
+		fmt.Println(err)
+	}
+
+	if err := WriteContainerAPIMetadata(ctx, os.Stdout, fakeMetadata(nil)); err != nil {
+		fmt.Println(err)
+	}
+
+	// Output:
+	// [
+	//   {
+	//     "name": "lightsailctlVersion",
+	//     "value": "v1.0.6"
+	//   },
+	//   {
+	//     "name": "someFutureKey",
+	//     "value": "42"
+	//   }
+	// ]
+	// []
+	// could not get container API metadata: no metadata for you
+}
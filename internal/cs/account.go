@@ -0,0 +1,76 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/lightsailctl/internal"
+)
+
+// AccountResolver tells which AWS account images are pushed to,
+// so that pushes to the wrong account are noticed right away.
+type AccountResolver interface {
+	// AccountID returns the account ID, or "" if it can't be found out.
+	AccountID(ctx context.Context) string
+}
+
+// CallerIdentityGetter is the part of STS client that AccountResolver
+// of NewAccountResolver needs.
+type CallerIdentityGetter interface {
+	GetCallerIdentity(
+		context.Context,
+		*sts.GetCallerIdentityInput,
+		...func(*sts.Options),
+	) (*sts.GetCallerIdentityOutput, error)
+}
+
+// stsAccountResolver calls STS GetCallerIdentity when the account is
+// first needed, and then remembers the outcome for the rest of the
+// invocation, even if the call failed.
+type stsAccountResolver struct {
+	cig     CallerIdentityGetter
+	logger  *internal.Logger
+	once    sync.Once
+	account string
+}
+
+// NewAccountResolver returns a resolver of the account of the credentials
+// that cig is configured with. The account is only looked up when it's
+// first needed, and a failure to look it up is merely logged.
+func NewAccountResolver(cig CallerIdentityGetter, logger *internal.Logger) AccountResolver {
+	return &stsAccountResolver{cig: cig, logger: logger}
+}
+
+func (r *stsAccountResolver) AccountID(ctx context.Context) string {
+	r.once.Do(func() {
+		out, err := r.cig.GetCallerIdentity(ctx, new(sts.GetCallerIdentityInput))
+		if err != nil {
+			r.logger.Debugf("could not find out the AWS account: %v", err)
+			return
+		}
+		r.account = aws.ToString(out.Account)
+	})
+	return r.account
+}
+
+// destination tells where in.Service is, e.g.
+// " in account 123456789012, region us-west-2", as far as it's known.
+func destination(ctx context.Context, in *PushImageInput) (account, where string) {
+	if in.AccountResolver != nil {
+		account = in.AccountResolver.AccountID(ctx)
+	}
+	switch {
+	case account != "" && in.Region != "":
+		where = " in account " + account + ", region " + in.Region
+	case account != "":
+		where = " in account " + account
+	case in.Region != "":
+		where = " in region " + in.Region
+	}
+	return account, where
+}
@@ -0,0 +1,76 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type fakeCallerIdentityGetter struct {
+	fail bool
+	log  []string
+}
+
+func (f *fakeCallerIdentityGetter) GetCallerIdentity(
+	context.Context,
+	*sts.GetCallerIdentityInput,
+	...func(*sts.Options),
+) (*sts.GetCallerIdentityOutput, error) {
+	op := "get caller identity"
+	f.log = append(f.log, op)
+	if f.fail {
+		return nil, fmt.Errorf("failed: %s", op)
+	}
+	return &sts.GetCallerIdentityOutput{Account: aws.String("111122223333")}, nil
+}
+
+func ExamplePushImages_account() {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611796436, 0) }
+	testRngReader = strings.NewReader("abcdefghabcdefgh")
+
+	ctx := context.Background()
+	cig := &fakeCallerIdentityGetter{}
+	accounts := NewAccountResolver(cig, discardLog)
+	in := &PushImagesInput{Images: []*PushImageInput{
+		{Service: "doge", Image: "web:latest", Label: "web", Region: "us-west-2", AccountResolver: accounts},
+		{Service: "doge", Image: "api:latest", Label: "api", Region: "us-west-2", AccountResolver: accounts},
+	}}
+	res, err := PushImages(ctx, discardLog, in, &fakeLightsailImageOperator{}, &fakeImageOperator{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("result: %s, %s\n", res[0].Account, res[0].Region)
+	fmt.Println("sts api call log:", cig.log)
+
+	// The push goes on without the account when it can't be found out.
+	testRngReader = strings.NewReader("abcdefgh")
+	cig = &fakeCallerIdentityGetter{fail: true}
+	img := &PushImageInput{Service: "doge", Image: "web:latest", Label: "web", Region: "us-west-2",
+		AccountResolver: NewAccountResolver(cig, discardLog)}
+	if _, err := PushImage(ctx, discardLog, img, &fakeLightsailImageOperator{}, &fakeImageOperator{}); err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// Digest: sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa
+	// Image "web:latest" registered in account 111122223333, region us-west-2.
+	// Refer to this image as ":doge.web.12345" in deployments.
+	// Digest: sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa
+	// Image "api:latest" registered in account 111122223333, region us-west-2.
+	// Refer to this image as ":doge.api.12345" in deployments.
+	// result: 111122223333, us-west-2
+	// sts api call log: [get caller identity]
+	// Digest: sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa
+	// Image "web:latest" registered in region us-west-2.
+	// Refer to this image as ":doge.web.12345" in deployments.
+}
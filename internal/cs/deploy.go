@@ -0,0 +1,202 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail/types"
+	"github.com/aws/lightsailctl/internal"
+)
+
+type DeployInput struct {
+	Service string
+	// Containers and PublicEndpoint are the same as the ones
+	// of CreateContainerServiceDeployment.
+	Containers     map[string]types.Container
+	PublicEndpoint *types.EndpointRequest
+
+	// Output is told how the deployment goes, os.Stdout when it's nil.
+	Output io.Writer
+}
+
+type Deployer interface {
+	ServiceLister
+	CreateContainerServiceDeployment(
+		context.Context,
+		*lightsail.CreateContainerServiceDeploymentInput,
+		...func(*lightsail.Options),
+	) (*lightsail.CreateContainerServiceDeploymentOutput, error)
+}
+
+// UseReferences replaces the image of each container that names one of
+// the images that were pushed, e.g. "nginx:latest", with the reference
+// that it was registered as, so that the deployment runs what was pushed.
+func (in *DeployInput) UseReferences(results []*PushImageResult) {
+	for name, c := range in.Containers {
+		image := normalizeImageRef(aws.ToString(c.Image))
+		for _, res := range results {
+			if res != nil && res.Image == image {
+				c.Image = aws.String(res.Reference)
+				in.Containers[name] = c
+				break
+			}
+		}
+	}
+}
+
+const (
+	// deploymentTimeout is how long Deploy waits for a deployment
+	// to become active, Lightsail gives up on failing ones sooner.
+	deploymentTimeout = 30 * time.Minute
+	// deploymentPollInterval is how often Deploy checks
+	// the state of the deployment.
+	deploymentPollInterval = 10 * time.Second
+)
+
+// Deploy creates a deployment of Lightsail container service and waits
+// until it's active, or until it fails or the wait times out,
+// and tells its final state. State transitions of the deployment
+// are logged at debug level.
+func Deploy(ctx context.Context, logger *internal.Logger, in *DeployInput, d Deployer) error {
+	out, err := d.CreateContainerServiceDeployment(ctx, &lightsail.CreateContainerServiceDeploymentInput{
+		ServiceName:    &in.Service,
+		Containers:     in.Containers,
+		PublicEndpoint: in.PublicEndpoint,
+	})
+	if err != nil {
+		return err
+	}
+	if out.ContainerService == nil || out.ContainerService.NextDeployment == nil {
+		return fmt.Errorf("service %q has no new deployment after it was created", in.Service)
+	}
+	version := aws.ToInt32(out.ContainerService.NextDeployment.Version)
+	fmt.Fprintf(outputOrStdout(in.Output), "Deployment %d of service %q is created.\n", version, in.Service)
+
+	state := out.ContainerService.NextDeployment.State
+	logger.Debugf("deployment %d of service %q is %s", version, in.Service, state)
+	deadline := now().Add(deploymentTimeout)
+	for {
+		switch state {
+		case types.ContainerServiceDeploymentStateActive:
+			fmt.Fprintf(outputOrStdout(in.Output), "Deployment %d of service %q is %s.\n", version, in.Service, state)
+			return nil
+		case types.ContainerServiceDeploymentStateFailed, types.ContainerServiceDeploymentStateInactive:
+			return fmt.Errorf("deployment %d of service %q is %s", version, in.Service, state)
+		}
+		if !now().Before(deadline) {
+			return fmt.Errorf("deployment %d of service %q is still %s after %v", version, in.Service, state, deploymentTimeout)
+		}
+		if err := sleep(ctx, deploymentPollInterval); err != nil {
+			return err
+		}
+
+		out, err := d.GetContainerServices(ctx, &lightsail.GetContainerServicesInput{ServiceName: &in.Service})
+		if err != nil {
+			return err
+		}
+		if len(out.ContainerServices) == 0 {
+			return fmt.Errorf("service %q is not found", in.Service)
+		}
+		s := out.ContainerServices[0]
+		var dep *types.ContainerServiceDeployment
+		for _, c := range []*types.ContainerServiceDeployment{s.NextDeployment, s.CurrentDeployment} {
+			if c != nil && aws.ToInt32(c.Version) == version {
+				dep = c
+				break
+			}
+		}
+		if dep == nil {
+			// Another deployment has replaced it.
+			return fmt.Errorf("deployment %d of service %q is superseded", version, in.Service)
+		}
+		if dep.State != state {
+			logger.Debugf("deployment %d of service %q is %s", version, in.Service, dep.State)
+			state = dep.State
+		}
+	}
+}
+
+// UpdateImageInput describes the image of a container to replace
+// in the current deployment of a service, see UpdateDeploymentImage.
+type UpdateImageInput struct {
+	Service string
+	// Container is the name of the container whose image is replaced
+	// by Image, e.g. ":doge.www.12", in a new deployment.
+	Container string
+	Image     string
+
+	// DryRun stops short of creating the deployment, after the current
+	// one is checked, and tells what would be done instead.
+	DryRun bool
+
+	// Output is told what's done, or what would be done in dry runs,
+	// os.Stdout when it's nil.
+	Output io.Writer
+}
+
+// UpdateDeploymentImage deploys the current deployment of a Lightsail
+// container service again, with in.Image as the image of in.Container,
+// and waits until the new deployment is active, see Deploy.
+// It fails when the service has no current deployment or when the
+// container isn't in it.
+func UpdateDeploymentImage(ctx context.Context, logger *internal.Logger, in *UpdateImageInput, d Deployer) error {
+	out, err := d.GetContainerServices(ctx, &lightsail.GetContainerServicesInput{ServiceName: &in.Service})
+	if err != nil {
+		return err
+	}
+	if len(out.ContainerServices) == 0 {
+		return fmt.Errorf("service %q is not found", in.Service)
+	}
+	cur := out.ContainerServices[0].CurrentDeployment
+	if cur == nil {
+		return fmt.Errorf("service %q has no current deployment to update", in.Service)
+	}
+	c, ok := cur.Containers[in.Container]
+	if !ok {
+		names := make([]string, 0, len(cur.Containers))
+		for name := range cur.Containers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("container %q is not in deployment %d of service %q, its containers are %q",
+			in.Container, aws.ToInt32(cur.Version), in.Service, names)
+	}
+	old := aws.ToString(c.Image)
+	if in.DryRun {
+		fmt.Fprintf(outputOrStdout(in.Output), "Dry run: a deployment of service %q would be created with image %q of container %q instead of %q.\n",
+			in.Service, in.Image, in.Container, old)
+		return nil
+	}
+
+	containers := make(map[string]types.Container, len(cur.Containers))
+	for name, c := range cur.Containers {
+		containers[name] = c
+	}
+	c.Image = aws.String(in.Image)
+	containers[in.Container] = c
+	logger.Debugf("deploying service %q like deployment %d, but with image %q of container %q instead of %q",
+		in.Service, aws.ToInt32(cur.Version), in.Image, in.Container, old)
+
+	var endpoint *types.EndpointRequest
+	if ep := cur.PublicEndpoint; ep != nil {
+		endpoint = &types.EndpointRequest{
+			ContainerName: ep.ContainerName,
+			ContainerPort: ep.ContainerPort,
+			HealthCheck:   ep.HealthCheck,
+		}
+	}
+	return Deploy(ctx, logger, &DeployInput{
+		Service:        in.Service,
+		Containers:     containers,
+		PublicEndpoint: endpoint,
+		Output:         in.Output,
+	}, d)
+}
@@ -0,0 +1,221 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail/types"
+	"github.com/aws/lightsailctl/internal"
+)
+
+// fakeDeployer creates deployment 7, which goes through states,
+// one state per GetContainerServices call. The first call returns
+// current as the current deployment instead, when it's set.
+type fakeDeployer struct {
+	states  []types.ContainerServiceDeploymentState
+	current *types.ContainerServiceDeployment
+	created *lightsail.CreateContainerServiceDeploymentInput
+	log     []string
+}
+
+func (f *fakeDeployer) deployment() *types.ContainerServiceDeployment {
+	return &types.ContainerServiceDeployment{Version: aws.Int32(7), State: f.states[0]}
+}
+
+func (f *fakeDeployer) CreateContainerServiceDeployment(
+	_ context.Context,
+	in *lightsail.CreateContainerServiceDeploymentInput,
+	_ ...func(*lightsail.Options),
+) (*lightsail.CreateContainerServiceDeploymentOutput, error) {
+	op := fmt.Sprintf("deploy (%s, %d containers)", aws.ToString(in.ServiceName), len(in.Containers))
+	f.log = append(f.log, op)
+	if len(f.states) == 0 {
+		return nil, fmt.Errorf("failed: %s", op)
+	}
+	f.created = in
+	return &lightsail.CreateContainerServiceDeploymentOutput{ContainerService: &types.ContainerService{
+		NextDeployment: f.deployment(),
+	}}, nil
+}
+
+func (f *fakeDeployer) GetContainerServices(
+	_ context.Context,
+	in *lightsail.GetContainerServicesInput,
+	_ ...func(*lightsail.Options),
+) (*lightsail.GetContainerServicesOutput, error) {
+	f.log = append(f.log, "get "+aws.ToString(in.ServiceName))
+	if f.current != nil {
+		s := types.ContainerService{ContainerServiceName: in.ServiceName, CurrentDeployment: f.current}
+		f.current = nil
+		return &lightsail.GetContainerServicesOutput{ContainerServices: []types.ContainerService{s}}, nil
+	}
+	if len(f.states) > 1 {
+		f.states = f.states[1:]
+	}
+	s := types.ContainerService{ContainerServiceName: in.ServiceName}
+	if f.states[0] == types.ContainerServiceDeploymentStateActivating {
+		s.NextDeployment = f.deployment()
+	} else {
+		s.CurrentDeployment = f.deployment()
+	}
+	return &lightsail.GetContainerServicesOutput{ContainerServices: []types.ContainerService{s}}, nil
+}
+
+func ExampleDeploy() {
+	defer func() {
+		testNow, testSleep = nil, nil
+	}()
+	clock := time.Unix(1611796436, 0)
+	testNow = func() time.Time { return clock }
+	testSleep = func(_ context.Context, d time.Duration) error {
+		clock = clock.Add(d)
+		return nil
+	}
+
+	ctx := context.Background()
+	logger := internal.NewLogger(log.New(os.Stdout, "debug: ", 0), internal.LevelDebug)
+	in := &DeployInput{
+		Service:    "doge",
+		Containers: map[string]types.Container{"web": {Image: aws.String(":doge.www.12")}},
+	}
+	for _, states := range [][]types.ContainerServiceDeploymentState{
+		{"ACTIVATING", "ACTIVATING", "ACTIVE"},
+		{"ACTIVATING", "FAILED"},
+		{"ACTIVATING"},
+		nil,
+	} {
+		d := &fakeDeployer{states: states}
+		if err := Deploy(ctx, logger, in, d); err != nil {
+			fmt.Println(err)
+		}
+		fmt.Println("lightsail api calls:", len(d.log))
+	}
+
+	// Output:
+	// Deployment 7 of service "doge" is created.
+	// debug: deployment 7 of service "doge" is ACTIVATING
+	// debug: deployment 7 of service "doge" is ACTIVE
+	// Deployment 7 of service "doge" is ACTIVE.
+	// lightsail api calls: 3
+	// Deployment 7 of service "doge" is created.
+	// debug: deployment 7 of service "doge" is ACTIVATING
+	// debug: deployment 7 of service "doge" is FAILED
+	// deployment 7 of service "doge" is FAILED
+	// lightsail api calls: 2
+	// Deployment 7 of service "doge" is created.
+	// debug: deployment 7 of service "doge" is ACTIVATING
+	// deployment 7 of service "doge" is still ACTIVATING after 30m0s
+	// lightsail api calls: 181
+	// failed: deploy (doge, 1 containers)
+	// lightsail api calls: 1
+}
+
+func TestDeployInputUseReferences(t *testing.T) {
+	in := &DeployInput{Containers: map[string]types.Container{
+		"web":   {Image: aws.String("docker.io/library/nginx:latest")},
+		"api":   {Image: aws.String("api:2")},
+		"cache": {Image: aws.String("redis:7")},
+	}}
+	in.UseReferences([]*PushImageResult{
+		{Image: "nginx:latest", Reference: ":doge.web.3"},
+		nil,
+		{Image: "api:2", Reference: ":doge.api.8"},
+	})
+	got := map[string]string{}
+	for name, c := range in.Containers {
+		got[name] = aws.ToString(c.Image)
+	}
+	want := map[string]string{"web": ":doge.web.3", "api": ":doge.api.8", "cache": "redis:7"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got images %v, want %v", got, want)
+	}
+}
+
+func ExampleUpdateDeploymentImage() {
+	defer func() {
+		testNow, testSleep = nil, nil
+	}()
+	clock := time.Unix(1611796436, 0)
+	testNow = func() time.Time { return clock }
+	testSleep = func(_ context.Context, d time.Duration) error {
+		clock = clock.Add(d)
+		return nil
+	}
+
+	ctx := context.Background()
+	current := &types.ContainerServiceDeployment{
+		Version: aws.Int32(6),
+		State:   types.ContainerServiceDeploymentStateActive,
+		Containers: map[string]types.Container{
+			"web":   {Image: aws.String(":doge.www.11"), Ports: map[string]types.ContainerServiceProtocol{"80": "HTTP"}},
+			"cache": {Image: aws.String("redis:7")},
+		},
+		PublicEndpoint: &types.ContainerServiceEndpoint{ContainerName: aws.String("web"), ContainerPort: aws.Int32(80)},
+	}
+	for _, in := range []*UpdateImageInput{
+		{Service: "doge", Container: "web", Image: ":doge.www.12"},
+		{Service: "doge", Container: "api", Image: ":doge.api.3"},
+		{Service: "doge", Container: "web", Image: ":doge.www.12", DryRun: true},
+	} {
+		d := &fakeDeployer{states: []types.ContainerServiceDeploymentState{"ACTIVATING", "ACTIVE"}, current: current}
+		if err := UpdateDeploymentImage(ctx, discardLog, in, d); err != nil {
+			fmt.Println(err)
+		}
+		if c := d.created; c != nil {
+			fmt.Printf("deployed: web %s with ports %v, cache %s, endpoint %s:%d\n",
+				aws.ToString(c.Containers["web"].Image), c.Containers["web"].Ports,
+				aws.ToString(c.Containers["cache"].Image),
+				aws.ToString(c.PublicEndpoint.ContainerName), aws.ToInt32(c.PublicEndpoint.ContainerPort))
+		}
+	}
+	fmt.Println("current deployment:", aws.ToString(current.Containers["web"].Image))
+
+	d := &fakeDeployer{states: []types.ContainerServiceDeploymentState{"ACTIVATING"}}
+	if err := UpdateDeploymentImage(ctx, discardLog, &UpdateImageInput{Service: "doge", Container: "web"}, d); err != nil {
+		fmt.Println(err)
+	}
+
+	// Output:
+	// Deployment 7 of service "doge" is created.
+	// Deployment 7 of service "doge" is ACTIVE.
+	// deployed: web :doge.www.12 with ports map[80:HTTP], cache redis:7, endpoint web:80
+	// container "api" is not in deployment 6 of service "doge", its containers are ["cache" "web"]
+	// Dry run: a deployment of service "doge" would be created with image ":doge.www.12" of container "web" instead of ":doge.www.11".
+	// current deployment: :doge.www.11
+	// service "doge" has no current deployment to update
+}
+
+func TestUpdateDeploymentImageOutput(t *testing.T) {
+	current := &types.ContainerServiceDeployment{
+		Version:    aws.Int32(6),
+		State:      types.ContainerServiceDeploymentStateActive,
+		Containers: map[string]types.Container{"web": {Image: aws.String(":doge.www.11")}},
+	}
+	for _, dryRun := range []bool{true, false} {
+		out := new(bytes.Buffer)
+		in := &UpdateImageInput{Service: "doge", Container: "web", Image: ":doge.www.12", DryRun: dryRun, Output: out}
+		d := &fakeDeployer{states: []types.ContainerServiceDeploymentState{"ACTIVE"}, current: current}
+		if err := UpdateDeploymentImage(context.Background(), discardLog, in, d); err != nil {
+			t.Fatal(err)
+		}
+		want := "Deployment 7 of service \"doge\" is created.\nDeployment 7 of service \"doge\" is ACTIVE.\n"
+		if dryRun {
+			want = "Dry run: a deployment of service \"doge\" would be created with image \":doge.www.12\" " +
+				"of container \"web\" instead of \":doge.www.11\".\n"
+		}
+		if out.String() != want {
+			t.Errorf("dry run %v: got output: %q, want: %q", dryRun, out, want)
+		}
+	}
+}
@@ -8,22 +8,42 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/aws/lightsailctl/internal"
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/versions"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/moby/term"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // DockerEngine defines a subset of client-side
 // operations against local Docker Engine, relevant to lightsailctl.
 type DockerEngine struct {
-	c *client.Client
+	c              *client.Client
+	progress       io.Writer
+	progressLog    io.Writer
+	progressEvents io.Writer
+	events         *internal.Events
+	logger         *internal.Logger
+	compression    string
+
+	maxConcurrentUploads int
 }
 
 // RemoteImage combines remote server auth details, address
@@ -32,19 +52,300 @@ type DockerEngine struct {
 type RemoteImage struct {
 	registry.AuthConfig
 	Tag string
+
+	// Platform selects what to push of a multi-platform image:
+	// "os/arch[/variant]", or "auto" for the platform of the local image.
+	// Docker Engine decides when it's empty.
+	Platform string
 }
 
 func (r *RemoteImage) Ref() string {
 	return r.ServerAddress + ":" + r.Tag
 }
 
-func NewDockerEngine(ctx context.Context) (*DockerEngine, error) {
-	dc, err := client.NewClientWithOpts(client.FromEnv)
+// DockerEngineConfig customizes how DockerEngine connects to Docker Engine.
+// The zero value means that DOCKER_HOST and related environment variables
+// are used the same way as docker CLI does.
+type DockerEngineConfig struct {
+	// Host is Docker Engine's address, e.g. "unix:///run/user/1000/docker.sock"
+	// or "tcp://10.0.0.5:2376". It overrides DOCKER_HOST when specified.
+	Host string
+	// Progress receives push progress for users, os.Stderr when it's nil.
+	// It's displayed like in a terminal when it is one.
+	Progress io.Writer
+	// ProgressLog, when set, receives a plain copy of push progress
+	// that's displayed on Progress.
+	ProgressLog io.Writer
+	// ProgressEvents, when set, receives push progress as JSON lines,
+	// one ProgressEvent per line, instead of it being displayed on Progress.
+	ProgressEvents io.Writer
+	// Events, when set, receives push progress as EventPushProgress
+	// events, instead of it being displayed on Progress. ProgressEvents
+	// still receives it too.
+	Events *internal.Events
+	// Proxy, when set, is the HTTP proxy for connecting to a tcp Docker
+	// host, instead of the one from HTTP(S)_PROXY environment variables.
+	// Unix socket and named pipe connections never go through a proxy.
+	Proxy *url.URL
+	// APIVersion, when set, pins Docker Engine API version, e.g. "1.41",
+	// instead of it being negotiated with Docker Engine.
+	APIVersion string
+	// Logger receives warnings about the images being pushed.
+	Logger *internal.Logger
+	// MaxConcurrentUploads, when positive, is how many layers of an image
+	// are meant to be uploaded at the same time. Docker Engine API has no
+	// per-push setting for it, so the daemon's own max-concurrent-uploads
+	// (5 by default) applies regardless, and this is only logged.
+	MaxConcurrentUploads int
+	// ResponseHeaderTimeout limits how long Docker Engine may take to
+	// start responding to a request, defaultResponseHeaderTimeout when
+	// it's 0. It doesn't limit the response itself, such as the progress
+	// of a long push, which takes as long as the upload does.
+	ResponseHeaderTimeout time.Duration
+	// Timeout, when positive, limits how long a request to Docker Engine
+	// may take in all, including the response. There's no such limit by
+	// default, so that pushes of multi-GB images aren't cut short.
+	Timeout time.Duration
+	// Compression, when set, is the compression of image layers that
+	// pushes ask for, "gzip" or "zstd". Docker Engine decides when it's
+	// empty. Unsupported ones fall back to gzip, see layerCompression.
+	Compression string
+}
+
+func NewDockerEngine(ctx context.Context, cfg DockerEngineConfig) (*DockerEngine, error) {
+	opts := []client.Opt{client.FromEnv}
+	if cfg.Host != "" {
+		if _, err := client.ParseHostURL(cfg.Host); err != nil {
+			return nil, fmt.Errorf("invalid Docker host %q: %w", cfg.Host, err)
+		}
+		opts = append(opts, client.WithHost(cfg.Host))
+	}
+	if cfg.Proxy != nil {
+		opts = append(opts, withProxy(cfg.Proxy))
+	}
+	if cfg.APIVersion != "" {
+		if !apiVersionRE.MatchString(cfg.APIVersion) {
+			return nil, fmt.Errorf("invalid Docker API version %q: it must look like 1.41", cfg.APIVersion)
+		}
+		opts = append(opts, client.WithVersion(cfg.APIVersion))
+	}
+	if cfg.MaxConcurrentUploads < 0 {
+		return nil, fmt.Errorf("invalid max concurrent uploads %d: it must be positive", cfg.MaxConcurrentUploads)
+	}
+	if cfg.ResponseHeaderTimeout < 0 || cfg.Timeout < 0 {
+		return nil, fmt.Errorf("invalid Docker timeouts %v and %v: they must not be negative", cfg.ResponseHeaderTimeout, cfg.Timeout)
+	}
+	responseHeaderTimeout := cfg.ResponseHeaderTimeout
+	if responseHeaderTimeout == 0 {
+		responseHeaderTimeout = defaultResponseHeaderTimeout
+	}
+	opts = append(opts, withTimeouts(responseHeaderTimeout, cfg.Timeout))
+	switch cfg.Compression {
+	case "", "gzip", "zstd":
+	default:
+		return nil, fmt.Errorf("invalid compression %q: it must be either \"gzip\" or \"zstd\"", cfg.Compression)
+	}
+	dc, err := client.NewClientWithOpts(opts...)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not create Docker client: %w", err)
+	}
+	if err := checkSocket(dc.DaemonHost()); err != nil {
+		dc.Close()
+		return nil, &stepError{step: ErrEngineUnavailable, err: err}
+	}
+
+	var p daemonPinger = dc
+	if testDaemonPinger != nil {
+		p = testDaemonPinger
+	}
+	ping, err := pingDaemon(ctx, p, dc.DaemonHost())
+	if err != nil {
+		dc.Close()
+		return nil, &stepError{step: ErrEngineUnavailable, err: err}
+	}
+	// This is a no-op when the version is pinned.
+	dc.NegotiateAPIVersionPing(ping)
+	progress := cfg.Progress
+	if progress == nil {
+		progress = os.Stderr
+	}
+	return &DockerEngine{
+		c:              dc,
+		progress:       progress,
+		progressLog:    cfg.ProgressLog,
+		progressEvents: cfg.ProgressEvents,
+		events:         cfg.Events,
+		logger:         cfg.Logger,
+		compression:    cfg.Compression,
+
+		maxConcurrentUploads: cfg.MaxConcurrentUploads,
+	}, nil
+}
+
+var apiVersionRE = regexp.MustCompile(`^[1-9][0-9]*\.[0-9]+$`)
+
+// daemonPinger is what pingDaemon needs from Docker client.
+type daemonPinger interface {
+	Ping(ctx context.Context) (types.Ping, error)
+}
+
+var testDaemonPinger daemonPinger
+
+// pingTimeout limits how long pingDaemon waits for Docker Engine,
+// it may be changed by tests.
+var pingTimeout = 5 * time.Second
+
+// pingDaemon makes sure that Docker Engine at host responds, so that
+// a daemon that isn't running is reported clearly and right away,
+// rather than by whatever operation happens to be first.
+func pingDaemon(ctx context.Context, p daemonPinger, host string) (types.Ping, error) {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	ping, err := p.Ping(ctx)
+	switch {
+	case client.IsErrConnectionFailed(err):
+		// Docker client's own message doesn't say more than this.
+		return types.Ping{}, fmt.Errorf("cannot connect to Docker daemon at %s; is Docker running?", host)
+	case err != nil:
+		return types.Ping{}, fmt.Errorf("cannot connect to Docker daemon at %s; is Docker running? (%v)", host, err)
+	}
+	return ping, nil
+}
+
+// withProxy makes Docker client connect to a tcp host through proxy.
+// It must come after the options that set the host, because these
+// reset the proxy of the client's transport.
+func withProxy(proxy *url.URL) client.Opt {
+	return func(c *client.Client) error {
+		if !strings.HasPrefix(c.DaemonHost(), "tcp://") {
+			return nil
+		}
+		tr, ok := c.HTTPClient().Transport.(*http.Transport)
+		if !ok {
+			return fmt.Errorf("cannot apply proxy to transport: %T", c.HTTPClient().Transport)
+		}
+		tr.Proxy = http.ProxyURL(proxy)
+		return nil
+	}
+}
+
+// defaultResponseHeaderTimeout is long enough for Docker Engine to
+// get going with a request, e.g. to look up the image and the auth of
+// its registry before a push, and short enough not to wait for long
+// on a daemon that's stuck.
+const defaultResponseHeaderTimeout = 2 * time.Minute
+
+// withTimeouts limits how long Docker client waits for the response
+// headers of Docker Engine, and for whole requests when timeout is
+// positive. Like withProxy, it must come after the options that set
+// the host, because these reconfigure the client's transport.
+func withTimeouts(responseHeader, timeout time.Duration) client.Opt {
+	return func(c *client.Client) error {
+		tr, ok := c.HTTPClient().Transport.(*http.Transport)
+		if !ok {
+			return fmt.Errorf("cannot apply timeouts to transport: %T", c.HTTPClient().Transport)
+		}
+		tr.ResponseHeaderTimeout = responseHeader
+		return client.WithTimeout(timeout)(c)
+	}
+}
+
+// checkSocket makes sure that a unix socket Docker host can be
+// connected to. This is common to get wrong when lightsailctl runs
+// in a container with the host's Docker socket mounted, and Docker
+// client's own errors are not very helpful in diagnosing that.
+func checkSocket(host string) error {
+	path, ok := strings.CutPrefix(host, "unix://")
+	if !ok {
+		return nil
+	}
+
+	var sp socketProber = osSocketProber{}
+	if testSocketProber != nil {
+		sp = testSocketProber
+	}
+
+	err := func() error {
+		fi, err := sp.Stat(path)
+		if err != nil {
+			return err
+		}
+		if fi.Mode().Type() != fs.ModeSocket {
+			return fmt.Errorf("%s is not a socket", path)
+		}
+		return sp.Dial(path)
+	}()
+	if err != nil {
+		return fmt.Errorf("Docker socket at %s is not accessible: %v; check mount and permissions", path, err)
+	}
+	return nil
+}
+
+// socketProber is what checkSocket needs from the file system.
+type socketProber interface {
+	Stat(path string) (fs.FileInfo, error)
+	Dial(path string) error
+}
+
+type osSocketProber struct{}
+
+func (osSocketProber) Stat(path string) (fs.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (osSocketProber) Dial(path string) error {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+var testSocketProber socketProber
+
+// NewPodmanEngine returns an engine that talks to Podman
+// via its Docker-compatible REST API, so the same push logic,
+// including registry auth, applies to both.
+//
+// Unless cfg.Host is given, Podman's socket is located by
+// CONTAINER_HOST environment variable or Podman's defaults,
+// DOCKER_HOST is not consulted.
+func NewPodmanEngine(ctx context.Context, cfg DockerEngineConfig) (*DockerEngine, error) {
+	if cfg.Host == "" {
+		cfg.Host = podmanHost()
+	}
+	return NewDockerEngine(ctx, cfg)
+}
+
+// podmanHost returns the address of the Podman API socket
+// the same way podman-remote finds it.
+func podmanHost() string {
+	if h := os.Getenv("CONTAINER_HOST"); h != "" {
+		return h
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" && os.Geteuid() != 0 {
+		return "unix://" + filepath.ToSlash(filepath.Join(dir, "podman", "podman.sock"))
 	}
-	dc.NegotiateAPIVersion(ctx)
-	return &DockerEngine{c: dc}, nil
+	return "unix:///run/podman/podman.sock"
+}
+
+// layerCompression returns the compression of image layers that pushes
+// get, or "" when it's left to Docker Engine. Docker Engine API has no
+// per-push choice of it: layers are pushed gzip-compressed, unless the
+// containerd image store keeps them compressed otherwise, so zstd falls
+// back to gzip.
+func (e *DockerEngine) layerCompression() string {
+	if e.compression == "zstd" {
+		e.logger.Debugf("Docker Engine API %s can't push with zstd layer compression, falling back to gzip",
+			e.c.ClientVersion())
+		return "gzip"
+	}
+	return e.compression
+}
+
+// Close releases the connection to Docker Engine.
+func (e *DockerEngine) Close() error {
+	return e.c.Close()
 }
 
 func (e *DockerEngine) TagImage(ctx context.Context, source, target string) error {
@@ -56,34 +357,457 @@ func (e *DockerEngine) UntagImage(ctx context.Context, imageID string) error {
 	return err
 }
 
-func (e *DockerEngine) PushImage(ctx context.Context, remoteImage RemoteImage) (digest string, err error) {
+// InspectImage returns the details of a local image,
+// or *LocalImageNotFoundError if there's no such image.
+func (e *DockerEngine) InspectImage(ctx context.Context, image string) (types.ImageInspect, error) {
+	info, _, err := e.c.ImageInspectWithRaw(ctx, image)
+	if client.IsErrNotFound(err) {
+		return info, &LocalImageNotFoundError{Image: image}
+	}
+	return info, err
+}
+
+// LoadImage loads the images of a tar archive, which is either made by
+// docker save or has an OCI image layout, and returns their references,
+// or IDs for the images that have no name.
+func (e *DockerEngine) LoadImage(ctx context.Context, archive io.Reader) ([]string, error) {
+	res, err := e.c.ImageLoad(ctx, archive, true)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return loadedImages(res.Body)
+}
+
+// loadedImages extracts image references from the messages of image load.
+func loadedImages(in io.Reader) ([]string, error) {
+	var images []string
+	dec := json.NewDecoder(in)
+	for {
+		m := jsonmessage.JSONMessage{}
+		if err := dec.Decode(&m); err == io.EOF {
+			return images, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if m.Error != nil {
+			return nil, m.Error
+		}
+		for _, line := range strings.Split(m.Stream, "\n") {
+			if ref, ok := strings.CutPrefix(line, "Loaded image: "); ok {
+				images = append(images, ref)
+			} else if id, ok := strings.CutPrefix(line, "Loaded image ID: "); ok {
+				images = append(images, id)
+			}
+		}
+	}
+}
+
+// ImageSize returns the size of a local image in bytes.
+func (e *DockerEngine) ImageSize(ctx context.Context, image string) (int64, error) {
+	info, err := e.InspectImage(ctx, image)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// PushSummary tells what pushing an image transferred.
+type PushSummary struct {
+	Digest string
+	// NewLayers is the number of layers that were uploaded,
+	// NewBytes is their size in all.
+	NewLayers int
+	NewBytes  int64
+	// ExistingLayers is the number of layers that the registry had already.
+	ExistingLayers int
+}
+
+func (s PushSummary) String() string {
+	return fmt.Sprintf("Pushed %d new layers (%.1f MiB), %d already present.",
+		s.NewLayers, float64(s.NewBytes)/(1<<20), s.ExistingLayers)
+}
+
+func (e *DockerEngine) PushImage(ctx context.Context, remoteImage RemoteImage) (PushSummary, error) {
 	authBytes, err := json.Marshal(remoteImage.AuthConfig)
 	if err != nil {
-		return "", err
+		return PushSummary{}, err
+	}
+	platform, err := e.pushPlatform(ctx, remoteImage.Ref(), remoteImage.Platform)
+	if err != nil {
+		return PushSummary{}, err
+	}
+	if e.maxConcurrentUploads > 0 {
+		e.logger.Debugf("Docker Engine API %s has no per-push limit of concurrent uploads, "+
+			"so %d is not applied; the daemon's max-concurrent-uploads setting controls it",
+			e.c.ClientVersion(), e.maxConcurrentUploads)
 	}
+	if c := e.layerCompression(); c != "" {
+		e.logger.Debugf("pushing %s with %s layer compression", remoteImage.Ref(), c)
+	}
+	registryAuth := base64.URLEncoding.EncodeToString(authBytes)
 	pushRes, err := e.c.ImagePush(ctx, remoteImage.Ref(), image.PushOptions{
-		RegistryAuth: base64.URLEncoding.EncodeToString(authBytes),
+		RegistryAuth: registryAuth,
+		Platform:     platform,
 	})
 	if err != nil {
-		return "", err
+		return PushSummary{}, e.pushError(ctx, err, remoteImage.Ref(), platform)
 	}
 	defer pushRes.Close()
 
-	termFd, isTerm := term.GetFdInfo(os.Stderr)
-	if err = jsonmessage.DisplayJSONMessagesStream(
-		// Skip statuses that have irrelevant details such as repo address.
-		skipStatuses(pushRes, remoteImage.ServerAddress, remoteImage.Tag),
-		os.Stderr, termFd, isTerm,
-		extractDigest(&digest)); err != nil {
-		return "", err
+	var summary PushSummary
+	// Skip statuses that have irrelevant details such as repo address.
+	progress := skipStatuses(e.logger, countLayers(e.logger, pushRes, &summary), remoteImage.ServerAddress, remoteImage.Tag)
+	var digest string
+	if e.progressEvents != nil || e.events != nil {
+		err = writeProgressEvents(progress, e.emitProgress(), extractDigest(e.logger, &digest))
+	} else {
+		termFd, isTerm := term.GetFdInfo(e.progress)
+		if !isTerm {
+			progress = summarizeProgress(e.logger, progress)
+		}
+		err = displayProgress(progress, e.progress, termFd, isTerm, e.progressLog, extractDigest(e.logger, &digest))
+	}
+	// The display stops at the first error, which would leave the stages
+	// of the progress stream blocked if they weren't stopped too.
+	progress.Close()
+	if err != nil {
+		return PushSummary{}, e.pushError(ctx, err, remoteImage.Ref(), platform)
+	}
+	if digest == "" {
+		if digest, err = e.pushedDigest(ctx, remoteImage, registryAuth); err != nil {
+			return PushSummary{}, err
+		}
+	}
+	summary.Digest = digest
+	return summary, nil
+}
+
+// pushedDigest finds out the digest of a pushed image whose push response
+// doesn't tell it, which happens when the image was already in the registry.
+// The local image knows it when it has the digest in the registry,
+// otherwise the registry is asked.
+func (e *DockerEngine) pushedDigest(ctx context.Context, remoteImage RemoteImage, registryAuth string) (string, error) {
+	info, _, err := e.c.ImageInspectWithRaw(ctx, remoteImage.Ref())
+	if err == nil {
+		for _, rd := range info.RepoDigests {
+			if digest, ok := strings.CutPrefix(rd, remoteImage.ServerAddress+"@"); ok {
+				e.logger.Debugf("push response has no digest, using the one of local image: %s", digest)
+				return digest, nil
+			}
+		}
+	}
+
+	dist, err := e.c.DistributionInspect(ctx, remoteImage.Ref(), registryAuth)
+	if err != nil {
+		return "", fmt.Errorf("image push response does not contain the image digest, "+
+			"and the registry could not be asked for it: %w", err)
 	}
+	digest := dist.Descriptor.Digest.String()
 	if digest == "" {
 		return "", errors.New("image push response does not contain the image digest")
 	}
+	e.logger.Debugf("push response has no digest, using the one in the registry: %s", digest)
 	return digest, nil
 }
 
-func skipStatuses(input io.Reader, s ...string) io.Reader {
+// pushPlatform resolves platform of the image ref for image push options.
+// In "auto" mode, it's the platform of the local image, unless the image
+// has several, or Docker Engine is too old to push a selected platform,
+// in which case its image store can't have multi-platform images anyway.
+// A selected platform that the local image doesn't have is a *platformError,
+// so that nothing is uploaded in vain.
+func (e *DockerEngine) pushPlatform(ctx context.Context, ref, platform string) (*ocispec.Platform, error) {
+	if platform != "auto" {
+		p, err := parsePlatform(platform)
+		if err != nil || p == nil {
+			return p, err
+		}
+		return p, e.checkLocalPlatform(ctx, ref, *p)
+	}
+
+	info, raw, err := e.c.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	// Descriptor is reported by Docker Engine with containerd image store,
+	// but it's not in the API types of the Docker client in use yet.
+	var desc struct {
+		Descriptor *ocispec.Descriptor
+	}
+	if err := json.Unmarshal(raw, &desc); err != nil {
+		return nil, fmt.Errorf("could not read image details: %w", err)
+	}
+	if d := desc.Descriptor; d != nil && isImageIndex(d.MediaType) {
+		return nil, fmt.Errorf("image %q has several platforms, so one of them must be specified, e.g. linux/amd64", ref)
+	}
+
+	if info.Os != "linux" {
+		e.logger.Infof("WARNING: image %q is built for %q operating system, but Lightsail runs Linux containers", ref, info.Os)
+	}
+	if versions.LessThan(e.c.ClientVersion(), "1.46") {
+		return nil, nil
+	}
+	return &ocispec.Platform{OS: info.Os, Architecture: info.Architecture, Variant: info.Variant}, nil
+}
+
+// checkLocalPlatform returns a *platformError if the local image ref
+// is built for another platform than p. Multi-platform images, and
+// those whose platform can't be found out, are left to the push,
+// whose errors pushError tells apart.
+func (e *DockerEngine) checkLocalPlatform(ctx context.Context, ref string, p ocispec.Platform) error {
+	available, err := e.imagePlatforms(ctx, ref)
+	if err != nil {
+		e.logger.Debugf("could not check the platform of image %q before pushing: %v", ref, err)
+		return nil
+	}
+	have, err := parsePlatform(available[0])
+	if err != nil || have == nil {
+		return nil
+	}
+	if platformMatches(p, *have) {
+		return nil
+	}
+	return &platformError{
+		platform:  formatPlatform(p),
+		available: available,
+		err:       fmt.Errorf("local image %q is built for %s", ref, available[0]),
+	}
+}
+
+// platformMatches tells whether an image built for have can be pushed
+// as want. Variants only count when both of them have one.
+func platformMatches(want, have ocispec.Platform) bool {
+	return want.OS == have.OS && want.Architecture == have.Architecture &&
+		(want.Variant == "" || have.Variant == "" || want.Variant == have.Variant)
+}
+
+func isImageIndex(mediaType string) bool {
+	return mediaType == ocispec.MediaTypeImageIndex ||
+		mediaType == "application/vnd.docker.distribution.manifest.list.v2+json"
+}
+
+// parsePlatform parses "os/arch[/variant]", the empty platform is nil.
+func parsePlatform(platform string) (*ocispec.Platform, error) {
+	if platform == "" {
+		return nil, nil
+	}
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") {
+		return nil, fmt.Errorf("invalid platform %q: it must be either \"auto\" or look like linux/amd64 or linux/arm64/v8", platform)
+	}
+	p := &ocispec.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// pushError returns err of pushing ref as *platformError
+// or *rateLimitError, if it's about either, otherwise as is.
+func (e *DockerEngine) pushError(ctx context.Context, err error, ref string, platform *ocispec.Platform) error {
+	if platform != nil && platformRE.MatchString(err.Error()) {
+		pe := &platformError{platform: formatPlatform(*platform), err: err}
+		available, ierr := e.imagePlatforms(ctx, ref)
+		if ierr != nil {
+			e.logger.Debugf("could not find out the platforms of image %q: %v", ref, ierr)
+		}
+		pe.available = available
+		return pe
+	}
+	return checkRateLimit(err)
+}
+
+// imagePlatforms returns the platforms that the local image ref provides.
+// Docker Engine API in use has no way to list the platforms of
+// a multi-platform image, so it fails for those.
+func (e *DockerEngine) imagePlatforms(ctx context.Context, ref string) ([]string, error) {
+	info, raw, err := e.c.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	var desc struct {
+		Descriptor *ocispec.Descriptor
+	}
+	if err := json.Unmarshal(raw, &desc); err != nil {
+		return nil, fmt.Errorf("could not read image details: %w", err)
+	}
+	if d := desc.Descriptor; d != nil && isImageIndex(d.MediaType) {
+		return nil, fmt.Errorf("image %q has several platforms, which can't be listed", ref)
+	}
+	if info.Os == "" || info.Architecture == "" {
+		return nil, fmt.Errorf("image %q has no platform details", ref)
+	}
+	return []string{formatPlatform(ocispec.Platform{OS: info.Os, Architecture: info.Architecture, Variant: info.Variant})}, nil
+}
+
+// formatPlatform formats p as "os/arch[/variant]", the same as parsePlatform parses.
+func formatPlatform(p ocispec.Platform) string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// platformRE matches error messages of Docker Engine about images
+// that don't have the platform selected for a push.
+var platformRE = regexp.MustCompile(`(?i)does not (provide|match) the specified platform|no matching manifest for|platform .* not (found|available)`)
+
+// platformError means that the image doesn't provide the platform
+// that was selected for the push.
+type platformError struct {
+	platform string
+	// available are the platforms of the image, if they're known.
+	available []string
+	err       error
+}
+
+func (e *platformError) Error() string {
+	if len(e.available) == 0 {
+		return fmt.Sprintf("image does not provide %s: %v", e.platform, e.err)
+	}
+	return fmt.Sprintf("image does not provide %s; available: %s", e.platform, strings.Join(e.available, ", "))
+}
+
+func (e *platformError) Unwrap() error {
+	return e.err
+}
+
+// rateLimitRE matches error messages of registries that throttle requests.
+var rateLimitRE = regexp.MustCompile(`(?i)\b429\b|too ?many ?requests|rate ?limit|throttl`)
+
+// rateLimitError means that the registry refused the push
+// because of too many requests.
+type rateLimitError struct {
+	err error
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("the registry is limiting the rate of requests, wait a few minutes and push again: %v", e.err)
+}
+
+func (e *rateLimitError) Unwrap() error {
+	return e.err
+}
+
+// checkRateLimit returns err as *rateLimitError if it's about
+// throttling, whether it comes from Docker Engine's API response
+// or from the push progress stream, otherwise err is returned as is.
+func checkRateLimit(err error) error {
+	var je *jsonmessage.JSONError
+	if errors.As(err, &je) && je.Code == http.StatusTooManyRequests || rateLimitRE.MatchString(err.Error()) {
+		return &rateLimitError{err: err}
+	}
+	return err
+}
+
+// displayProgress displays the JSON message stream in on out, the same
+// way jsonmessage.DisplayJSONMessagesStream does, and also copies it to
+// progressLog, if it's not nil. The copy is always plain text: the terminal
+// specific rendering, if any, is only applied to out.
+func displayProgress(
+	in io.Reader,
+	out io.Writer,
+	outFd uintptr,
+	outIsTerm bool,
+	progressLog io.Writer,
+	auxCallback func(jsonmessage.JSONMessage),
+) error {
+	switch {
+	case progressLog == nil:
+		return jsonmessage.DisplayJSONMessagesStream(in, out, outFd, outIsTerm, auxCallback)
+	case !outIsTerm:
+		return jsonmessage.DisplayJSONMessagesStream(in, io.MultiWriter(out, progressLog), outFd, false, auxCallback)
+	}
+
+	// The terminal and the log need different renderings, so the stream
+	// is rendered twice, the log copy being fed from the terminal one.
+	pr, pw := io.Pipe()
+	logDone := make(chan struct{})
+	go func() {
+		defer close(logDone)
+		// Errors are for the terminal rendering to report, but the rest
+		// of the stream must be read, so that the terminal one isn't blocked.
+		_ = jsonmessage.DisplayJSONMessagesStream(pr, progressLog, 0, false, nil)
+		_, _ = io.Copy(io.Discard, pr)
+	}()
+
+	err := jsonmessage.DisplayJSONMessagesStream(io.TeeReader(in, pw), out, outFd, true, auxCallback)
+	pw.Close()
+	<-logDone
+	return err
+}
+
+// ProgressEvent describes a step of pushing an image, or of pushing
+// one of its layers when Layer is set. Current and Total are
+// the numbers of bytes of the layer pushed so far and in all.
+type ProgressEvent struct {
+	Phase   string `json:"phase"`
+	Layer   string `json:"layer,omitempty"`
+	Current int64  `json:"current,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+}
+
+// writeProgressEvents passes the JSON message stream in to emit as
+// ProgressEvents. Like jsonmessage.DisplayJSONMessagesStream,
+// it passes aux messages to auxCallback and stops at the first error.
+func writeProgressEvents(in io.Reader, emit func(ProgressEvent) error, auxCallback func(jsonmessage.JSONMessage)) error {
+	dec := json.NewDecoder(in)
+	for {
+		var m jsonmessage.JSONMessage
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if m.Aux != nil {
+			if auxCallback != nil {
+				auxCallback(m)
+			}
+			continue
+		}
+		if m.Error != nil {
+			return m.Error
+		}
+
+		ev := ProgressEvent{Phase: m.Status, Layer: m.ID}
+		if m.Progress != nil {
+			ev.Current, ev.Total = m.Progress.Current, m.Progress.Total
+		}
+		if err := emit(ev); err != nil {
+			return err
+		}
+	}
+}
+
+// encodeProgress returns an emitter of ProgressEvents as JSON lines to out.
+func encodeProgress(out io.Writer) func(ProgressEvent) error {
+	enc := json.NewEncoder(out)
+	return func(ev ProgressEvent) error { return enc.Encode(ev) }
+}
+
+// emitProgress returns an emitter of ProgressEvents as e.events,
+// and as JSON lines to e.progressEvents if it's set too.
+func (e *DockerEngine) emitProgress() func(ProgressEvent) error {
+	encode := func(ProgressEvent) error { return nil }
+	if e.progressEvents != nil {
+		encode = encodeProgress(e.progressEvents)
+	}
+	return func(ev ProgressEvent) error {
+		e.events.Emit(internal.Event{
+			Type:    internal.EventPushProgress,
+			Phase:   ev.Phase,
+			Layer:   ev.Layer,
+			Current: ev.Current,
+			Total:   ev.Total,
+		})
+		return encode(ev)
+	}
+}
+
+func skipStatuses(logger *internal.Logger, input io.Reader, s ...string) io.ReadCloser {
 	r, w := io.Pipe()
 	go func() {
 		defer w.Close()
@@ -94,7 +818,7 @@ func skipStatuses(input io.Reader, s ...string) io.Reader {
 			m := jsonmessage.JSONMessage{}
 			if err := dec.Decode(&m); err != nil {
 				if err != io.EOF {
-					log.Printf("skipStatuses: %v", err)
+					logger.Errorf("skipStatuses: %v", err)
 				}
 				break
 			}
@@ -104,18 +828,145 @@ func skipStatuses(input io.Reader, s ...string) io.Reader {
 				}
 			}
 			if err := enc.Encode(m); err != nil {
-				log.Printf("skipStatuses: %v", err)
+				stopReading(logger, "skipStatuses", input, err)
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// stopReading closes input, if it can be closed, once the stage reading it
+// can't pass it on because of err, e.g. because its own reader was closed
+// early, so that the stage feeding input doesn't block forever.
+func stopReading(logger *internal.Logger, stage string, input io.Reader, err error) {
+	if !errors.Is(err, io.ErrClosedPipe) {
+		logger.Errorf("%s: %v", stage, err)
+	}
+	if c, ok := input.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// layerProgress is how many bytes of a layer have been pushed,
+// out of its total.
+type layerProgress struct{ current, total int64 }
+
+// progressSummaryInterval is how often summarizeProgress reports
+// the overall progress.
+const progressSummaryInterval = 5 * time.Second
+
+// summarizeProgress replaces the byte counts of layers being pushed with
+// a periodic "N% pushed" status of all of them together, because output
+// that isn't a terminal gets a new line for every one of those updates.
+// The final percentage is reported when the stream ends, unless it was already.
+func summarizeProgress(logger *internal.Logger, input io.Reader) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		defer w.Close()
+		dec := json.NewDecoder(input)
+		enc := json.NewEncoder(w)
+		layers := map[string]*layerProgress{}
+		var (
+			lastReport  time.Time
+			lastPercent int64 = -1
+		)
+		for {
+			m := jsonmessage.JSONMessage{}
+			if err := dec.Decode(&m); err != nil {
+				if err != io.EOF {
+					logger.Errorf("summarizeProgress: %v", err)
+				}
+				break
+			}
+
+			if m.ID != "" && m.Progress != nil && m.Progress.Total > 0 {
+				layers[m.ID] = &layerProgress{current: m.Progress.Current, total: m.Progress.Total}
+				percent, t := pushedPercent(layers), now()
+				if percent == lastPercent || lastPercent >= 0 && t.Sub(lastReport) < progressSummaryInterval {
+					continue
+				}
+				lastReport, lastPercent = t, percent
+				m = jsonmessage.JSONMessage{Status: fmt.Sprintf("%d%% pushed", percent)}
+			} else if l := layers[m.ID]; l != nil && m.Status == "Pushed" {
+				l.current = l.total
+			}
+
+			if err := enc.Encode(m); err != nil {
+				stopReading(logger, "summarizeProgress", input, err)
+				return
+			}
+		}
+
+		if lastPercent < 0 {
+			return
+		}
+		if percent := pushedPercent(layers); percent != lastPercent {
+			if err := enc.Encode(jsonmessage.JSONMessage{Status: fmt.Sprintf("%d%% pushed", percent)}); err != nil {
+				stopReading(logger, "summarizeProgress", input, err)
+			}
+		}
+	}()
+	return r
+}
+
+// pushedPercent returns how much of layers has been pushed, in percent.
+func pushedPercent(layers map[string]*layerProgress) int64 {
+	var current, total int64
+	for _, l := range layers {
+		current += l.current
+		total += l.total
+	}
+	if total == 0 {
+		return 0
+	}
+	return 100 * current / total
+}
+
+// countLayers tallies in summary the layers of the JSON message stream
+// input, which is passed on as is. The summary is complete
+// once the returned reader reaches EOF.
+func countLayers(logger *internal.Logger, input io.Reader, summary *PushSummary) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		defer w.Close()
+		dec := json.NewDecoder(input)
+		enc := json.NewEncoder(w)
+		sizes := map[string]int64{}
+		for {
+			m := jsonmessage.JSONMessage{}
+			if err := dec.Decode(&m); err != nil {
+				if err != io.EOF {
+					logger.Errorf("countLayers: %v", err)
+				}
+				break
+			}
+
+			switch {
+			case m.ID == "":
+			case m.Status == "Layer already exists":
+				summary.ExistingLayers++
+			case m.Status == "Pushed":
+				summary.NewLayers++
+				summary.NewBytes += sizes[m.ID]
+			case m.Progress != nil && m.Progress.Total > 0:
+				sizes[m.ID] = m.Progress.Total
+			}
+
+			if err := enc.Encode(m); err != nil {
+				stopReading(logger, "countLayers", input, err)
+				return
 			}
 		}
 	}()
 	return r
 }
 
-func extractDigest(p *string) func(jsonmessage.JSONMessage) {
+func extractDigest(logger *internal.Logger, p *string) func(jsonmessage.JSONMessage) {
 	return func(m jsonmessage.JSONMessage) {
 		aux := struct{ Digest string }{}
 		if err := json.Unmarshal(*m.Aux, &aux); err != nil {
-			log.Printf("extractDigest: %v", err)
+			logger.Errorf("extractDigest: %v", err)
 			return
 		}
 		*p = aux.Digest
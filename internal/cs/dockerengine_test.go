@@ -1,34 +1,269 @@
 package cs
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/aws/lightsailctl/internal"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/jsonmessage"
 )
 
+func TestNewDockerEngineHost(t *testing.T) {
+	defer func() { testSocketProber, testDaemonPinger = nil, nil }()
+	testSocketProber = fakeSocketProber{MapFS: fstest.MapFS{
+		"nonexistent/lightsailctl/docker.sock": {Mode: fs.ModeSocket},
+	}}
+	testDaemonPinger = fakeDaemonPinger{}
+
+	ctx := context.Background()
+
+	if _, err := NewDockerEngine(ctx, DockerEngineConfig{Host: "bogus host"}); err == nil ||
+		!strings.Contains(err.Error(), `invalid Docker host "bogus host"`) {
+		t.Errorf("got err: %v", err)
+	}
+
+	const host = "unix:///nonexistent/lightsailctl/docker.sock"
+	e, err := NewDockerEngine(ctx, DockerEngineConfig{Host: host})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.c.DaemonHost(); got != host {
+		t.Errorf("got daemon host %q, want %q", got, host)
+	}
+}
+
+func TestPodmanHost(t *testing.T) {
+	defer func() { testSocketProber, testDaemonPinger = nil, nil }()
+	testSocketProber = fakeSocketProber{MapFS: fstest.MapFS{
+		"run/podman/podman.sock": {Mode: fs.ModeSocket},
+	}}
+	testDaemonPinger = fakeDaemonPinger{}
+
+	t.Setenv("CONTAINER_HOST", "tcp://podman.example.com:8888")
+	if got, want := podmanHost(), "tcp://podman.example.com:8888"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	t.Setenv("CONTAINER_HOST", "")
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	want := "unix:///run/user/1000/podman/podman.sock"
+	if os.Geteuid() == 0 {
+		want = "unix:///run/podman/podman.sock"
+	}
+	if got := podmanHost(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	if got, want := podmanHost(), "unix:///run/podman/podman.sock"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	e, err := NewPodmanEngine(context.Background(), DockerEngineConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.c.DaemonHost(), "unix:///run/podman/podman.sock"; got != want {
+		t.Errorf("got daemon host %q, want %q", got, want)
+	}
+}
+
+func TestCheckSocket(t *testing.T) {
+	defer func() { testSocketProber = nil }()
+	testSocketProber = fakeSocketProber{
+		MapFS: fstest.MapFS{
+			"var/run/docker.sock":     {Mode: fs.ModeSocket},
+			"var/run/locked.sock":     {Mode: fs.ModeSocket},
+			"var/run/not-socket.sock": {Mode: 0o644},
+		},
+		dialErrs: map[string]error{
+			"/var/run/locked.sock": fs.ErrPermission,
+		},
+	}
+
+	for _, test := range []struct {
+		host, want string
+	}{
+		{host: "tcp://127.0.0.1:2375"},
+		{host: "npipe:////./pipe/docker_engine"},
+		{host: "unix:///var/run/docker.sock"},
+		{
+			host: "unix:///var/run/missing.sock",
+			want: "Docker socket at /var/run/missing.sock is not accessible: " +
+				"open var/run/missing.sock: file does not exist; check mount and permissions",
+		},
+		{
+			host: "unix:///var/run/locked.sock",
+			want: "Docker socket at /var/run/locked.sock is not accessible: " +
+				"permission denied; check mount and permissions",
+		},
+		{
+			host: "unix:///var/run/not-socket.sock",
+			want: "Docker socket at /var/run/not-socket.sock is not accessible: " +
+				"/var/run/not-socket.sock is not a socket; check mount and permissions",
+		},
+	} {
+		got := ""
+		if err := checkSocket(test.host); err != nil {
+			got = err.Error()
+		}
+		if got != test.want {
+			t.Errorf("%s: got err: %q", test.host, got)
+			t.Logf("want: %q", test.want)
+		}
+	}
+
+	if _, err := NewDockerEngine(context.Background(), DockerEngineConfig{Host: "unix:///var/run/locked.sock"}); err == nil {
+		t.Error("NewDockerEngine unexpectedly succeeded with inaccessible socket")
+	}
+}
+
+// fakeSocketProber simulates sockets with files in MapFS,
+// dialing succeeds unless dialErrs has an error for the path.
+type fakeSocketProber struct {
+	fstest.MapFS
+	dialErrs map[string]error
+}
+
+func (f fakeSocketProber) Stat(path string) (fs.FileInfo, error) {
+	return fs.Stat(f.MapFS, strings.TrimPrefix(path, "/"))
+}
+
+func (f fakeSocketProber) Dial(path string) error {
+	return f.dialErrs[path]
+}
+
+// fakeDaemonPinger fails with err, if any, or blocks until ctx is done
+// if err is "hang".
+type fakeDaemonPinger struct {
+	err error
+}
+
+func (f fakeDaemonPinger) Ping(ctx context.Context) (dockertypes.Ping, error) {
+	switch {
+	case f.err == nil:
+		return dockertypes.Ping{APIVersion: "1.45"}, nil
+	case f.err.Error() == "hang":
+		<-ctx.Done()
+		return dockertypes.Ping{}, ctx.Err()
+	default:
+		return dockertypes.Ping{}, f.err
+	}
+}
+
+func TestPingDaemon(t *testing.T) {
+	defer func(d time.Duration) { pingTimeout = d }(pingTimeout)
+	pingTimeout = 10 * time.Millisecond
+
+	ctx := context.Background()
+	const host = "unix:///var/run/docker.sock"
+
+	if got, err := pingDaemon(ctx, fakeDaemonPinger{}, host); err != nil || got.APIVersion != "1.45" {
+		t.Errorf("got %v, %v", got, err)
+	}
+
+	for _, test := range []struct {
+		err  error
+		want string
+	}{
+		{
+			err: errors.New("dial unix /var/run/docker.sock: connect: connection refused"),
+			want: "cannot connect to Docker daemon at unix:///var/run/docker.sock; is Docker running? " +
+				"(dial unix /var/run/docker.sock: connect: connection refused)",
+		},
+		{
+			err:  errors.New("hang"),
+			want: "cannot connect to Docker daemon at unix:///var/run/docker.sock; is Docker running? (context deadline exceeded)",
+		},
+	} {
+		start := time.Now()
+		_, err := pingDaemon(ctx, fakeDaemonPinger{err: test.err}, host)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("got err: %v", err)
+			t.Logf("want: %v", test.want)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("ping took %v", elapsed)
+		}
+	}
+}
+
+func TestNewDockerEngineUnreachable(t *testing.T) {
+	// Nothing listens on a port of a closed server.
+	srv := httptest.NewServer(http.NotFoundHandler())
+	host := "tcp://" + srv.Listener.Addr().String()
+	srv.Close()
+
+	_, err := NewDockerEngine(context.Background(), DockerEngineConfig{Host: host})
+	if want := "cannot connect to Docker daemon at " + host + "; is Docker running?"; err == nil || err.Error() != want {
+		t.Errorf("got err: %v", err)
+		t.Logf("want: %v", want)
+	}
+	if !errors.Is(err, ErrEngineUnavailable) {
+		t.Errorf("err %v is not %v", err, ErrEngineUnavailable)
+	}
+}
+
 func TestExtractDigest(t *testing.T) {
 	got := ""
+	stdLog := new(bytes.Buffer)
+	logger := internal.NewLogger(log.New(stdLog, "", 0), internal.LevelError)
 	badAux := json.RawMessage("42")
-	extractDigest(&got)(jsonmessage.JSONMessage{Aux: &badAux})
+	extractDigest(logger, &got)(jsonmessage.JSONMessage{Aux: &badAux})
 	if got != "" {
 		t.Errorf("unexpected got: %q", got)
 	}
+	if !strings.HasPrefix(stdLog.String(), "extractDigest: ") {
+		t.Errorf("got log %q", stdLog)
+	}
 	wantDigest := "sha256:b95cf9b496720e43b12ce435775d5e337a6648147825c0fc8fc0ff93616c69a0"
 	goodAux := json.RawMessage(`{"digest": "` + wantDigest + `"}`)
-	extractDigest(&got)(jsonmessage.JSONMessage{Aux: &goodAux})
+	extractDigest(discardLog, &got)(jsonmessage.JSONMessage{Aux: &goodAux})
 	if got != wantDigest {
 		t.Errorf("got: %q", got)
 		t.Logf("want: %q", wantDigest)
 	}
 }
 
+func TestLoadedImages(t *testing.T) {
+	got, err := loadedImages(strings.NewReader(`
+		{"stream": "Loaded image: hello:latest\n"}
+		{"stream": "Loaded image ID: sha256:10b8cc43\nLoaded image: api:1\n"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"hello:latest", "sha256:10b8cc43", "api:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	_, err = loadedImages(strings.NewReader(`{"errorDetail": {"message": "unexpected EOF"}, "error": "unexpected EOF"}`))
+	if err == nil || err.Error() != "unexpected EOF" {
+		t.Errorf("got err: %v", err)
+	}
+}
+
 func Example_skipStatuses() {
 	r := skipStatuses(
+		discardLog,
 		strings.NewReader(`
 		{"status": "keep me"}
 		{"status": "xyz skip1 abc"}
@@ -44,3 +279,670 @@ func Example_skipStatuses() {
 	// {"status":"keep me"}
 	// {"status":"also keep me!"}
 }
+
+func Example_summarizeProgress() {
+	defer func() { testNow = nil }()
+	var clock time.Time
+	testNow = func() time.Time {
+		clock = clock.Add(2 * time.Second)
+		return clock
+	}
+
+	r := summarizeProgress(discardLog, strings.NewReader(`
+		{"status": "Preparing", "id": "a"}
+		{"status": "Preparing", "id": "b"}
+		{"status": "Pushing", "progressDetail": {"current": 100, "total": 1000}, "id": "a"}
+		{"status": "Pushing", "progressDetail": {"current": 200, "total": 1000}, "id": "a"}
+		{"status": "Pushing", "progressDetail": {"current": 500, "total": 1000}, "id": "b"}
+		{"status": "Pushing", "progressDetail": {"current": 1000, "total": 1000}, "id": "a"}
+		{"status": "Pushed", "id": "a"}
+		{"status": "Pushing", "progressDetail": {"current": 900, "total": 1000}, "id": "b"}
+		{"status": "Pushed", "id": "b"}`))
+	if _, err := io.Copy(os.Stdout, r); err != nil {
+		fmt.Println(err)
+		return
+	}
+	// Output:
+	// {"status":"Preparing","id":"a"}
+	// {"status":"Preparing","id":"b"}
+	// {"status":"10% pushed"}
+	// {"status":"75% pushed"}
+	// {"status":"Pushed","id":"a"}
+	// {"status":"Pushed","id":"b"}
+	// {"status":"100% pushed"}
+}
+
+func TestProgressStagesStopEarly(t *testing.T) {
+	const m = `{"status": "Pushing", "progressDetail": {"current": 100, "total": 1000}, "id": "a"}` + "\n"
+	var summary PushSummary
+	for name, stage := range map[string]func(io.Reader) io.ReadCloser{
+		"skipStatuses":      func(r io.Reader) io.ReadCloser { return skipStatuses(discardLog, r, "skip") },
+		"summarizeProgress": func(r io.Reader) io.ReadCloser { return summarizeProgress(discardLog, r) },
+		"countLayers":       func(r io.Reader) io.ReadCloser { return countLayers(discardLog, r, &summary) },
+	} {
+		pr, pw := io.Pipe()
+		out := stage(pr)
+		out.Close()
+		// The stage fails to pass the first message on, and then
+		// stops reading, rather than block the writer for good.
+		if _, err := io.WriteString(pw, m); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := io.WriteString(pw, m); !errors.Is(err, io.ErrClosedPipe) {
+			t.Errorf("%s: got err: %v, want %v", name, err, io.ErrClosedPipe)
+		}
+	}
+}
+
+func Example_countLayers() {
+	var summary PushSummary
+	r := countLayers(discardLog, strings.NewReader(`
+		{"status": "Preparing", "id": "a"}
+		{"status": "Preparing", "id": "b"}
+		{"status": "Preparing", "id": "c"}
+		{"status": "Layer already exists", "id": "c"}
+		{"status": "Pushing", "progressDetail": {"current": 1048576, "total": 3145728}, "id": "a"}
+		{"status": "Pushing", "progressDetail": {"current": 3145728, "total": 3145728}, "id": "a"}
+		{"status": "Pushing", "progressDetail": {"current": 524288, "total": 524288}, "id": "b"}
+		{"status": "Pushed", "id": "a"}
+		{"status": "Pushed", "id": "b"}
+		{"status": "latest: digest: sha256:10b8cc43 size: 529"}`), &summary)
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(summary)
+	// Output:
+	// Pushed 2 new layers (3.5 MiB), 1 already present.
+}
+
+func TestDisplayProgress(t *testing.T) {
+	const stream = `
+		{"status": "Preparing", "id": "5f70bf18a086"}
+		{"status": "Pushing", "progressDetail": {"current": 512, "total": 1024}, "id": "5f70bf18a086"}
+		{"status": "Pushed", "progressDetail": {}, "id": "5f70bf18a086"}
+		{"status": "latest: digest: sha256:10b8cc43 size: 529"}
+		{"aux": {"Tag": "latest", "Digest": "sha256:10b8cc43", "Size": 529}}`
+	const plain = "5f70bf18a086: Preparing\n5f70bf18a086: Pushed\nlatest: digest: sha256:10b8cc43 size: 529\n"
+
+	for _, isTerm := range []bool{false, true} {
+		out, progressLog := new(bytes.Buffer), new(bytes.Buffer)
+		var digest string
+		err := displayProgress(strings.NewReader(stream), out, 0, isTerm, progressLog, extractDigest(discardLog, &digest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest != "sha256:10b8cc43" {
+			t.Errorf("isTerm %v: got digest %q", isTerm, digest)
+		}
+		if got := progressLog.String(); got != plain {
+			t.Errorf("isTerm %v: got log %q, want %q", isTerm, got, plain)
+		}
+		if rendered := strings.Contains(out.String(), "\x1b["); rendered != isTerm {
+			t.Errorf("isTerm %v: got terminal output %q", isTerm, out)
+		}
+	}
+
+	// An error in the middle of the stream stops both renderings.
+	const failing = `
+		{"status": "Preparing", "id": "5f70bf18a086"}
+		{"errorDetail": {"message": "denied"}, "error": "denied"}
+		{"status": "Pushed", "id": "5f70bf18a086"}`
+	progressLog := new(bytes.Buffer)
+	err := displayProgress(strings.NewReader(failing), io.Discard, 0, true, progressLog, nil)
+	if err == nil || err.Error() != "denied" {
+		t.Errorf("got err: %v", err)
+	}
+	if got := progressLog.String(); got != "5f70bf18a086: Preparing\n" {
+		t.Errorf("got log %q", got)
+	}
+}
+
+func TestInspectImageNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_ping"):
+			w.Header().Set("API-Version", "1.45")
+			fmt.Fprint(w, "OK")
+		case strings.HasSuffix(r.URL.Path, "/images/nginx:latest/json"):
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"Id": "sha256:10b8cc43", "Os": "linux"}`)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "No such image: bogus:latest"}`)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	e, err := NewDockerEngine(ctx, DockerEngineConfig{Host: "tcp://" + srv.Listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := e.InspectImage(ctx, "nginx:latest"); err != nil || info.Os != "linux" {
+		t.Errorf("got %#v, %v", info, err)
+	}
+
+	_, err = e.InspectImage(ctx, "bogus:latest")
+	var notFound *LocalImageNotFoundError
+	if !errors.As(err, &notFound) || notFound.Image != "bogus:latest" {
+		t.Errorf("got err: %v", err)
+	}
+}
+
+func TestWriteProgressEvents(t *testing.T) {
+	const stream = `
+		{"status": "The push refers to repository [123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr]"}
+		{"status": "Preparing", "progressDetail": {}, "id": "5f70bf18a086"}
+		{"status": "Pushing", "progressDetail": {"current": 512, "total": 1024}, "id": "5f70bf18a086"}
+		{"status": "Pushed", "progressDetail": {}, "id": "5f70bf18a086"}
+		{"aux": {"Tag": "latest", "Digest": "sha256:10b8cc43", "Size": 529}}`
+
+	out := new(bytes.Buffer)
+	var digest string
+	if err := writeProgressEvents(strings.NewReader(stream), encodeProgress(out), extractDigest(discardLog, &digest)); err != nil {
+		t.Fatal(err)
+	}
+	if digest != "sha256:10b8cc43" {
+		t.Errorf("got digest %q", digest)
+	}
+	want := `{"phase":"The push refers to repository [123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr]"}
+{"phase":"Preparing","layer":"5f70bf18a086"}
+{"phase":"Pushing","layer":"5f70bf18a086","current":512,"total":1024}
+{"phase":"Pushed","layer":"5f70bf18a086"}
+`
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s", got)
+		t.Logf("want:\n%s", want)
+	}
+
+	const failing = `
+		{"status": "Preparing", "progressDetail": {}, "id": "5f70bf18a086"}
+		{"errorDetail": {"message": "denied"}, "error": "denied"}`
+	if err := writeProgressEvents(strings.NewReader(failing), encodeProgress(io.Discard), nil); err == nil || err.Error() != "denied" {
+		t.Errorf("got err: %v", err)
+	}
+}
+
+func TestPushImageRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_ping"):
+			w.Header().Set("API-Version", "1.45")
+			fmt.Fprint(w, "OK")
+		case r.URL.Query().Get("tag") == "direct":
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"message": "toomanyrequests: Rate exceeded"}`)
+		case r.URL.Query().Get("tag") == "stream":
+			fmt.Fprint(w, `{"status": "Preparing", "progressDetail": {}, "id": "5f70bf18a086"}
+				{"errorDetail": {"message": "toomanyrequests: Rate exceeded"}, "error": "toomanyrequests: Rate exceeded"}`)
+		default:
+			fmt.Fprint(w, `{"errorDetail": {"message": "denied: sha256:a429b is not allowed"}, "error": "denied"}`)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	e, err := NewDockerEngine(ctx, DockerEngineConfig{
+		Host:           "tcp://" + srv.Listener.Addr().String(),
+		ProgressEvents: io.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for tag, wantRateLimit := range map[string]bool{"direct": true, "stream": true, "denied": false} {
+		_, err := e.PushImage(ctx, RemoteImage{AuthConfig: registry.AuthConfig{ServerAddress: "example.com/sr"}, Tag: tag})
+		var rle *rateLimitError
+		if err == nil || errors.As(err, &rle) != wantRateLimit {
+			t.Errorf("%s: got err: %v", tag, err)
+		}
+		if wantRateLimit && !strings.Contains(err.Error(), "wait a few minutes and push again") {
+			t.Errorf("%s: got err: %v", tag, err)
+		}
+	}
+}
+
+func TestPushImagePlatformError(t *testing.T) {
+	pushes := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/push") {
+			pushes[r.URL.Query().Get("tag")]++
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_ping"):
+			w.Header().Set("API-Version", "1.46")
+			fmt.Fprint(w, "OK")
+		case strings.HasSuffix(r.URL.Path, "/push") && r.URL.Query().Get("tag") == "multi":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"message": "no matching manifest for linux/amd64 in the manifest list entries"}`)
+		case strings.HasSuffix(r.URL.Path, "/push"):
+			fmt.Fprint(w, `{"errorDetail": {"message": "image does not match the specified platform"},
+				"error": "image with reference example.com/sr was found but does not match the specified platform"}`)
+		case strings.HasSuffix(r.URL.Path, ":single/json"):
+			fmt.Fprint(w, `{"Id": "sha256:10b8cc43", "Os": "linux", "Architecture": "arm64", "Variant": "v8"}`)
+		case strings.HasSuffix(r.URL.Path, ":multi/json"):
+			fmt.Fprint(w, `{"Id": "sha256:10b8cc43", "Os": "linux", "Architecture": "arm64",
+				"Descriptor": {"mediaType": "application/vnd.oci.image.index.v1+json", "digest": "sha256:10b8cc43", "size": 529}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "not found"}`)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	e, err := NewDockerEngine(ctx, DockerEngineConfig{
+		Host:           "tcp://" + srv.Listener.Addr().String(),
+		ProgressEvents: io.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for tag, want := range map[string]string{
+		"single":  "image does not provide linux/amd64; available: linux/arm64/v8",
+		"multi":   "image does not provide linux/amd64: Error response from daemon: no matching manifest for linux/amd64",
+		"missing": "image does not provide linux/amd64: image does not match the specified platform",
+	} {
+		_, err := e.PushImage(ctx, RemoteImage{
+			AuthConfig: registry.AuthConfig{ServerAddress: "example.com/sr"},
+			Tag:        tag,
+			Platform:   "linux/amd64",
+		})
+		var pe *platformError
+		if !errors.As(err, &pe) || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got err: %v, want it to contain %q", tag, err, want)
+		}
+	}
+	// The local image tells the mismatch without pushing, the others
+	// are only found out by the push.
+	if want := map[string]int{"multi": 1, "missing": 1}; !reflect.DeepEqual(pushes, want) {
+		t.Errorf("got pushes %v, want %v", pushes, want)
+	}
+
+	// Without a platform selected, the error is not about it.
+	_, err = e.PushImage(ctx, RemoteImage{AuthConfig: registry.AuthConfig{ServerAddress: "example.com/sr"}, Tag: "single"})
+	var pe *platformError
+	if err == nil || errors.As(err, &pe) {
+		t.Errorf("got err: %v", err)
+	}
+}
+
+func TestPushImageDigestFallback(t *testing.T) {
+	const digest = "sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch path := r.URL.Path; {
+		case strings.HasSuffix(path, "/_ping"):
+			w.Header().Set("API-Version", "1.45")
+			fmt.Fprint(w, "OK")
+		case strings.HasSuffix(path, "/push"):
+			fmt.Fprint(w, `{"status": "Layer already exists", "progressDetail": {}, "id": "5f70bf18a086"}`)
+		case strings.Contains(path, "/images/example.com/sr:local/"):
+			fmt.Fprintf(w, `{"Id": "sha256:0b159cd1", "RepoDigests": ["example.com/other@sha256:0b159cd1", "example.com/sr@%s"]}`, digest)
+		case strings.Contains(path, "/images/"):
+			fmt.Fprint(w, `{"Id": "sha256:0b159cd1", "RepoDigests": []}`)
+		case strings.Contains(path, "/distribution/example.com/sr:remote/"):
+			fmt.Fprintf(w, `{"Descriptor": {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": %q, "size": 529}}`, digest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "manifest unknown"}`)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	e, err := NewDockerEngine(ctx, DockerEngineConfig{
+		Host:           "tcp://" + srv.Listener.Addr().String(),
+		ProgressEvents: io.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for tag, wantErr := range map[string]string{"local": "", "remote": "", "gone": "manifest unknown"} {
+		got, err := e.PushImage(ctx, RemoteImage{AuthConfig: registry.AuthConfig{ServerAddress: "example.com/sr"}, Tag: tag})
+		switch {
+		case wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), wantErr) {
+				t.Errorf("%s: got err: %v, want it to contain %q", tag, err, wantErr)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tag, err)
+		case got.Digest != digest:
+			t.Errorf("%s: got digest %q, want %q", tag, got.Digest, digest)
+		}
+	}
+}
+
+func TestWithProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.Header().Set("API-Version", "1.45")
+		fmt.Fprint(w, "OK")
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dc, err := client.NewClientWithOpts(client.WithHost("tcp://10.0.0.5:2375"), withProxy(proxyURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dc.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"http://10.0.0.5:2375/_ping"}; !reflect.DeepEqual(proxied, want) {
+		t.Errorf("got proxied requests %q, want %q", proxied, want)
+	}
+
+	// Local connections are left alone.
+	if _, err := client.NewClientWithOpts(client.WithHost("unix:///var/run/docker.sock"), withProxy(proxyURL)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPushPlatform(t *testing.T) {
+	stdLog := new(bytes.Buffer)
+	logger := internal.NewLogger(log.New(stdLog, "", 0), internal.LevelInfo)
+
+	apiVersion := "1.46"
+	var pushedPlatform string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_ping"):
+			w.Header().Set("API-Version", apiVersion)
+			fmt.Fprint(w, "OK")
+		case strings.HasSuffix(r.URL.Path, "/push"):
+			pushedPlatform = r.URL.Query().Get("platform")
+			fmt.Fprint(w, `{"aux": {"Tag": "latest", "Digest": "sha256:10b8cc43", "Size": 529}}`)
+		case strings.HasSuffix(r.URL.Path, ":single/json"):
+			fmt.Fprint(w, `{"Id": "sha256:10b8cc43", "Os": "linux", "Architecture": "arm64", "Variant": "v8"}`)
+		case strings.HasSuffix(r.URL.Path, ":windows/json"):
+			fmt.Fprint(w, `{"Id": "sha256:10b8cc43", "Os": "windows", "Architecture": "amd64"}`)
+		case strings.HasSuffix(r.URL.Path, ":multi/json"):
+			fmt.Fprint(w, `{"Id": "sha256:10b8cc43", "Os": "linux", "Architecture": "amd64",
+				"Descriptor": {"mediaType": "application/vnd.oci.image.index.v1+json", "digest": "sha256:10b8cc43", "size": 529}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "not found"}`)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	for i, test := range []struct {
+		apiVersion, tag, platform string
+		want, wantErr, wantLog    string
+	}{
+		{apiVersion: "1.46", tag: "single"},
+		{apiVersion: "1.46", tag: "single", platform: "linux/arm64", want: `{"architecture":"arm64","os":"linux"}`},
+		{apiVersion: "1.46", tag: "single", platform: "linux/amd64", wantErr: "image does not provide linux/amd64; available: linux/arm64/v8"},
+		{apiVersion: "1.46", tag: "single", platform: "linux/arm64/v7", wantErr: "image does not provide linux/arm64/v7"},
+		{apiVersion: "1.46", tag: "multi", platform: "linux/arm64", want: `{"architecture":"arm64","os":"linux"}`},
+		{apiVersion: "1.46", tag: "single", platform: "auto", want: `{"architecture":"arm64","os":"linux","variant":"v8"}`},
+		{apiVersion: "1.45", tag: "single", platform: "auto"},
+		{apiVersion: "1.46", tag: "multi", platform: "auto", wantErr: "has several platforms, so one of them must be specified"},
+		{
+			apiVersion: "1.46", tag: "windows", platform: "auto",
+			want:    `{"architecture":"amd64","os":"windows"}`,
+			wantLog: `is built for "windows" operating system, but Lightsail runs Linux containers`,
+		},
+		{apiVersion: "1.46", tag: "single", platform: "linux", wantErr: `invalid platform "linux"`},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			apiVersion, pushedPlatform = test.apiVersion, ""
+			stdLog.Reset()
+			e, err := NewDockerEngine(ctx, DockerEngineConfig{
+				Host:           "tcp://" + srv.Listener.Addr().String(),
+				ProgressEvents: io.Discard,
+				Logger:         logger,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = e.PushImage(ctx, RemoteImage{
+				AuthConfig: registry.AuthConfig{ServerAddress: "example.com/sr"},
+				Tag:        test.tag,
+				Platform:   test.platform,
+			})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("got err: %v, that doesn't contain %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pushedPlatform != test.want {
+				t.Errorf("got platform %q, want %q", pushedPlatform, test.want)
+			}
+			if !strings.Contains(stdLog.String(), test.wantLog) || test.wantLog == "" && stdLog.Len() != 0 {
+				t.Errorf("got log %q, want %q", stdLog, test.wantLog)
+			}
+		})
+	}
+}
+
+func TestDockerAPIVersion(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_ping"):
+			w.Header().Set("API-Version", "1.45")
+			fmt.Fprint(w, "OK")
+		default:
+			fmt.Fprint(w, `{"Id": "sha256:10b8cc43", "Os": "linux"}`)
+		}
+	}))
+	defer srv.Close()
+	host := "tcp://" + srv.Listener.Addr().String()
+
+	ctx := context.Background()
+	for apiVersion, want := range map[string]string{"": "1.45", "1.41": "1.41"} {
+		paths = nil
+		e, err := NewDockerEngine(ctx, DockerEngineConfig{Host: host, APIVersion: apiVersion})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.c.ClientVersion(); got != want {
+			t.Errorf("%q: got version %q, want %q", apiVersion, got, want)
+		}
+		if _, err := e.InspectImage(ctx, "nginx:latest"); err != nil {
+			t.Fatal(err)
+		}
+		if got := paths[len(paths)-1]; got != "/v"+want+"/images/nginx:latest/json" {
+			t.Errorf("%q: got request path %q", apiVersion, got)
+		}
+	}
+
+	for _, bad := range []string{"v1.41", "1", "1.41.0", "latest", "0.9"} {
+		_, err := NewDockerEngine(ctx, DockerEngineConfig{Host: host, APIVersion: bad})
+		if want := fmt.Sprintf("invalid Docker API version %q", bad); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got err: %v, that doesn't contain %q", err, want)
+		}
+	}
+}
+
+func TestLayerCompression(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", "1.46")
+		fmt.Fprint(w, "OK")
+	}))
+	defer srv.Close()
+	host := "tcp://" + srv.Listener.Addr().String()
+
+	ctx := context.Background()
+	for compression, want := range map[string]string{"": "", "gzip": "gzip", "zstd": "gzip"} {
+		stdLog := new(bytes.Buffer)
+		logger := internal.NewLogger(log.New(stdLog, "", 0), internal.LevelDebug)
+		e, err := NewDockerEngine(ctx, DockerEngineConfig{Host: host, Compression: compression, Logger: logger})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.layerCompression(); got != want {
+			t.Errorf("%q: got compression %q, want %q", compression, got, want)
+		}
+		if fellBack := strings.Contains(stdLog.String(), "falling back to gzip"); fellBack != (compression == "zstd") {
+			t.Errorf("%q: got log %q", compression, stdLog)
+		}
+	}
+
+	_, err := NewDockerEngine(ctx, DockerEngineConfig{Host: host, Compression: "brotli"})
+	if err == nil || !strings.Contains(err.Error(), `invalid compression "brotli"`) {
+		t.Errorf("got err: %v", err)
+	}
+}
+
+func TestDockerTimeouts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_ping"):
+			w.Header().Set("API-Version", "1.46")
+			fmt.Fprint(w, "OK")
+		case strings.HasSuffix(r.URL.Path, "/push"):
+			// A long push responds at once, and then takes its time.
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprint(w, `{"aux": {"Tag": "latest", "Digest": "sha256:10b8cc43", "Size": 529}}`)
+		default:
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprint(w, `{"Id": "sha256:10b8cc43", "Os": "linux"}`)
+		}
+	}))
+	defer srv.Close()
+	host := "tcp://" + srv.Listener.Addr().String()
+
+	ctx := context.Background()
+	img := RemoteImage{AuthConfig: registry.AuthConfig{ServerAddress: "example.com/sr"}, Tag: "doge.www.latest"}
+	e, err := NewDockerEngine(ctx, DockerEngineConfig{Host: host})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeout := e.c.HTTPClient().Timeout; timeout != 0 {
+		t.Errorf("got timeout %v, want none", timeout)
+	}
+	if _, err := e.InspectImage(ctx, "nginx:latest"); err != nil {
+		t.Errorf("inspect: %v", err)
+	}
+
+	e, err = NewDockerEngine(ctx, DockerEngineConfig{Host: host, ResponseHeaderTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.PushImage(ctx, img); err != nil {
+		t.Errorf("push: %v", err)
+	}
+	if _, err := e.InspectImage(ctx, "nginx:latest"); err == nil || !strings.Contains(err.Error(), "timeout awaiting response headers") {
+		t.Errorf("got err: %v", err)
+	}
+
+	e, err = NewDockerEngine(ctx, DockerEngineConfig{Host: host, Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.PushImage(ctx, img); err == nil {
+		t.Error("push didn't time out")
+	}
+
+	_, err = NewDockerEngine(ctx, DockerEngineConfig{Host: host, Timeout: -time.Second})
+	if err == nil || !strings.Contains(err.Error(), "invalid Docker timeouts") {
+		t.Errorf("got err: %v", err)
+	}
+}
+
+func TestMaxConcurrentUploads(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_ping"):
+			w.Header().Set("API-Version", "1.45")
+			fmt.Fprint(w, "OK")
+		case strings.HasSuffix(r.URL.Path, "/push"):
+			fmt.Fprint(w, `{"aux": {"Tag": "latest", "Digest": "sha256:10b8cc43", "Size": 529}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "not found"}`)
+		}
+	}))
+	defer srv.Close()
+	host := "tcp://" + srv.Listener.Addr().String()
+
+	ctx := context.Background()
+	for _, level := range []internal.Level{internal.LevelInfo, internal.LevelDebug} {
+		stdLog := new(bytes.Buffer)
+		e, err := NewDockerEngine(ctx, DockerEngineConfig{
+			Host:                 host,
+			Progress:             io.Discard,
+			Logger:               internal.NewLogger(log.New(stdLog, "", 0), level),
+			MaxConcurrentUploads: 10,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.PushImage(ctx, RemoteImage{AuthConfig: registry.AuthConfig{ServerAddress: "example.com/sr"}, Tag: "latest"}); err != nil {
+			t.Fatal(err)
+		}
+		const note = "so 10 is not applied; the daemon's max-concurrent-uploads setting controls it"
+		if logged := strings.Contains(stdLog.String(), note); logged != (level == internal.LevelDebug) {
+			t.Errorf("%v: got log %q", level, stdLog)
+		}
+	}
+
+	_, err := NewDockerEngine(ctx, DockerEngineConfig{Host: host, MaxConcurrentUploads: -1})
+	if err == nil || !strings.Contains(err.Error(), "invalid max concurrent uploads -1") {
+		t.Errorf("got err: %v", err)
+	}
+}
+
+func TestPushImageProgress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_ping"):
+			w.Header().Set("API-Version", "1.45")
+			fmt.Fprint(w, "OK")
+		case strings.HasSuffix(r.URL.Path, "/push"):
+			fmt.Fprint(w, `{"status": "Layer already exists", "progressDetail": {}, "id": "5f70bf18a086"}`)
+			fmt.Fprint(w, `{"aux": {"Tag": "latest", "Digest": "sha256:10b8cc43", "Size": 529}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "not found"}`)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	progress, progressLog := new(bytes.Buffer), new(bytes.Buffer)
+	e, err := NewDockerEngine(ctx, DockerEngineConfig{
+		Host:        "tcp://" + srv.Listener.Addr().String(),
+		Progress:    progress,
+		ProgressLog: progressLog,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.PushImage(ctx, RemoteImage{AuthConfig: registry.AuthConfig{ServerAddress: "example.com/sr"}, Tag: "latest"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "5f70bf18a086: Layer already exists"; !strings.Contains(progress.String(), want) {
+		t.Errorf("got progress %q, want it to contain %q", progress, want)
+	}
+	if progressLog.Len() == 0 {
+		t.Error("progress log is empty")
+	}
+}
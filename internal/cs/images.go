@@ -0,0 +1,111 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+)
+
+type DeleteImageInput struct {
+	Service string
+	// Image is the registered image name, e.g. ":hello.www.73".
+	Image string
+
+	// Output is told about the deletion, os.Stdout when it's nil.
+	Output io.Writer
+}
+
+type ImageDeleter interface {
+	DeleteContainerImage(
+		context.Context,
+		*lightsail.DeleteContainerImageInput,
+		...func(*lightsail.Options),
+	) (*lightsail.DeleteContainerImageOutput, error)
+}
+
+// DeleteImage deletes a container image registered with Lightsail service.
+func DeleteImage(ctx context.Context, in *DeleteImageInput, d ImageDeleter) error {
+	if _, err := d.DeleteContainerImage(ctx, &lightsail.DeleteContainerImageInput{
+		ServiceName: &in.Service,
+		Image:       &in.Image,
+	}); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(outputOrStdout(in.Output), "Image %q deleted from service %q.\n", in.Image, in.Service)
+	return nil
+}
+
+type ListImagesInput struct {
+	Service string
+	// Output receives the table, os.Stdout when it's nil.
+	Output io.Writer
+}
+
+type ImageLister interface {
+	GetContainerImages(
+		context.Context,
+		*lightsail.GetContainerImagesInput,
+		...func(*lightsail.Options),
+	) (*lightsail.GetContainerImagesOutput, error)
+}
+
+// ListImages prints a table of container images registered with Lightsail service.
+func ListImages(ctx context.Context, in *ListImagesInput, l ImageLister) error {
+	out, err := l.GetContainerImages(ctx, &lightsail.GetContainerImagesInput{ServiceName: &in.Service})
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(outputOrStdout(in.Output), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tDIGEST\tCREATED")
+	for _, img := range out.ContainerImages {
+		created := ""
+		if img.CreatedAt != nil {
+			created = img.CreatedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", aws.ToString(img.Image), aws.ToString(img.Digest), created)
+	}
+	return tw.Flush()
+}
+
+// AutoLabel is the label that PushImage replaces with the next free
+// numeric label of the service, see nextLabel.
+const AutoLabel = "auto"
+
+// nextLabel returns the label that follows the highest numeric label
+// of the images registered with the service, or "1" if there are none.
+func nextLabel(ctx context.Context, service string, l ImageLister) (string, error) {
+	out, err := l.GetContainerImages(ctx, &lightsail.GetContainerImagesInput{ServiceName: &service})
+	if err != nil {
+		return "", fmt.Errorf("could not find the next free label: %w", err)
+	}
+
+	highest := 0
+	prefix := ":" + service + "."
+	for _, img := range out.ContainerImages {
+		// Registered images are named ":service.label.version".
+		rest, ok := strings.CutPrefix(aws.ToString(img.Image), prefix)
+		if !ok {
+			continue
+		}
+		label, _, ok := strings.Cut(rest, ".")
+		if !ok || strings.TrimLeft(label, "0123456789") != "" {
+			continue
+		}
+		if n, err := strconv.Atoi(label); err == nil && n > highest {
+			highest = n
+		}
+	}
+	return strconv.Itoa(highest + 1), nil
+}
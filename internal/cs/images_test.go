@@ -0,0 +1,134 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail/types"
+)
+
+type fakeImageDeleter struct {
+	failToDelete bool
+	log          []string
+}
+
+func (f *fakeImageDeleter) DeleteContainerImage(
+	_ context.Context,
+	in *lightsail.DeleteContainerImageInput,
+	_ ...func(*lightsail.Options),
+) (*lightsail.DeleteContainerImageOutput, error) {
+	op := fmt.Sprintf("delete (%s, %s)", aws.ToString(in.ServiceName), aws.ToString(in.Image))
+	if f.failToDelete {
+		return nil, fmt.Errorf("failed: %s", op)
+	}
+	f.log = append(f.log, op)
+	return &lightsail.DeleteContainerImageOutput{}, nil
+}
+
+func ExampleDeleteImage() {
+	ctx := context.Background()
+	in := &DeleteImageInput{Service: "doge", Image: ":doge.www.12345"}
+
+	d := &fakeImageDeleter{}
+	if err := DeleteImage(ctx, in, d); err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println(d.log)
+
+	if err := DeleteImage(ctx, in, &fakeImageDeleter{failToDelete: true}); err != nil {
+		fmt.Println(err)
+	}
+
+	// Output:
+	// Image ":doge.www.12345" deleted from service "doge".
+	// [delete (doge, :doge.www.12345)]
+	// failed: delete (doge, :doge.www.12345)
+}
+
+type fakeImageLister map[string][]types.ContainerImage
+
+func (f fakeImageLister) GetContainerImages(
+	_ context.Context,
+	in *lightsail.GetContainerImagesInput,
+	_ ...func(*lightsail.Options),
+) (*lightsail.GetContainerImagesOutput, error) {
+	images, ok := f[aws.ToString(in.ServiceName)]
+	if !ok {
+		return nil, fmt.Errorf("failed: service %q not found", aws.ToString(in.ServiceName))
+	}
+	return &lightsail.GetContainerImagesOutput{ContainerImages: images}, nil
+}
+
+func ExampleListImages() {
+	ctx := context.Background()
+	l := fakeImageLister{
+		"doge": {
+			{
+				Image:     aws.String(":doge.www.2"),
+				Digest:    aws.String("sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa"),
+				CreatedAt: aws.Time(time.Date(2024, 7, 2, 10, 30, 0, 0, time.UTC)),
+			},
+			{
+				Image:     aws.String(":doge.api.1"),
+				Digest:    aws.String("sha256:b95cf9b496720e43b12ce435775d5e337a6648147825c0fc8fc0ff93616c69a0"),
+				CreatedAt: aws.Time(time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)),
+			},
+		},
+		"empty": nil,
+	}
+
+	for _, service := range []string{"doge", "empty", "missing"} {
+		if err := ListImages(ctx, &ListImagesInput{Service: service}, l); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	// Output:
+	// IMAGE        DIGEST                                                                   CREATED
+	// :doge.www.2  sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa  2024-07-02T10:30:00Z
+	// :doge.api.1  sha256:b95cf9b496720e43b12ce435775d5e337a6648147825c0fc8fc0ff93616c69a0  2024-07-01T09:00:00Z
+	// IMAGE  DIGEST  CREATED
+	// failed: service "missing" not found
+}
+
+func TestNextLabel(t *testing.T) {
+	ctx := context.Background()
+	images := func(names ...string) []types.ContainerImage {
+		var imgs []types.ContainerImage
+		for _, name := range names {
+			imgs = append(imgs, types.ContainerImage{Image: aws.String(name)})
+		}
+		return imgs
+	}
+	l := fakeImageLister{
+		"empty": nil,
+		"doge":  images(":doge.www.3", ":doge.2.1", ":doge.10.4", ":doge.9.7"),
+		"mixed": images(":mixed.www.1", ":mixed.v2.3", ":mixed.-5.1", ":other.7.1", "nginx:latest"),
+	}
+
+	for service, want := range map[string]string{
+		"empty": "1",
+		"doge":  "11",
+		"mixed": "1",
+	} {
+		got, err := nextLabel(ctx, service, l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: got label %q, want %q", service, got, want)
+		}
+	}
+
+	if _, err := nextLabel(ctx, "missing", l); err == nil || !strings.Contains(err.Error(), "could not find the next free label") {
+		t.Errorf("got err: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/lightsailctl/internal"
+)
+
+// These are the values of PushImageInput.SourceType,
+// which tells where the image to push comes from.
+const (
+	// SourceDaemon is an image that the container engine already has,
+	// which is what an empty SourceType means as well.
+	SourceDaemon = "daemon"
+	// SourceOCI is an OCI image layout directory, e.g. made by ko or buildah.
+	SourceOCI = "oci"
+	// SourceTar is a tarball made by docker save.
+	SourceTar = "tar"
+)
+
+// fromSource tells whether the image is loaded from in.SourcePath
+// rather than being in the container engine already.
+func (in *PushImageInput) fromSource() bool {
+	return in.SourceType == SourceOCI || in.SourceType == SourceTar
+}
+
+// checkImageSource makes sure that in.SourceType is known and that
+// in.SourcePath is there for the source types that need it, so that
+// a bad source fails early, dry runs included, which load nothing.
+func checkImageSource(in *PushImageInput) error {
+	switch in.SourceType {
+	case "", SourceDaemon:
+		if in.SourcePath != "" {
+			return fmt.Errorf("image source path %q is only used with %q and %q source types",
+				in.SourcePath, SourceOCI, SourceTar)
+		}
+		return nil
+	case SourceOCI, SourceTar:
+	default:
+		return fmt.Errorf("image source type %q is invalid: it must be %q, %q or %q",
+			in.SourceType, SourceDaemon, SourceOCI, SourceTar)
+	}
+
+	if in.SourcePath == "" {
+		return fmt.Errorf("image source path is not specified for %q source type", in.SourceType)
+	}
+	if in.SourceType == SourceOCI {
+		if _, err := os.Stat(filepath.Join(in.SourcePath, "oci-layout")); err != nil {
+			return fmt.Errorf("%s is not an OCI image layout: %w", in.SourcePath, err)
+		}
+		return nil
+	}
+	info, err := os.Stat(in.SourcePath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory, not a tarball", in.SourcePath)
+	}
+	return nil
+}
+
+// loadImageSource loads the image from in.SourcePath into the container
+// engine for the image sources other than the engine itself, which
+// checkImageSource has checked already.
+// It sets in.Image to the loaded image, unless in.Image is set already,
+// in which case it's up to the user to name one of the loaded images.
+func loadImageSource(ctx context.Context, logger *internal.Logger, imgo ImageOperator, in *PushImageInput) error {
+	if !in.fromSource() {
+		return nil
+	}
+
+	archive, err := openImageSource(in.SourceType, in.SourcePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	loaded, err := imgo.LoadImage(ctx, archive)
+	if err != nil {
+		return fmt.Errorf("could not load image from %s: %w", in.SourcePath, err)
+	}
+	logger.Debugf("loaded images %q from %s", loaded, in.SourcePath)
+
+	if in.Image != "" {
+		return nil
+	}
+	if len(loaded) != 1 {
+		return fmt.Errorf("%s has %d images, so the image to push must be specified", in.SourcePath, len(loaded))
+	}
+	in.Image = loaded[0]
+	return nil
+}
+
+// openImageSource returns the image source at path as a tar archive
+// that container engines know how to load.
+func openImageSource(sourceType, path string) (io.ReadCloser, error) {
+	if sourceType == SourceTar {
+		return os.Open(path)
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(w)
+		err := tw.AddFS(os.DirFS(path))
+		if err == nil {
+			err = tw.Close()
+		}
+		w.CloseWithError(err)
+	}()
+	return r, nil
+}
@@ -0,0 +1,235 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushImageFromSource(t *testing.T) {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611796436, 0) }
+	testRngReader = strings.NewReader("abcdefghijklmnopqrstuvwxyz")
+
+	archive := filepath.Join(t.TempDir(), "hello.tar")
+	if err := os.WriteFile(archive, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fls := &fakeLightsailImageOperator{}
+	fimgo := &fakeImageOperator{loaded: []string{"hello:latest"}}
+	in := &PushImageInput{Service: "doge", Label: "www", SourceType: SourceTar, SourcePath: archive}
+	if _, err := PushImage(context.Background(), discardLog, in, fls, fimgo); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`load 5 bytes`,
+		`inspect "hello:latest"`,
+		`tag "hello:latest" as "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611796436000000000-c5h66p35cpjmg"`,
+		`push "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611796436000000000-c5h66p35cpjmg"`,
+		`untag "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611796436000000000-c5h66p35cpjmg"`,
+	}
+	if !reflect.DeepEqual(fimgo.log, want) {
+		t.Errorf("got docker engine call log %q, want %q", fimgo.log, want)
+	}
+}
+
+func TestPushImageFromSourceDryRun(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "hello.tar")
+	if err := os.WriteFile(archive, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	fimgo := &fakeImageOperator{loaded: []string{"hello:latest"}}
+	in := &PushImageInput{
+		Service: "doge", Label: "www", SourceType: SourceTar, SourcePath: archive,
+		IfNotPresent: true, DryRun: true, Output: buf,
+	}
+	if res, err := PushImage(context.Background(), discardLog, in, &fakeLightsailImageOperator{}, fimgo); err != nil || res != nil {
+		t.Fatalf("got result %+v and err: %v", res, err)
+	}
+	if len(fimgo.log) != 0 {
+		t.Errorf("unexpected docker engine calls: %q", fimgo.log)
+	}
+	want := "Dry run: image " + archive + " would be loaded, pushed to 123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr " +
+		"and registered with service \"doge\" under label \"www\".\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	in.SourcePath = filepath.Join(filepath.Dir(archive), "missing.tar")
+	if _, err := PushImage(context.Background(), discardLog, in, &fakeLightsailImageOperator{}, fimgo); err == nil {
+		t.Error("got no error for a missing source")
+	}
+}
+
+func TestCheckImageSource(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "images.tar")
+	if err := os.WriteFile(archive, []byte("images"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	layout := filepath.Join(dir, "layout")
+	if err := os.Mkdir(layout, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(layout, "oci-layout"), []byte(`{"imageLayoutVersion": "1.0.0"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, test := range []struct {
+		in          PushImageInput
+		errContains string
+	}{
+		{in: PushImageInput{}},
+		{in: PushImageInput{SourceType: SourceDaemon}},
+		{in: PushImageInput{SourceType: SourceTar, SourcePath: archive}},
+		{in: PushImageInput{SourceType: SourceOCI, SourcePath: layout}},
+		{
+			in:          PushImageInput{SourcePath: archive},
+			errContains: "is only used with",
+		},
+		{
+			in:          PushImageInput{SourceType: SourceTar},
+			errContains: "image source path is not specified",
+		},
+		{
+			in:          PushImageInput{SourceType: SourceOCI, SourcePath: dir},
+			errContains: "is not an OCI image layout",
+		},
+		{
+			in:          PushImageInput{SourceType: SourceTar, SourcePath: filepath.Join(dir, "missing.tar")},
+			errContains: "no such file",
+		},
+		{
+			in:          PushImageInput{SourceType: SourceTar, SourcePath: layout},
+			errContains: "is a directory, not a tarball",
+		},
+		{
+			in:          PushImageInput{SourceType: "docker"},
+			errContains: `image source type "docker" is invalid`,
+		},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			err := checkImageSource(&test.in)
+			if test.errContains == "" {
+				if err != nil {
+					t.Errorf("got err: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.errContains) {
+				t.Errorf("got err: %v, want one that contains %q", err, test.errContains)
+			}
+		})
+	}
+}
+
+func TestLoadImageSource(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "images.tar")
+	if err := os.WriteFile(archive, []byte("images"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, test := range []struct {
+		in          PushImageInput
+		loaded      []string
+		wantImage   string
+		errContains string
+	}{
+		{in: PushImageInput{Image: "hello:latest"}, wantImage: "hello:latest"},
+		{in: PushImageInput{Image: "hello:latest", SourceType: SourceDaemon}, wantImage: "hello:latest"},
+		{
+			in:        PushImageInput{SourceType: SourceTar, SourcePath: archive},
+			loaded:    []string{"sha256:10b8cc43"},
+			wantImage: "sha256:10b8cc43",
+		},
+		{
+			in:        PushImageInput{Image: "api:1", SourceType: SourceTar, SourcePath: archive},
+			loaded:    []string{"web:1", "api:1"},
+			wantImage: "api:1",
+		},
+		{
+			in:          PushImageInput{SourceType: SourceTar, SourcePath: archive},
+			loaded:      []string{"web:1", "api:1"},
+			errContains: "has 2 images, so the image to push must be specified",
+		},
+		{
+			in:          PushImageInput{SourceType: SourceTar, SourcePath: filepath.Join(dir, "missing.tar")},
+			errContains: "no such file",
+		},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			in := test.in
+			err := loadImageSource(context.Background(), discardLog, &fakeImageOperator{loaded: test.loaded}, &in)
+			if test.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errContains) {
+					t.Errorf("got err: %v, want one that contains %q", err, test.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if in.Image != test.wantImage {
+				t.Errorf("got image %q, want %q", in.Image, test.wantImage)
+			}
+		})
+	}
+}
+
+func TestOpenImageSourceOCI(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"oci-layout":        `{"imageLayoutVersion": "1.0.0"}`,
+		"index.json":        `{"schemaVersion": 2, "manifests": []}`,
+		"blobs/sha256/abcd": "blob",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive, err := openImageSource(SourceOCI, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	var names []string
+	tr := tar.NewReader(archive)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+	}
+	want := []string{"blobs/", "blobs/sha256/", "blobs/sha256/abcd", "index.json", "oci-layout"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail/types"
+	"github.com/aws/lightsailctl/internal"
+)
+
+// LoginCacheDir returns the directory where registry logins are kept
+// between invocations, which is in the user's cache directory.
+func LoginCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "lightsailctl", "registry-logins"), nil
+}
+
+// cachedLogin is what a login cache file contains.
+type cachedLogin struct {
+	Registry  string    `json:"registry"`
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// loginCache creates registry logins with the Lightsail client it embeds,
+// and keeps them in a file to reuse in later invocations until they're
+// about to expire. Logins without an expiration time aren't kept,
+// since there's no telling how long they work.
+type loginCache struct {
+	LightsailImageOperator
+	file   string
+	logger *internal.Logger
+}
+
+// WithLoginCache returns lio with its registry logins kept in file,
+// which should be named after the account and region they're for,
+// e.g. in LoginCacheDir. Failures to use the file are only logged,
+// and new logins are created then.
+func WithLoginCache(lio LightsailImageOperator, file string, logger *internal.Logger) LightsailImageOperator {
+	return &loginCache{LightsailImageOperator: lio, file: file, logger: logger}
+}
+
+func (c *loginCache) CreateContainerServiceRegistryLogin(
+	ctx context.Context,
+	in *lightsail.CreateContainerServiceRegistryLoginInput,
+	optFns ...func(*lightsail.Options),
+) (*lightsail.CreateContainerServiceRegistryLoginOutput, error) {
+	if l, err := c.read(); err != nil {
+		c.logger.Debugf("could not read cached registry login: %v", err)
+	} else if l != nil {
+		login := &RegistryLogin{ExpiresAt: l.ExpiresAt}
+		if !login.expiresWithin(loginRefreshMargin) {
+			c.logger.Debugf("using cached registry login that expires at %v", l.ExpiresAt)
+			return &lightsail.CreateContainerServiceRegistryLoginOutput{
+				RegistryLogin: &types.ContainerServiceRegistryLogin{
+					Registry:  aws.String(l.Registry),
+					Username:  aws.String(l.Username),
+					Password:  aws.String(l.Password),
+					ExpiresAt: aws.Time(l.ExpiresAt),
+				},
+			}, nil
+		}
+	}
+
+	out, err := c.LightsailImageOperator.CreateContainerServiceRegistryLogin(ctx, in, optFns...)
+	if err != nil || out.RegistryLogin == nil || out.RegistryLogin.ExpiresAt == nil {
+		return out, err
+	}
+	if err := c.write(&cachedLogin{
+		Registry:  aws.ToString(out.RegistryLogin.Registry),
+		Username:  aws.ToString(out.RegistryLogin.Username),
+		Password:  aws.ToString(out.RegistryLogin.Password),
+		ExpiresAt: aws.ToTime(out.RegistryLogin.ExpiresAt),
+	}); err != nil {
+		c.logger.Debugf("could not cache registry login: %v", err)
+	}
+	return out, nil
+}
+
+// read returns the cached login, or nil if there is none.
+func (c *loginCache) read() (*cachedLogin, error) {
+	b, err := os.ReadFile(c.file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := new(cachedLogin)
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// write replaces the cached login with l. The file is only readable by
+// its owner, as os.CreateTemp makes it, and it's replaced by a rename
+// so that concurrent invocations never read half of it.
+func (c *loginCache) write(l *cachedLogin) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(c.file)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(c.file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.file)
+}
@@ -0,0 +1,88 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+)
+
+func TestLoginCache(t *testing.T) {
+	defer func() { testNow = nil }()
+	clock := time.Unix(1611800397, 0)
+	testNow = func() time.Time { return clock }
+
+	ctx := context.Background()
+	for i, test := range []struct {
+		expiresIn time.Duration
+		// elapsed is the time between the first invocation and the second.
+		elapsed    time.Duration
+		wantLogins int
+		wantFile   bool
+	}{
+		{expiresIn: time.Hour, elapsed: time.Minute, wantLogins: 1, wantFile: true},
+		{expiresIn: time.Hour, elapsed: time.Hour - loginRefreshMargin + time.Second, wantLogins: 2, wantFile: true},
+		{expiresIn: 0, elapsed: time.Minute, wantLogins: 2},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "logins", "key.json")
+			lio := &fakeLightsailImageOperator{fakeRegistryLoginCreator: fakeRegistryLoginCreator{expiresIn: test.expiresIn}}
+
+			for j := 0; j < 2; j++ {
+				if j > 0 {
+					clock = clock.Add(test.elapsed)
+				}
+				// Each invocation has its own cache, the file is what they share.
+				c := WithLoginCache(lio, file, discardLog)
+				login, err := getServiceRegistryAuth(ctx, discardLog, c, "", DefaultRegistryRepo)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if login.Username != "gollum" || login.Password != "precious" ||
+					login.ServerAddress != "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr" {
+					t.Errorf("got login: %+v", login)
+				}
+			}
+
+			if got := len(lio.log); got != test.wantLogins {
+				t.Errorf("got %d logins, want %d", got, test.wantLogins)
+			}
+			fi, err := os.Stat(file)
+			if gotFile := err == nil; gotFile != test.wantFile {
+				t.Fatalf("got file: %v, want: %v", gotFile, test.wantFile)
+			}
+			if test.wantFile && runtime.GOOS != "windows" && fi.Mode().Perm() != 0o600 {
+				t.Errorf("got file mode %v, want 0600", fi.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestLoginCacheIgnoresBadFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(file, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	lio := &fakeLightsailImageOperator{fakeRegistryLoginCreator: fakeRegistryLoginCreator{expiresIn: time.Hour}}
+	c := WithLoginCache(lio, file, discardLog)
+	if _, err := c.CreateContainerServiceRegistryLogin(context.Background(), new(lightsail.CreateContainerServiceRegistryLoginInput)); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(lio.log); got != 1 {
+		t.Errorf("got %d logins, want 1", got)
+	}
+	if _, err := c.CreateContainerServiceRegistryLogin(context.Background(), new(lightsail.CreateContainerServiceRegistryLoginInput)); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(lio.log); got != 1 {
+		t.Errorf("got %d logins after the file was fixed, want 1", got)
+	}
+}
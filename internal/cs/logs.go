@@ -0,0 +1,110 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+)
+
+type GetLogInput struct {
+	Service   string
+	Container string
+	// StartTime, when set, skips the log events that are older.
+	StartTime time.Time
+	// FilterPattern selects log events, see Lightsail GetContainerLog API.
+	FilterPattern string
+	// Follow keeps polling for new log events until ctx is done.
+	Follow bool
+	// Output receives the log events, os.Stdout when it's nil.
+	Output io.Writer
+}
+
+type ContainerLogGetter interface {
+	GetContainerLog(
+		context.Context,
+		*lightsail.GetContainerLogInput,
+		...func(*lightsail.Options),
+	) (*lightsail.GetContainerLogOutput, error)
+}
+
+// logPollInterval is how often GetLog polls for new log events
+// when following the log.
+const logPollInterval = 5 * time.Second
+
+// GetLog prints the log events of a container of Lightsail service,
+// one per line, preceded by their time.
+func GetLog(ctx context.Context, in *GetLogInput, g ContainerLogGetter) error {
+	f := &logFollower{in: in, g: g, since: in.StartTime}
+	for {
+		if err := f.poll(ctx); err != nil {
+			return err
+		}
+		if !in.Follow {
+			return nil
+		}
+		if err := sleep(ctx, logPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// logFollower prints the log events that it hasn't printed yet.
+type logFollower struct {
+	in    *GetLogInput
+	g     ContainerLogGetter
+	since time.Time
+	// printed counts the messages printed with the time in since,
+	// because the next poll starts at that time and gets them again.
+	// They're counted, rather than merely noted, because the same
+	// message may well be logged several times within a second.
+	printed map[string]int
+}
+
+func (f *logFollower) poll(ctx context.Context) error {
+	req := &lightsail.GetContainerLogInput{
+		ServiceName:   &f.in.Service,
+		ContainerName: &f.in.Container,
+	}
+	if !f.since.IsZero() {
+		req.StartTime = aws.Time(f.since)
+	}
+	if f.in.FilterPattern != "" {
+		req.FilterPattern = &f.in.FilterPattern
+	}
+
+	// The events with the time in since come again, the ones
+	// printed by the previous polls first.
+	prev, seen := f.printed, map[string]int{}
+	defer func() { f.printed = seen }()
+	for {
+		out, err := f.g.GetContainerLog(ctx, req)
+		if err != nil {
+			return err
+		}
+		for _, e := range out.LogEvents {
+			t, msg := aws.ToTime(e.CreatedAt), aws.ToString(e.Message)
+			switch {
+			case t.Before(f.since):
+				continue
+			case !t.Equal(f.since):
+				f.since, prev, seen = t, nil, map[string]int{}
+			}
+			seen[msg]++
+			if seen[msg] <= prev[msg] {
+				continue
+			}
+			fmt.Fprintf(outputOrStdout(f.in.Output), "%s %s\n", t.UTC().Format(time.RFC3339), msg)
+		}
+		if out.NextPageToken == nil {
+			return nil
+		}
+		req.PageToken = out.NextPageToken
+	}
+}
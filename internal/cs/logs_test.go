@@ -0,0 +1,120 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail/types"
+)
+
+// fakeContainerLogGetter returns the log events that are not older
+// than the start time, two per page.
+type fakeContainerLogGetter struct {
+	events []types.ContainerServiceLogEvent
+	log    []string
+}
+
+func (f *fakeContainerLogGetter) GetContainerLog(
+	_ context.Context,
+	in *lightsail.GetContainerLogInput,
+	_ ...func(*lightsail.Options),
+) (*lightsail.GetContainerLogOutput, error) {
+	op := fmt.Sprintf("get log (%s, %s, %v, %s, %s)",
+		aws.ToString(in.ServiceName),
+		aws.ToString(in.ContainerName),
+		aws.ToTime(in.StartTime).Unix(),
+		aws.ToString(in.FilterPattern),
+		aws.ToString(in.PageToken))
+	if aws.ToString(in.ServiceName) != "doge" {
+		return nil, fmt.Errorf("failed: %s", op)
+	}
+	f.log = append(f.log, op)
+
+	var events []types.ContainerServiceLogEvent
+	for _, e := range f.events {
+		if in.StartTime == nil || !e.CreatedAt.Before(*in.StartTime) {
+			events = append(events, e)
+		}
+	}
+	start := 0
+	if in.PageToken != nil {
+		fmt.Sscan(*in.PageToken, &start)
+	}
+	out := &lightsail.GetContainerLogOutput{LogEvents: events[start:min(start+2, len(events))]}
+	if start+2 < len(events) {
+		out.NextPageToken = aws.String(fmt.Sprint(start + 2))
+	}
+	return out, nil
+}
+
+func ExampleGetLog() {
+	defer func() { testSleep = nil }()
+
+	event := func(sec int64, msg string) types.ContainerServiceLogEvent {
+		return types.ContainerServiceLogEvent{CreatedAt: aws.Time(time.Unix(sec, 0)), Message: aws.String(msg)}
+	}
+	g := &fakeContainerLogGetter{events: []types.ContainerServiceLogEvent{
+		event(1611796436, "starting"),
+		event(1611796437, "listening on :80"),
+		event(1611796437, "GET /"),
+		event(1611796437, "GET /"),
+	}}
+
+	ctx := context.Background()
+	in := &GetLogInput{Service: "doge", Container: "www"}
+	if err := GetLog(ctx, in, g); err != nil {
+		fmt.Println(err)
+	}
+
+	// New events appear while following the log, and the poll
+	// from the time of the last event doesn't print it again,
+	// though the same message logged again at that time is printed.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	polls := 0
+	testSleep = func(ctx context.Context, d time.Duration) error {
+		polls++
+		switch polls {
+		case 1:
+			g.events = append(g.events, event(1611796437, "GET /favicon.ico"), event(1611796437, "GET /"),
+				event(1611796440, "GET /about"))
+		case 2:
+			cancel()
+		}
+		return ctx.Err()
+	}
+	in = &GetLogInput{Service: "doge", Container: "www", StartTime: time.Unix(1611796437, 0), Follow: true}
+	fmt.Println(GetLog(ctx, in, g))
+
+	fmt.Println(GetLog(ctx, &GetLogInput{Service: "cate", Container: "www", FilterPattern: "GET"}, g))
+	for _, op := range g.log {
+		fmt.Println(op)
+	}
+
+	// Output:
+	// 2021-01-28T01:13:56Z starting
+	// 2021-01-28T01:13:57Z listening on :80
+	// 2021-01-28T01:13:57Z GET /
+	// 2021-01-28T01:13:57Z GET /
+	// 2021-01-28T01:13:57Z listening on :80
+	// 2021-01-28T01:13:57Z GET /
+	// 2021-01-28T01:13:57Z GET /
+	// 2021-01-28T01:13:57Z GET /favicon.ico
+	// 2021-01-28T01:13:57Z GET /
+	// 2021-01-28T01:14:00Z GET /about
+	// context canceled
+	// failed: get log (cate, www, -62135596800, GET, )
+	// get log (doge, www, -62135596800, , )
+	// get log (doge, www, -62135596800, , 2)
+	// get log (doge, www, 1611796437, , )
+	// get log (doge, www, 1611796437, , 2)
+	// get log (doge, www, 1611796437, , )
+	// get log (doge, www, 1611796437, , 2)
+	// get log (doge, www, 1611796437, , 4)
+}
@@ -0,0 +1,78 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/lightsailctl/internal"
+)
+
+type MetricDataPutter interface {
+	PutMetricData(
+		context.Context,
+		*cloudwatch.PutMetricDataInput,
+		...func(*cloudwatch.Options),
+	) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+// PushMetrics describes the outcome of one PushImage call.
+type PushMetrics struct {
+	Service   string
+	Duration  time.Duration
+	Succeeded bool
+	// ImageSize is the local image size in bytes, zero if unknown.
+	ImageSize int64
+}
+
+// PutPushMetrics publishes m as custom metrics in the given CloudWatch namespace,
+// with the service name as the dimension. Since metrics are not essential,
+// errors are logged as warnings and not returned.
+func PutPushMetrics(ctx context.Context, logger *internal.Logger, mdp MetricDataPutter, namespace string, m PushMetrics) {
+	// The push may have failed because ctx is done, but its metrics still matter.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	if _, err := mdp.PutMetricData(ctx, pushMetricDataInput(namespace, m)); err != nil {
+		logger.Infof("WARNING: could not publish push metrics to CloudWatch: %v", err)
+	}
+}
+
+func pushMetricDataInput(namespace string, m PushMetrics) *cloudwatch.PutMetricDataInput {
+	dims := []types.Dimension{{Name: aws.String("ServiceName"), Value: aws.String(m.Service)}}
+
+	success := 0.0
+	if m.Succeeded {
+		success = 1
+	}
+
+	data := []types.MetricDatum{
+		{
+			MetricName: aws.String("PushDuration"),
+			Dimensions: dims,
+			Unit:       types.StandardUnitSeconds,
+			Value:      aws.Float64(m.Duration.Seconds()),
+		},
+		{
+			MetricName: aws.String("PushSuccess"),
+			Dimensions: dims,
+			Unit:       types.StandardUnitCount,
+			Value:      aws.Float64(success),
+		},
+	}
+	if m.ImageSize > 0 {
+		data = append(data, types.MetricDatum{
+			MetricName: aws.String("ImageSize"),
+			Dimensions: dims,
+			Unit:       types.StandardUnitBytes,
+			Value:      aws.Float64(float64(m.ImageSize)),
+		})
+	}
+
+	return &cloudwatch.PutMetricDataInput{Namespace: aws.String(namespace), MetricData: data}
+}
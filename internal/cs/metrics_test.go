@@ -0,0 +1,90 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/lightsailctl/internal"
+)
+
+type fakeMetricDataPutter struct {
+	fail bool
+	got  []*cloudwatch.PutMetricDataInput
+}
+
+func (f *fakeMetricDataPutter) PutMetricData(
+	_ context.Context,
+	in *cloudwatch.PutMetricDataInput,
+	_ ...func(*cloudwatch.Options),
+) (*cloudwatch.PutMetricDataOutput, error) {
+	if f.fail {
+		return nil, errors.New("throttled")
+	}
+	f.got = append(f.got, in)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func (f *fakeMetricDataPutter) String() string {
+	var sb strings.Builder
+	for _, in := range f.got {
+		for _, d := range in.MetricData {
+			fmt.Fprintf(&sb, "%s %s{%s=%s} %v %s\n",
+				aws.ToString(in.Namespace),
+				aws.ToString(d.MetricName),
+				aws.ToString(d.Dimensions[0].Name),
+				aws.ToString(d.Dimensions[0].Value),
+				aws.ToFloat64(d.Value),
+				d.Unit)
+		}
+	}
+	return sb.String()
+}
+
+func TestPutPushMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	mdp := &fakeMetricDataPutter{}
+	PutPushMetrics(ctx, nil, mdp, "CI/Deploys", PushMetrics{
+		Service:   "doge",
+		Duration:  1500 * time.Millisecond,
+		Succeeded: true,
+		ImageSize: 4096,
+	})
+	PutPushMetrics(ctx, nil, mdp, "CI/Deploys", PushMetrics{Service: "doge", Duration: time.Second})
+
+	want := `CI/Deploys PushDuration{ServiceName=doge} 1.5 Seconds
+CI/Deploys PushSuccess{ServiceName=doge} 1 Count
+CI/Deploys ImageSize{ServiceName=doge} 4096 Bytes
+CI/Deploys PushDuration{ServiceName=doge} 1 Seconds
+CI/Deploys PushSuccess{ServiceName=doge} 0 Count
+`
+	if got := mdp.String(); got != want {
+		t.Errorf("got:\n%s", got)
+		t.Logf("want:\n%s", want)
+	}
+}
+
+func TestPutPushMetricsFailureIsLogged(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := internal.NewLogger(log.New(buf, "", 0), internal.LevelInfo)
+
+	// Metrics must be published even if the push has been canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	PutPushMetrics(ctx, logger, &fakeMetricDataPutter{fail: true}, "CI/Deploys", PushMetrics{Service: "doge"})
+	if !strings.Contains(buf.String(), "could not publish push metrics to CloudWatch: throttled") {
+		t.Errorf("unexpected log: %q", buf)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/lightsailctl/internal"
+)
+
+// Pusher pushes images with a Lightsail client and a container engine
+// that it creates itself, for embedding image pushes without wiring
+// them up. PushImage and PushImages remain for more control.
+type Pusher struct {
+	lio      LightsailImageOperator
+	imgo     ImageOperator
+	engine   *DockerEngine
+	region   string
+	accounts AccountResolver
+	logger   *internal.Logger
+}
+
+// PusherOption customizes what NewPusher creates.
+type PusherOption func(*pusherOptions)
+
+type pusherOptions struct {
+	engine          DockerEngineConfig
+	podman          bool
+	logger          *internal.Logger
+	lightsailOptFns []func(*lightsail.Options)
+}
+
+// WithEngineConfig makes the pusher connect to the container engine
+// with cfg instead of the zero DockerEngineConfig.
+func WithEngineConfig(cfg DockerEngineConfig) PusherOption {
+	return func(o *pusherOptions) { o.engine = cfg }
+}
+
+// WithLogger makes the pusher log to logger, and so the container engine
+// too, unless its config has a logger of its own.
+func WithLogger(logger *internal.Logger) PusherOption {
+	return func(o *pusherOptions) { o.logger = logger }
+}
+
+// WithPodman makes the pusher use Podman instead of Docker Engine.
+func WithPodman() PusherOption {
+	return func(o *pusherOptions) { o.podman = true }
+}
+
+// WithLightsailOptions customizes the Lightsail client of the pusher,
+// e.g. its endpoint.
+func WithLightsailOptions(optFns ...func(*lightsail.Options)) PusherOption {
+	return func(o *pusherOptions) { o.lightsailOptFns = append(o.lightsailOptFns, optFns...) }
+}
+
+// NewPusher returns a pusher that calls Lightsail with awsCfg, and
+// pushes images of Docker Engine, or of Podman with WithPodman.
+// The logger of the engine config, if any, is the pusher's logger too,
+// unless WithLogger says otherwise.
+func NewPusher(ctx context.Context, awsCfg aws.Config, opts ...PusherOption) (*Pusher, error) {
+	var o pusherOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.logger == nil {
+		o.logger = o.engine.Logger
+	} else if o.engine.Logger == nil {
+		o.engine.Logger = o.logger
+	}
+
+	newEngine := NewDockerEngine
+	if o.podman {
+		newEngine = NewPodmanEngine
+	}
+	engine, err := newEngine(ctx, o.engine)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pusher{
+		lio:      lightsail.NewFromConfig(awsCfg, o.lightsailOptFns...),
+		imgo:     engine,
+		engine:   engine,
+		region:   awsCfg.Region,
+		accounts: NewAccountResolver(sts.NewFromConfig(awsCfg), o.logger),
+		logger:   o.logger,
+	}, nil
+}
+
+// Push pushes and registers an image, see PushImage.
+// The service is expected to be in the region of the pusher,
+// unless in.Region tells otherwise, and the account is looked up
+// with the pusher's credentials unless in.AccountResolver is set.
+func (p *Pusher) Push(ctx context.Context, in *PushImageInput) (*PushImageResult, error) {
+	return PushImage(ctx, p.logger, p.withRegion(in), p.lio, p.imgo)
+}
+
+// PushAll pushes and registers several images, see PushImages.
+func (p *Pusher) PushAll(ctx context.Context, in *PushImagesInput) ([]*PushImageResult, error) {
+	batch := *in
+	batch.Images = make([]*PushImageInput, len(in.Images))
+	for i, img := range in.Images {
+		batch.Images[i] = p.withRegion(img)
+	}
+	return PushImages(ctx, p.logger, &batch, p.lio, p.imgo)
+}
+
+// Close releases the connection to the container engine.
+func (p *Pusher) Close() error {
+	if p.engine == nil {
+		return nil
+	}
+	return p.engine.Close()
+}
+
+// withRegion returns in, or a copy of it with the pusher's region
+// and account resolver where in has none.
+func (p *Pusher) withRegion(in *PushImageInput) *PushImageInput {
+	if (in.Region != "" || p.region == "") && (in.AccountResolver != nil || p.accounts == nil) {
+		return in
+	}
+	c := *in
+	if c.Region == "" {
+		c.Region = p.region
+	}
+	if c.AccountResolver == nil {
+		c.AccountResolver = p.accounts
+	}
+	return &c
+}
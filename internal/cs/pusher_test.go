@@ -0,0 +1,86 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/lightsailctl/internal"
+)
+
+func TestNewPusher(t *testing.T) {
+	defer func() { testSocketProber, testDaemonPinger = nil, nil }()
+	testSocketProber = fakeSocketProber{MapFS: fstest.MapFS{
+		"nonexistent/lightsailctl/docker.sock": {Mode: fs.ModeSocket},
+	}}
+	testDaemonPinger = fakeDaemonPinger{}
+
+	ctx := context.Background()
+	const host = "unix:///nonexistent/lightsailctl/docker.sock"
+	p, err := NewPusher(ctx, aws.Config{Region: "us-west-2"}, WithEngineConfig(DockerEngineConfig{Host: host}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if got := p.engine.c.DaemonHost(); got != host {
+		t.Errorf("got daemon host %q, want %q", got, host)
+	}
+	if p.lio == nil || p.region != "us-west-2" || p.accounts == nil {
+		t.Errorf("got pusher %+v", p)
+	}
+
+	logger := internal.NewLogger(log.New(io.Discard, "", 0), internal.LevelDebug)
+	p, err = NewPusher(ctx, aws.Config{}, WithEngineConfig(DockerEngineConfig{Host: host}), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.logger != logger || p.engine.logger != logger {
+		t.Errorf("got pusher logger %p and engine logger %p, want %p", p.logger, p.engine.logger, logger)
+	}
+
+	if _, err := NewPusher(ctx, aws.Config{}, WithEngineConfig(DockerEngineConfig{Host: "bogus host"})); err == nil {
+		t.Error("got no error for a bogus host")
+	}
+}
+
+func TestPusherPush(t *testing.T) {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611796436, 0) }
+	testRngReader = strings.NewReader("abcdefgh")
+
+	stdLog := new(bytes.Buffer)
+	p := &Pusher{
+		lio:    &fakeLightsailImageOperator{},
+		imgo:   &fakeImageOperator{},
+		region: "us-east-1",
+		logger: internal.NewLogger(log.New(stdLog, "", 0), internal.LevelInfo),
+	}
+	in := &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "www"}
+	res, err := p.Push(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Reference != ":doge.www.12345" {
+		t.Errorf("got result %+v", res)
+	}
+	if in.Region != "" {
+		t.Errorf("input is changed: %+v", in)
+	}
+	// The service registry is in so-fake-2, which the pusher's region is not.
+	if want := `but region "us-east-1" is configured`; !strings.Contains(stdLog.String(), want) {
+		t.Errorf("got log %q, that doesn't contain %q", stdLog, want)
+	}
+}
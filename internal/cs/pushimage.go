@@ -8,20 +8,136 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base32"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/lightsailctl/internal"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/registry"
 )
 
 type PushImageInput struct {
 	Service string
 	Image   string
-	Label   string
+	// Label is AutoLabel for the next free numeric label of the service.
+	Label string
+	// ExtraLabels are more labels to register the image under, e.g. for
+	// promotion workflows. The image is pushed once, and then registered
+	// under Label and each of ExtraLabels in order.
+	ExtraLabels []string
+
+	// RegisterGracePeriod is how long to keep retrying image registration
+	// while a freshly created service is not ready to accept images yet.
+	// Registration is attempted only once when it's zero.
+	RegisterGracePeriod time.Duration
+
+	// PushAttempts is how many times pushing the image is attempted,
+	// it's attempted once when PushAttempts is zero.
+	PushAttempts int
+	// PushAttemptTimeout limits each push attempt, so that a stuck
+	// one is abandoned in favor of the next. The overall time is limited
+	// only by ctx given to PushImage.
+	PushAttemptTimeout time.Duration
+	// PushTimeout limits the whole push, all of its attempts included,
+	// apart from the AWS API calls before and after it. The push is still
+	// limited by ctx too, so it can only be cut shorter than ctx allows.
+	// The push isn't limited apart from ctx when it's zero.
+	PushTimeout time.Duration
+
+	// RequireExposedPorts makes PushImage fail early for images that
+	// don't declare any exposed ports, which is likely a mistake when
+	// the image is meant for a deployment with a public endpoint.
+	RequireExposedPorts bool
+	// IfNotPresent skips pushing the image when the same image digest
+	// is already registered with the service under Label or one of
+	// ExtraLabels, and skips registering it under the labels it's
+	// registered under already.
+	IfNotPresent bool
+
+	// DryRun stops short of tagging, pushing and registering the image,
+	// after all the checks are done and the registry login is created,
+	// and tells what would be done instead. Images from SourcePath are
+	// not loaded in dry runs, so only their source is checked then,
+	// and IfNotPresent can't tell whether they're pushed already.
+	DryRun bool
+
+	// Output receives the messages for users, os.Stdout when it's nil.
+	Output io.Writer
+
+	// KeepLocalTag keeps the temporary local tag that refers to the image
+	// in the service registry, instead of removing it after the push.
+	KeepLocalTag bool
+
+	// TagPrefix, when set, is prepended to the unique tag that the image
+	// is pushed under, e.g. to include a CI build number for traceability.
+	// It must follow Docker tag rules.
+	TagPrefix string
+
+	// Platform selects what to push of a multi-platform image,
+	// see RemoteImage.Platform.
+	Platform string
+
+	// WarnIncompatible makes PushImage warn about images with
+	// characteristics that Lightsail may not be able to run.
+	// It's a heuristic and never fails the push.
+	WarnIncompatible bool
+
+	// SignatureVerifier, when set, must find a valid signature of the image
+	// before it's pushed, otherwise nothing is pushed. The image is
+	// verified by its digest in the registry that it was pulled from.
+	SignatureVerifier SignatureVerifier
+
+	// Region, when set, is the AWS region the service is expected to be in.
+	// A warning is logged if the service registry is in another region,
+	// which hints at an endpoint and region mismatch.
+	Region string
+
+	// AccountResolver, when set, tells the AWS account that the image
+	// is pushed to, which is included in the success message along with
+	// Region. The push doesn't fail if the account can't be found out.
+	AccountResolver AccountResolver
+
+	// Events, when set, receives the steps of the push, from the
+	// registry login to the registration, as they happen.
+	Events *internal.Events
+
+	// SourceType tells where the image comes from, see SourceDaemon,
+	// SourceOCI and SourceTar. The image at SourcePath is loaded into
+	// the container engine before it's pushed, and Image may be left
+	// empty if there's only one image there.
+	SourceType string
+	SourcePath string
+
+	// RegisterOnly registers the image with Digest, which is already
+	// in the service registry, e.g. pushed there by another job,
+	// instead of pushing a local image. Image is not used then.
+	// Only PushImage supports it, PushImages does not.
+	RegisterOnly bool
+	Digest       string
+
+	// RegistryRepo is the repo path that is appended to the service
+	// registry host, DefaultRegistryRepo when it's empty. It's not meant
+	// for users to change, but for integration tests against other
+	// registries, and for other kinds of repos that Lightsail might have
+	// in the future. PushImages needs it to be the same for all images.
+	RegistryRepo string
+
+	// localID is the ID of the local image that Image refers to
+	// by digest, which is what gets tagged for the push.
+	localID string
 }
 
 type RegistryLoginCreator interface {
@@ -34,6 +150,8 @@ type RegistryLoginCreator interface {
 
 type LightsailImageOperator interface {
 	RegistryLoginCreator
+	ImageLister
+	ImageDeleter
 
 	RegisterContainerImage(
 		context.Context,
@@ -43,92 +161,1197 @@ type LightsailImageOperator interface {
 }
 
 type ImageOperator interface {
+	// InspectImage returns *LocalImageNotFoundError for missing images.
+	InspectImage(ctx context.Context, image string) (types.ImageInspect, error)
 	TagImage(ctx context.Context, source, target string) error
 	UntagImage(ctx context.Context, image string) error
-	PushImage(ctx context.Context, r RemoteImage) (digest string, err error)
+	PushImage(ctx context.Context, r RemoteImage) (PushSummary, error)
+	LoadImage(ctx context.Context, archive io.Reader) (images []string, err error)
+}
+
+// PushImageResult describes an image that is registered with a service.
+type PushImageResult struct {
+	// Image is the local image that was pushed,
+	// or the digest that was registered with RegisterOnly.
+	Image string `json:"image"`
+	// Digest is the digest of the image in the service registry.
+	Digest string `json:"digest,omitempty"`
+	// Reference is how deployments refer to the image, e.g. ":doge.www.12".
+	Reference string `json:"reference"`
+	// ExtraReferences are the references of the image registered
+	// under ExtraLabels, in the same order.
+	ExtraReferences []string `json:"extraReferences,omitempty"`
+	// ExistingReferences are the references among Reference and
+	// ExtraReferences that IfNotPresent found already registered,
+	// so they weren't registered again.
+	ExistingReferences []string `json:"existingReferences,omitempty"`
+	// AlreadyRegistered tells that IfNotPresent found the image
+	// registered under all of its labels, so it wasn't pushed
+	// or registered again.
+	AlreadyRegistered bool `json:"alreadyRegistered,omitempty"`
+	// Account and Region are the AWS account and region of the service,
+	// each of them empty when it's not known.
+	Account string `json:"account,omitempty"`
+	Region  string `json:"region,omitempty"`
+}
+
+// registeredReferences returns the references that were registered
+// rather than found already registered.
+func (r *PushImageResult) registeredReferences() []string {
+	var refs []string
+	for _, ref := range append([]string{r.Reference}, r.ExtraReferences...) {
+		if ref != "" && !slices.Contains(r.ExistingReferences, ref) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+func (in *PushImageInput) registryRepo() string {
+	if in.RegistryRepo == "" {
+		return DefaultRegistryRepo
+	}
+	return in.RegistryRepo
+}
+
+func (in *PushImageInput) output() io.Writer {
+	return outputOrStdout(in.Output)
+}
+
+// outputOrStdout returns w, or os.Stdout if w is nil,
+// for the inputs whose Output is optional.
+func outputOrStdout(w io.Writer) io.Writer {
+	if w != nil {
+		return w
+	}
+	return os.Stdout
 }
 
 // PushImage pushes and registers the image to Lightsail service registry.
-func PushImage(ctx context.Context, in *PushImageInput, lio LightsailImageOperator, imgo ImageOperator) error {
-	authConfig, err := getServiceRegistryAuth(ctx, lio)
+// The result is nil in dry runs, which register nothing.
+func PushImage(
+	ctx context.Context,
+	logger *internal.Logger,
+	in *PushImageInput,
+	lio LightsailImageOperator,
+	imgo ImageOperator,
+) (*PushImageResult, error) {
+	if in.RegisterOnly {
+		return registerOnly(ctx, logger, in, lio)
+	}
+
+	in, err := prepareImage(ctx, logger, imgo, in)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	login, err := getServiceRegistryAuth(ctx, logger, lio, in.Region, in.registryRepo())
+	if err != nil {
+		return nil, err
+	}
+	in.Events.Emit(internal.Event{Type: internal.EventLoginCreated, Registry: login.ServerAddress})
+
+	return pushAndRegister(ctx, logger, in, lio, imgo, &login.AuthConfig)
+}
+
+// registerOnly registers in.Digest without tagging or pushing anything,
+// so no container engine is involved.
+func registerOnly(
+	ctx context.Context,
+	logger *internal.Logger,
+	in *PushImageInput,
+	lio LightsailImageOperator,
+) (*PushImageResult, error) {
+	if err := checkDigest(in.Digest); err != nil {
+		return nil, err
+	}
+	// The label is resolved in a copy, the same as the image is normalized in one.
+	reg := *in
+	if err := resolveAutoLabel(ctx, &reg, lio); err != nil {
+		return nil, err
+	}
+	in = &reg
+	if in.DryRun {
+		fmt.Fprintf(in.output(), "Dry run: image %s would be registered with service %q under label %q.\n",
+			in.Digest, in.Service, in.Label)
+		return nil, nil
+	}
+	return registerDigest(ctx, logger, in, lio, in.Digest, nil)
+}
+
+var digestRE = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+func checkDigest(digest string) error {
+	if !digestRE.MatchString(digest) {
+		return fmt.Errorf("image digest %q is invalid: it must be sha256: followed by 64 hexadecimal digits", digest)
+	}
+	return nil
+}
+
+// PushImagesInput describes a batch of images for PushImages.
+type PushImagesInput struct {
+	Images []*PushImageInput
+
+	// Atomic makes PushImages delete the images registered earlier
+	// in the batch when a later one fails, so that either all
+	// of the images are registered or none of them.
+	Atomic bool
+
+	// ContinueOnError makes PushImages go on with the rest of the images
+	// when one fails, rather than stop. It's ignored when Atomic is set.
+	ContinueOnError bool
+}
+
+// PushImages pushes and registers several images, one by one,
+// reusing the same registry login for as long as it's valid.
+// It returns the result of each image in order, the same as PushImage.
+//
+// All images are checked before anything is pushed.
+// Pushing stops at the first image that fails, in which case
+// a *BatchPushError tells which images were pushed and
+// registered before the failure. These are rolled back
+// only when in.Atomic is set.
+//
+// When in.ContinueOnError is set, every image is attempted, and
+// a *PushImagesError tells which of them failed and why, along with
+// the results of those that were pushed and registered.
+func PushImages(
+	ctx context.Context,
+	logger *internal.Logger,
+	in *PushImagesInput,
+	lio LightsailImageOperator,
+	imgo ImageOperator,
+) ([]*PushImageResult, error) {
+	keepGoing := in.ContinueOnError && !in.Atomic
+	imgs := make([]*PushImageInput, len(in.Images))
+	errs := make([]error, len(in.Images))
+	present := make([]bool, len(in.Images))
+	for i, img := range in.Images {
+		var err error
+		if repo := in.Images[0].registryRepo(); img.registryRepo() != repo {
+			err = fmt.Errorf("registry repo %q differs from %q of the first image: "+
+				"images of a batch are pushed to the same repo", img.registryRepo(), repo)
+		} else {
+			imgs[i], err = prepareImage(ctx, logger, imgo, img)
+		}
+		if err != nil {
+			if !keepGoing {
+				return nil, &BatchPushError{Failed: img, Err: err}
+			}
+			errs[i] = err
+		}
+	}
+
+	logins := &registryLogins{rlc: lio, repo: in.Images[0].registryRepo(), events: in.Images[0].Events}
+	var (
+		pushed     []*PushImageInput
+		registered []*DeleteImageInput
+		results    []*PushImageResult
+	)
+	for i, img := range in.Images {
+		if errs[i] != nil {
+			continue
+		}
+		authConfig, err := logins.get(ctx, logger, img.Region)
+		var res *PushImageResult
+		if err == nil {
+			res, err = pushAndRegister(ctx, logger, imgs[i], lio, imgo, authConfig)
+		}
+		if res != nil {
+			for _, ref := range res.registeredReferences() {
+				registered = append(registered, &DeleteImageInput{Service: img.Service, Image: ref, Output: img.Output})
+			}
+		}
+		if err != nil && keepGoing {
+			logger.Debugf("image %s failed, going on with the rest: %v", describeImage(img), err)
+			errs[i] = err
+			continue
+		}
+		if err != nil {
+			batchErr := &BatchPushError{Failed: img, Pushed: pushed, Err: err}
+			if in.Atomic && len(registered) > 0 {
+				batchErr.RolledBack = true
+				batchErr.Deleted, batchErr.RollbackErr = rollBack(ctx, registered, lio)
+			}
+			return nil, batchErr
+		}
+		pushed = append(pushed, img)
+		results = append(results, res)
+		present[i] = res != nil && res.AlreadyRegistered
+	}
+	return results, newPushImagesError(in.Images, errs, present)
+}
+
+// rollBack deletes the registered images, the most recent first,
+// and returns the ones it deleted and the errors of the deletions that failed.
+func rollBack(ctx context.Context, registered []*DeleteImageInput, d ImageDeleter) ([]string, error) {
+	// The batch may have failed because ctx is done, but the cleanup still matters.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	var (
+		deleted []string
+		errs    []error
+	)
+	for i := len(registered) - 1; i >= 0; i-- {
+		if err := DeleteImage(ctx, registered[i], d); err != nil {
+			errs = append(errs, fmt.Errorf("could not delete image %q: %w", registered[i].Image, err))
+			continue
+		}
+		deleted = append(deleted, registered[i].Image)
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// BatchPushError is returned by PushImages when one of the images fails.
+type BatchPushError struct {
+	// Failed is the image that could not be pushed or registered.
+	Failed *PushImageInput
+	// Pushed are the images that were pushed and registered before the failure.
+	Pushed []*PushImageInput
+	// RolledBack tells whether deleting the registered images was attempted,
+	// Deleted are the references of the images it deleted,
+	// and RollbackErr is why some of them are still registered.
+	RolledBack  bool
+	Deleted     []string
+	RollbackErr error
+	Err         error
+}
+
+func (e *BatchPushError) Error() string {
+	msg := fmt.Sprintf("image %s: %v", describeImage(e.Failed), e.Err)
+	deleted := make([]string, len(e.Deleted))
+	for i, ref := range e.Deleted {
+		deleted[i] = strconv.Quote(ref)
 	}
+	var notes []string
+	switch {
+	case e.RolledBack && e.RollbackErr == nil:
+		return msg + " (rolled back: deleted " + strings.Join(deleted, ", ") + ")"
+	case len(e.Pushed) == 0 && !e.RolledBack:
+		return msg + " (no images were pushed)"
+	case len(e.Pushed) > 0:
+		notes = append(notes, "already pushed: "+describeImages(e.Pushed))
+	}
+	if len(e.Deleted) > 0 {
+		notes = append(notes, "deleted: "+strings.Join(deleted, ", "))
+	}
+	if e.RollbackErr != nil {
+		notes = append(notes, "rollback failed: "+strings.ReplaceAll(e.RollbackErr.Error(), "\n", "; "))
+	}
+	return msg + " (" + strings.Join(notes, "; ") + ")"
+}
+
+// Unwrap returns the error of the failed image, cleanup
+// errors are only reported by Error.
+func (e *BatchPushError) Unwrap() error {
+	return e.Err
+}
+
+// PushImagesError is returned by PushImages with ContinueOnError
+// when some of the images fail.
+type PushImagesError struct {
+	// Failed are the images that could not be pushed or registered,
+	// and Errs are their errors, in the same order.
+	Failed []*PushImageInput
+	Errs   []error
+	// Pushed are the images that were pushed and registered.
+	Pushed []*PushImageInput
+	// Present are the images that IfNotPresent found registered
+	// under all of their labels, so they weren't pushed.
+	Present []*PushImageInput
+}
 
-	remoteImage := RemoteImage{AuthConfig: *authConfig, Tag: generateUniqueTag()}
+// newPushImagesError returns a *PushImagesError for the images whose
+// errs are not nil, or nil if there are none. The images which are
+// present were found already registered.
+func newPushImagesError(imgs []*PushImageInput, errs []error, present []bool) error {
+	e := &PushImagesError{}
+	for i, img := range imgs {
+		switch {
+		case errs[i] != nil:
+			e.Failed = append(e.Failed, img)
+			e.Errs = append(e.Errs, errs[i])
+		case present[i]:
+			e.Present = append(e.Present, img)
+		default:
+			e.Pushed = append(e.Pushed, img)
+		}
+	}
+	if len(e.Failed) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *PushImagesError) Error() string {
+	failed := make([]error, len(e.Failed))
+	for i, in := range e.Failed {
+		failed[i] = fmt.Errorf("image %s: %w", describeImage(in), e.Errs[i])
+	}
+	total := len(e.Failed) + len(e.Pushed) + len(e.Present)
+	msg := fmt.Sprintf("%d of %d images failed:\n%v", len(e.Failed), total, errors.Join(failed...))
+	var notes []string
+	if len(e.Pushed) > 0 {
+		notes = append(notes, "pushed: "+describeImages(e.Pushed))
+	}
+	if len(e.Present) > 0 {
+		notes = append(notes, "already registered: "+describeImages(e.Present))
+	}
+	if len(notes) == 0 {
+		return msg + "\n(no images were pushed)"
+	}
+	return msg + "\n(" + strings.Join(notes, "; ") + ")"
+}
+
+// Unwrap returns the errors of the failed images.
+func (e *PushImagesError) Unwrap() []error {
+	return e.Errs
+}
+
+// describeImage tells which image of a batch in is.
+func describeImage(in *PushImageInput) string {
+	return fmt.Sprintf("%q with label %q", in.Image, in.Label)
+}
+
+// describeImages lists the images of a batch with describeImage.
+func describeImages(ins []*PushImageInput) string {
+	described := make([]string, len(ins))
+	for i, in := range ins {
+		described[i] = describeImage(in)
+	}
+	return strings.Join(described, ", ")
+}
+
+// prepareImage checks the options of in, loads the image from its source,
+// except in dry runs, and checks the image. It returns a copy of in
+// with the image normalized, which the push goes on with.
+func prepareImage(ctx context.Context, logger *internal.Logger, imgo ImageOperator, in *PushImageInput) (*PushImageInput, error) {
+	if err := checkPushOptions(in); err != nil {
+		return nil, err
+	}
+	// There's nothing to check in the container engine yet
+	// when the image would be loaded from its source.
+	if in.DryRun && in.fromSource() {
+		return normalizeImage(logger, in), nil
+	}
+
+	loaded := *in
+	if err := loadImageSource(ctx, logger, imgo, &loaded); err != nil {
+		return nil, err
+	}
+	in = normalizeImage(logger, &loaded)
+	if err := checkImage(ctx, logger, imgo, in); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// normalizeImage returns a copy of in with its Image normalized,
+// so that the same reference is used throughout the push.
+func normalizeImage(logger *internal.Logger, in *PushImageInput) *PushImageInput {
+	image := normalizeImageRef(in.Image)
+	if image != in.Image {
+		logger.Debugf("image %q is normalized to %q", in.Image, image)
+	}
+	norm := *in
+	norm.Image = image
+	return &norm
+}
 
-	err = imgo.TagImage(ctx, in.Image, remoteImage.Ref())
+// normalizeImageRef puts an image reference in the short form that
+// Docker displays, e.g. "docker.io/library/nginx:1" becomes "nginx:1".
+// The digest of a reference with both a tag and a digest is dropped,
+// so that the image is referred to by its tag.
+//
+// Image IDs and references that don't parse are returned as is,
+// and no tag is added to references without one, because Docker
+// may take those for short image IDs.
+func normalizeImageRef(image string) string {
+	ref, err := reference.ParseAnyReference(image)
 	if err != nil {
+		return image
+	}
+	named, ok := ref.(reference.Named)
+	if !ok {
+		return image
+	}
+	if tagged, ok := named.(reference.NamedTagged); ok {
+		if _, ok := named.(reference.Digested); ok {
+			if named, err = reference.WithTag(reference.TrimNamed(named), tagged.Tag()); err != nil {
+				return image
+			}
+		}
+	}
+	return reference.FamiliarString(named)
+}
+
+// checkPushOptions makes sure that the options of in are valid,
+// before anything is loaded into the container engine.
+func checkPushOptions(in *PushImageInput) error {
+	if err := checkImageSource(in); err != nil {
+		return err
+	}
+	if err := checkTagPrefix(in.TagPrefix); err != nil {
+		return err
+	}
+	if err := checkRegistryRepo(in.RegistryRepo); err != nil {
 		return err
 	}
-	defer tryUntagImage(ctx, imgo, remoteImage.Ref())
+	if in.Platform != "auto" {
+		if _, err := parsePlatform(in.Platform); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	digest, err := imgo.PushImage(ctx, remoteImage)
+// checkImage makes sure that the local image exists, which tagging
+// errors don't tell clearly, and does the checks of it that in asks for.
+// It resolves digest references, such as "nginx@sha256:...", to image IDs
+// in in.localID, because Docker doesn't tag images by digest reliably.
+func checkImage(ctx context.Context, logger *internal.Logger, imgo ImageOperator, in *PushImageInput) error {
+	info, err := imgo.InspectImage(ctx, in.Image)
 	if err != nil {
 		return err
 	}
+	if isDigestRef(in.Image) {
+		if info.ID == "" {
+			return fmt.Errorf("local image %q has no ID", in.Image)
+		}
+		in.localID = info.ID
+	}
 
-	registered, err := lio.RegisterContainerImage(
-		ctx,
-		&lightsail.RegisterContainerImageInput{
-			ServiceName: &in.Service,
-			Label:       &in.Label,
-			Digest:      &digest,
-		},
-	)
+	if in.SignatureVerifier != nil {
+		if err := verifySignature(ctx, logger, in, info); err != nil {
+			return err
+		}
+	}
+
+	if in.RequireExposedPorts {
+		if err := checkExposedPorts(in.Image, info); err != nil {
+			return err
+		}
+	}
+
+	if in.WarnIncompatible {
+		for _, problem := range incompatibilities(info) {
+			logger.Infof("WARNING: image %q %s, it may not run on Lightsail", in.Image, problem)
+		}
+	}
+
+	return nil
+}
+
+// checkRegistryRepo makes sure that repo, unless it's empty for
+// DefaultRegistryRepo, is a repo path that image references can have,
+// e.g. "sr" or "test/repo", with no tag or digest.
+func checkRegistryRepo(repo string) error {
+	if repo == "" {
+		return nil
+	}
+	const host = "localhost"
+	named, err := reference.ParseNamed(host + "/" + repo)
+	if err != nil || named.Name() != host+"/"+repo {
+		return fmt.Errorf("registry repo %q is invalid: it must be a repo path like %q", repo, DefaultRegistryRepo)
+	}
+	return nil
+}
+
+// isDigestRef tells whether image is a reference by digest alone,
+// e.g. "nginx@sha256:..." or "sha256:...".
+func isDigestRef(image string) bool {
+	ref, err := reference.ParseAnyReference(image)
 	if err != nil {
-		return err
+		return false
+	}
+	_, digested := ref.(reference.Digested)
+	_, tagged := ref.(reference.Tagged)
+	return digested && !tagged
+}
+
+// tagPrefixRE matches what may precede the unique part of a tag:
+// Docker tags start with a word character, followed by word
+// characters, periods and dashes.
+var tagPrefixRE = regexp.MustCompile(`^[\w][\w.-]*$`)
+
+// maxTagPrefixLen leaves room for the unique part of a tag
+// within Docker's 128 character limit.
+const maxTagPrefixLen = 128 - len("-1593224653252075123-c5h66p35cpjmg")
+
+func checkTagPrefix(prefix string) error {
+	switch {
+	case prefix == "":
+		return nil
+	case !tagPrefixRE.MatchString(prefix):
+		return fmt.Errorf("tag prefix %q is invalid: it must start with a letter, digit or underscore, "+
+			"and contain only letters, digits, underscores, periods and dashes", prefix)
+	case len(prefix) > maxTagPrefixLen:
+		return fmt.Errorf("tag prefix %q is too long: it must be at most %d characters", prefix, maxTagPrefixLen)
 	}
+	return nil
+}
+
+// These errors tell which step of PushImage and PushImages failed,
+// use errors.Is to find out. The returned errors keep the messages
+// of the underlying errors, which errors.As can find as well,
+// e.g. *LocalImageNotFoundError, or *smithy.OperationError of AWS API.
+// ErrEngineUnavailable is likewise returned by NewDockerEngine
+// and NewPodmanEngine.
+var (
+	ErrEngineUnavailable = errors.New("container engine is not available")
+	ErrLoginFailed       = errors.New("registry login failed")
+	ErrTagFailed         = errors.New("image tagging failed")
+	ErrPushFailed        = errors.New("image push failed")
+	ErrRegisterFailed    = errors.New("image registration failed")
+	ErrSignatureInvalid  = errors.New("image signature verification failed")
+)
+
+// stepError marks err as the failure of step, one of the errors above.
+type stepError struct {
+	step error
+	err  error
+}
+
+func (e *stepError) Error() string {
+	return e.err.Error()
+}
 
-	fmt.Printf("Digest: %s\nImage %q registered.\nRefer to this image as %q in deployments.\n",
-		aws.ToString(registered.ContainerImage.Digest),
-		in.Image,
-		aws.ToString(registered.ContainerImage.Image))
+func (e *stepError) Unwrap() []error {
+	return []error{e.step, e.err}
+}
+
+// LocalImageNotFoundError is returned when the image to push
+// does not exist locally.
+type LocalImageNotFoundError struct {
+	Image string
+}
+
+func (e *LocalImageNotFoundError) Error() string {
+	return fmt.Sprintf("local image %q not found; pull or build it first", e.Image)
+}
+
+// incompatibilities describes image characteristics that are unusual enough
+// to suspect that the image was built with experimental features.
+func incompatibilities(info types.ImageInspect) []string {
+	var problems []string
+	if t := info.RootFS.Type; t != "" && t != "layers" {
+		problems = append(problems, fmt.Sprintf("has root file system of unsupported type %q", t))
+	}
+	if info.Os != "" && info.Os != "linux" {
+		problems = append(problems, fmt.Sprintf("is built for %q operating system", info.Os))
+	}
+	return problems
+}
 
+// pushAndRegister pushes the image using authConfig and then registers it.
+// The result is nil in dry runs.
+func pushAndRegister(
+	ctx context.Context,
+	logger *internal.Logger,
+	in *PushImageInput,
+	lio LightsailImageOperator,
+	imgo ImageOperator,
+	authConfig *registry.AuthConfig,
+) (*PushImageResult, error) {
+	if err := resolveAutoLabel(ctx, in, lio); err != nil {
+		return nil, err
+	}
+
+	// Images that aren't loaded from their source in dry runs
+	// have no digest to look for.
+	if in.IfNotPresent && !(in.DryRun && in.fromSource()) {
+		digest, existing, err := findRegisteredImage(ctx, in, lio, imgo)
+		if err != nil {
+			return nil, err
+		}
+		// The image is in the service registry already, so the labels
+		// it's missing from need no push, only registering.
+		var missing []string
+		for _, label := range append([]string{in.Label}, in.ExtraLabels...) {
+			if existing[label] == "" {
+				missing = append(missing, label)
+			}
+		}
+		switch {
+		case digest == "":
+		case in.DryRun && len(missing) > 0:
+			fmt.Fprintf(in.output(), "Dry run: image %q is already pushed to service %q and would be registered under labels %q.\n",
+				in.Image, in.Service, missing)
+			return nil, nil
+		default:
+			return registerDigest(ctx, logger, in, lio, digest, existing)
+		}
+	}
+
+	if in.DryRun && in.fromSource() {
+		image := in.SourcePath
+		if in.Image != "" {
+			image = fmt.Sprintf("%q in %s", in.Image, in.SourcePath)
+		}
+		fmt.Fprintf(in.output(), "Dry run: image %s would be loaded, pushed to %s and registered with service %q under label %q.\n",
+			image, authConfig.ServerAddress, in.Service, in.Label)
+		return nil, nil
+	}
+	if in.DryRun {
+		fmt.Fprintf(in.output(), "Dry run: image %q would be pushed to %s and registered with service %q under label %q.\n",
+			in.Image, authConfig.ServerAddress, in.Service, in.Label)
+		return nil, nil
+	}
+
+	tag, err := generateUniqueTag(in.TagPrefix)
+	if err != nil {
+		return nil, &stepError{step: ErrTagFailed, err: err}
+	}
+	remoteImage := RemoteImage{
+		AuthConfig: *authConfig,
+		Tag:        tag,
+		Platform:   in.Platform,
+	}
+
+	source := in.Image
+	if in.localID != "" {
+		source = in.localID
+	}
+	err = imgo.TagImage(ctx, source, remoteImage.Ref())
+	if err != nil {
+		return nil, &stepError{step: ErrTagFailed, err: err}
+	}
+	if in.KeepLocalTag {
+		defer fmt.Fprintf(in.output(), "Local tag %q was kept.\n", remoteImage.Ref())
+	} else {
+		defer tryUntagImage(ctx, logger, imgo, remoteImage.Ref())
+	}
+
+	in.Events.Emit(internal.Event{Type: internal.EventPushStarted, Service: in.Service, Image: in.Image})
+	pushed, err := pushImage(ctx, logger, in, imgo, remoteImage)
+	if err != nil {
+		return nil, &stepError{step: ErrPushFailed, err: err}
+	}
+	if pushed.NewLayers+pushed.ExistingLayers > 0 {
+		fmt.Fprintln(in.output(), pushed)
+	}
+
+	return registerDigest(ctx, logger, in, lio, pushed.Digest, nil)
+}
+
+// registerDigest registers the image with digest, which is in the
+// service registry, under in.Label and in.ExtraLabels, reusing
+// the references of existing, by label, instead of registering them again.
+//
+// When a label fails to register, the result lists the references
+// registered or found before it, along with the error, so that they can
+// be rolled back; it's nil when there are none.
+func registerDigest(
+	ctx context.Context,
+	logger *internal.Logger,
+	in *PushImageInput,
+	lio LightsailImageOperator,
+	digest string,
+	existing map[string]string,
+) (*PushImageResult, error) {
+	image := in.Image
+	if in.RegisterOnly {
+		image = digest
+	}
+	account, where := destination(ctx, in)
+	res := &PushImageResult{
+		Image:   image,
+		Digest:  digest,
+		Account: account,
+		Region:  in.Region,
+	}
+
+	announced := false
+	for i, label := range append([]string{in.Label}, in.ExtraLabels...) {
+		ref, found := existing[label]
+		if found {
+			fmt.Fprintf(in.output(), "Image %q is already registered as %q%s, it is up to date.\n", in.Image, ref, where)
+			res.ExistingReferences = append(res.ExistingReferences, ref)
+		} else {
+			l := *in
+			l.Label = label
+			var err error
+			ref, err = registerLabel(ctx, logger, &l, lio, digest)
+			if err != nil {
+				if i == 0 {
+					return nil, err
+				}
+				return res, err
+			}
+			if !announced {
+				fmt.Fprintf(in.output(), "Digest: %s\nImage %q registered%s.\n", res.Digest, res.Image, where)
+				announced = true
+			}
+			fmt.Fprintf(in.output(), "Refer to this image as %q in deployments.\n", ref)
+			in.Events.Emit(internal.Event{
+				Type:      internal.EventRegistered,
+				Service:   in.Service,
+				Image:     res.Image,
+				Reference: ref,
+				Digest:    digest,
+			})
+		}
+		if i == 0 {
+			res.Reference = ref
+		} else {
+			res.ExtraReferences = append(res.ExtraReferences, ref)
+		}
+	}
+	res.AlreadyRegistered = !announced
+	return res, nil
+}
+
+// registerLabel registers the image with digest under in.Label
+// and returns its reference.
+func registerLabel(
+	ctx context.Context,
+	logger *internal.Logger,
+	in *PushImageInput,
+	lio LightsailImageOperator,
+	digest string,
+) (string, error) {
+	registered, err := registerImage(ctx, logger, in, lio, digest)
+	if err != nil {
+		return "", &stepError{step: ErrRegisterFailed, err: err}
+	}
+	if registered.ContainerImage == nil {
+		return "", &stepError{
+			step: ErrRegisterFailed,
+			err:  errors.New("image registration response does not contain the container image"),
+		}
+	}
+	if got := aws.ToString(registered.ContainerImage.Digest); got != digest {
+		return "", &stepError{
+			step: ErrRegisterFailed,
+			err:  fmt.Errorf("registered image digest %q does not match pushed image digest %q", got, digest),
+		}
+	}
+	return aws.ToString(registered.ContainerImage.Image), nil
+}
+
+// resolveAutoLabel replaces AutoLabel in in.Label with the next free label,
+// which is looked up right before the image is registered, so that
+// the images of the same batch get labels of their own.
+func resolveAutoLabel(ctx context.Context, in *PushImageInput, l ImageLister) error {
+	if in.Label != AutoLabel {
+		return nil
+	}
+	label, err := nextLabel(ctx, in.Service, l)
+	if err != nil {
+		return err
+	}
+	in.Label = label
+	fmt.Fprintf(in.output(), "Using label %q.\n", label)
 	return nil
 }
 
+// findRegisteredImage looks for the images registered with the service
+// under in.Label and in.ExtraLabels which have the same digest as the local
+// image. It returns that digest, or an empty string if there are none,
+// and the names of the images found, by label.
+//
+// The local image knows its digest only in the registries it was pushed to,
+// so this finds images that were pushed to the service before.
+func findRegisteredImage(
+	ctx context.Context,
+	in *PushImageInput,
+	lio LightsailImageOperator,
+	imgo ImageOperator,
+) (string, map[string]string, error) {
+	info, err := imgo.InspectImage(ctx, in.Image)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(info.RepoDigests) == 0 {
+		return "", nil, nil
+	}
+
+	out, err := lio.GetContainerImages(ctx, &lightsail.GetContainerImagesInput{ServiceName: &in.Service})
+	if err != nil {
+		return "", nil, err
+	}
+
+	labels := append([]string{in.Label}, in.ExtraLabels...)
+	var digest string
+	found := map[string]string{}
+	prefix := ":" + in.Service + "."
+	for _, img := range out.ContainerImages {
+		name := aws.ToString(img.Image)
+		// Registered images are named ":service.label.version".
+		rest, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+		label, _, ok := strings.Cut(rest, ".")
+		if !ok || !slices.Contains(labels, label) || found[label] != "" {
+			continue
+		}
+		d := aws.ToString(img.Digest)
+		if digest != "" && d != digest {
+			continue
+		}
+		for _, rd := range info.RepoDigests {
+			if _, local, _ := strings.Cut(rd, "@"); local == d {
+				digest = d
+				found[label] = name
+				break
+			}
+		}
+	}
+	return digest, found, nil
+}
+
+// checkExposedPorts returns an error if the image config has no EXPOSE ports.
+func checkExposedPorts(image string, info types.ImageInspect) error {
+	if info.Config != nil && len(info.Config.ExposedPorts) > 0 {
+		return nil
+	}
+	return fmt.Errorf("image %q does not expose any ports: "+
+		"confirm that the container listens on the port expected by your public endpoint "+
+		"and declare it with EXPOSE", image)
+}
+
+// pushImage calls imgo.PushImage up to in.PushAttempts times,
+// each attempt limited by in.PushAttemptTimeout, and all of them
+// by in.PushTimeout.
+func pushImage(
+	ctx context.Context,
+	logger *internal.Logger,
+	in *PushImageInput,
+	imgo ImageOperator,
+	remoteImage RemoteImage,
+) (PushSummary, error) {
+	if in.PushTimeout <= 0 {
+		return pushImageAttempts(ctx, logger, in, imgo, remoteImage)
+	}
+	pushCtx, cancel := context.WithTimeout(ctx, in.PushTimeout)
+	defer cancel()
+	pushed, err := pushImageAttempts(pushCtx, logger, in, imgo, remoteImage)
+	if err != nil && ctx.Err() == nil && errors.Is(pushCtx.Err(), context.DeadlineExceeded) {
+		return PushSummary{}, fmt.Errorf("push timed out after %v: %w", in.PushTimeout, err)
+	}
+	return pushed, err
+}
+
+// pushImageAttempts is pushImage without in.PushTimeout.
+func pushImageAttempts(
+	ctx context.Context,
+	logger *internal.Logger,
+	in *PushImageInput,
+	imgo ImageOperator,
+	remoteImage RemoteImage,
+) (PushSummary, error) {
+	for attempt := 1; ; attempt++ {
+		pushed, err := pushImageAttempt(ctx, in.PushAttemptTimeout, imgo, remoteImage)
+		// An image without the platform won't get it by trying again.
+		var pe *platformError
+		if err == nil || attempt >= in.PushAttempts || ctx.Err() != nil || errors.As(err, &pe) {
+			return pushed, err
+		}
+		delay := time.Duration(attempt) * time.Second
+		logger.Debugf("push attempt %d of %d failed, will retry in %v: %v", attempt, in.PushAttempts, delay, err)
+		if err := sleep(ctx, delay); err != nil {
+			return PushSummary{}, err
+		}
+	}
+}
+
+func pushImageAttempt(
+	ctx context.Context,
+	timeout time.Duration,
+	imgo ImageOperator,
+	remoteImage RemoteImage,
+) (PushSummary, error) {
+	if timeout <= 0 {
+		return imgo.PushImage(ctx, remoteImage)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	pushed, err := imgo.PushImage(attemptCtx, remoteImage)
+	if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		return PushSummary{}, fmt.Errorf("push attempt timed out after %v: %w", timeout, err)
+	}
+	return pushed, err
+}
+
+// registerThrottledRetries is how many times registerImage retries
+// a throttled registration. The image is uploaded by then, so retrying
+// is much cheaper than failing the whole push.
+const registerThrottledRetries = 4
+
+// registerImage calls RegisterContainerImage, retrying it within
+// in.RegisterGracePeriod for as long as the service is not ready,
+// and up to registerThrottledRetries times when it's throttled.
+// Retries back off exponentially, unless the response suggests
+// how long to wait with Retry-After header.
+func registerImage(
+	ctx context.Context,
+	logger *internal.Logger,
+	in *PushImageInput,
+	lio LightsailImageOperator,
+	digest string,
+) (*lightsail.RegisterContainerImageOutput, error) {
+	delay, waited, throttled := time.Second, time.Duration(0), 0
+	for {
+		out, err := lio.RegisterContainerImage(
+			ctx,
+			&lightsail.RegisterContainerImageInput{
+				ServiceName: &in.Service,
+				Label:       &in.Label,
+				Digest:      &digest,
+			},
+		)
+		wait := delay
+		if d, ok := retryAfter(err); ok {
+			wait = d
+		}
+		switch {
+		case err == nil:
+			return out, nil
+		case isThrottled(err) && throttled < registerThrottledRetries:
+			throttled++
+			logger.Debugf("image registration is throttled, will retry in %v: %v", wait, err)
+		case isServiceNotReady(err) && waited+wait <= in.RegisterGracePeriod:
+			waited += wait
+			logger.Debugf("service %q is not ready, will retry image registration in %v: %v", in.Service, wait, err)
+		default:
+			return out, err
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+		delay *= 2
+	}
+}
+
+// retryAfter returns the delay suggested by Retry-After header
+// of the HTTP response that err came with, if there is one.
+func retryAfter(err error) (time.Duration, bool) {
+	var re *smithyhttp.ResponseError
+	if !errors.As(err, &re) || re.Response == nil || re.Response.Response == nil {
+		return 0, false
+	}
+	v := re.Response.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now()), 0), true
+	}
+	return 0, false
+}
+
+// isThrottled tells whether err means that the request was rejected
+// because of too many requests.
+func isThrottled(err error) bool {
+	var re *smithyhttp.ResponseError
+	if errors.As(err, &re) && re.HTTPStatusCode() == http.StatusTooManyRequests {
+		return true
+	}
+	var ae smithy.APIError
+	if !errors.As(err, &ae) {
+		return false
+	}
+	switch ae.ErrorCode() {
+	case "ThrottlingException", "TooManyRequestsException", "Throttling", "RequestLimitExceeded":
+		return true
+	}
+	return false
+}
+
+// isServiceNotReady tells whether err means that the container service
+// can't accept images yet, which happens shortly after it's created.
+// A service that doesn't exist, e.g. a misspelt one, is not one of those.
+func isServiceNotReady(err error) bool {
+	var ae smithy.APIError
+	if !errors.As(err, &ae) {
+		return false
+	}
+	switch ae.ErrorCode() {
+	case "NotFoundException", "InvalidInputException":
+		msg := strings.ToLower(ae.ErrorMessage())
+		return strings.Contains(msg, "not ready") || strings.Contains(msg, "pending")
+	}
+	return false
+}
+
+// IsAccessDenied tells whether err means that IAM didn't allow the request,
+// as opposed to it failing otherwise, e.g. because it was throttled.
+func IsAccessDenied(err error) bool {
+	var ae smithy.APIError
+	if !errors.As(err, &ae) {
+		return false
+	}
+	switch ae.ErrorCode() {
+	case "AccessDeniedException", "AccessDenied", "UnauthorizedException", "UnauthorizedOperation":
+		return true
+	}
+	return false
+}
+
+// loginDeniedError is a registry login that IAM didn't allow,
+// which new users run into before they know what's missing.
+type loginDeniedError struct {
+	err error
+}
+
+func (e *loginDeniedError) Error() string {
+	return fmt.Sprintf("not allowed to log in to the service registry: the IAM identity in use needs "+
+		"the lightsail:CreateContainerServiceRegistryLogin permission, see %s: %v", iamDocsURL, e.err)
+}
+
+func (e *loginDeniedError) Unwrap() error {
+	return e.err
+}
+
+// iamDocsURL tells how to give IAM users access to Lightsail.
+const iamDocsURL = "https://docs.aws.amazon.com/lightsail/latest/userguide/amazon-lightsail-managing-access-for-an-iam-user.html"
+
+// RegistryLogin is a registry login along with its expiration time.
+type RegistryLogin struct {
+	registry.AuthConfig
+	// ExpiresAt is when the credentials stop working, zero if unknown.
+	ExpiresAt time.Time
+}
+
+// expiresWithin tells whether the login expires less than d from now.
+func (l *RegistryLogin) expiresWithin(d time.Duration) bool {
+	return !l.ExpiresAt.IsZero() && now().Add(d).After(l.ExpiresAt)
+}
+
+// loginRefreshMargin is how long before the expiration a registry login
+// gets replaced, so that it doesn't expire in the middle of a push.
+const loginRefreshMargin = 15 * time.Minute
+
+// registryLogins creates a registry login when it's first needed
+// and then reuses it until it's about to expire.
+type registryLogins struct {
+	rlc    RegistryLoginCreator
+	repo   string
+	login  *RegistryLogin
+	events *internal.Events
+}
+
+func (l *registryLogins) get(ctx context.Context, logger *internal.Logger, region string) (*registry.AuthConfig, error) {
+	if l.login != nil && !l.login.expiresWithin(loginRefreshMargin) {
+		return &l.login.AuthConfig, nil
+	}
+	if l.login != nil {
+		logger.Debugf("registry login expires at %v, creating a new one", l.login.ExpiresAt)
+	}
+	login, err := getServiceRegistryAuth(ctx, logger, l.rlc, region, l.repo)
+	if err != nil {
+		return nil, err
+	}
+	l.login = login
+	l.events.Emit(internal.Event{Type: internal.EventLoginCreated, Registry: login.ServerAddress})
+	return &login.AuthConfig, nil
+}
+
+// DefaultRegistryRepo is the repo of the service registry
+// that images are pushed to, unless PushImageInput.RegistryRepo
+// says otherwise.
+const DefaultRegistryRepo = "sr"
+
 // getServiceRegistryAuth returns the server address and
 // the temporary credentials sufficient to push images to
-// Lightsail Containers service repo (aka "sr").
+// Lightsail Containers service repo (aka "sr"), or to another
+// repo of the service registry.
 //
 // Note that "sr" repo only retains image tags generated
 // when RegisterContainerImage API is called with specific image
 // digests. The purpose of this repo is to keep images that are
 // strictly related to your Lightsail container service deployments.
-func getServiceRegistryAuth(ctx context.Context, rlc RegistryLoginCreator) (*registry.AuthConfig, error) {
+func getServiceRegistryAuth(
+	ctx context.Context,
+	logger *internal.Logger,
+	rlc RegistryLoginCreator,
+	region string,
+	repo string,
+) (*RegistryLogin, error) {
+	if repo == "" {
+		return nil, errors.New("registry repo is empty")
+	}
 	out, err := rlc.CreateContainerServiceRegistryLogin(
 		ctx,
 		new(lightsail.CreateContainerServiceRegistryLoginInput),
 	)
 	if err != nil {
-		return nil, err
+		if IsAccessDenied(err) {
+			err = &loginDeniedError{err: err}
+		}
+		return nil, &stepError{step: ErrLoginFailed, err: err}
+	}
+
+	host := aws.ToString(out.RegistryLogin.Registry)
+	if got, ok := registryRegion(host); ok && region != "" && got != region {
+		logger.Infof("WARNING: service registry %s is in region %q, but region %q is configured; "+
+			"check the endpoint and region settings", host, got, region)
 	}
 
-	return &registry.AuthConfig{
-		Username:      aws.ToString(out.RegistryLogin.Username),
-		Password:      aws.ToString(out.RegistryLogin.Password),
-		ServerAddress: aws.ToString(out.RegistryLogin.Registry) + "/sr",
+	return &RegistryLogin{
+		AuthConfig: registry.AuthConfig{
+			Username:      aws.ToString(out.RegistryLogin.Username),
+			Password:      aws.ToString(out.RegistryLogin.Password),
+			ServerAddress: host + "/" + repo,
+		},
+		ExpiresAt: aws.ToTime(out.RegistryLogin.ExpiresAt),
 	}, nil
 }
 
+// registryRegion extracts the region from an ECR registry host,
+// such as "123456789012.dkr.ecr.us-west-2.amazonaws.com".
+// It returns false for hosts that don't look like that.
+func registryRegion(host string) (string, bool) {
+	labels := strings.Split(host, ".")
+	for i := 0; i+2 < len(labels); i++ {
+		if labels[i] == "dkr" && labels[i+1] == "ecr" && labels[i+2] != "" {
+			return labels[i+2], true
+		}
+	}
+	return "", false
+}
+
 // tryUntagImage is the same as ImageOperator.UntagImage
-// except it doesn't return error and instead logs it.
-func tryUntagImage(ctx context.Context, imgo ImageOperator, image string) {
-	if err := imgo.UntagImage(ctx, image); err != nil {
-		log.Println(err)
+// except it doesn't return error and instead logs it to logger.
+// Failing to remove the temporary tag is harmless, so this
+// isn't worth bothering users with unless they are debugging.
+// The tag is removed even when ctx is canceled, e.g. by Ctrl-C
+// during the push, so that it doesn't stay behind.
+func tryUntagImage(ctx context.Context, logger *internal.Logger, imgo ImageOperator, image string) {
+	if err := imgo.UntagImage(context.WithoutCancel(ctx), image); err != nil {
+		logger.Debugf("could not remove temporary tag: %v", err)
 	}
 }
 
-func generateUniqueTag() string {
-	now := time.Now()
+// generateUniqueTag returns a tag that is unique enough not to collide
+// with other pushes, preceded by prefix, if it's not empty.
+func generateUniqueTag(prefix string) (string, error) {
+	name, err := randomName13()
+	if err != nil {
+		return "", fmt.Errorf("could not generate a unique tag: %w", err)
+	}
+	tag := fmt.Sprintf("%v-%s", now().UnixNano(), name)
+	if prefix != "" {
+		tag = prefix + "-" + tag
+	}
+	return tag, nil
+}
+
+func now() time.Time {
 	if testNow != nil {
-		now = testNow()
+		return testNow()
 	}
-	return fmt.Sprintf("%v-%s", now.UnixNano(), randomName13())
+	return time.Now()
 }
 
-func randomName13() string {
+// randomName13 returns 13 random characters, or an error
+// if the entropy source runs dry.
+func randomName13() (string, error) {
 	r := rand.Reader
 	if testRngReader != nil {
 		r = testRngReader
@@ -136,9 +1359,24 @@ func randomName13() string {
 
 	b := make([]byte, 8)
 	if _, err := io.ReadFull(r, b); err != nil {
-		panic(err)
+		return "", fmt.Errorf("could not read random bytes: %w", err)
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// sleep pauses for d, or less if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if testSleep != nil {
+		return testSleep(ctx, d)
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
 	}
-	return b32.EncodeToString(b)
 }
 
 var (
@@ -146,4 +1384,5 @@ var (
 
 	testNow       func() time.Time
 	testRngReader io.Reader
+	testSleep     func(context.Context, time.Duration) error
 )
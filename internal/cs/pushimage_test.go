@@ -4,8 +4,14 @@
 package cs
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/http"
 	"reflect"
 	"strconv"
 	"strings"
@@ -13,9 +19,17 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/lightsail"
 	"github.com/aws/aws-sdk-go-v2/service/lightsail/types"
+	"github.com/aws/lightsailctl/internal"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/distribution/reference"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/go-connections/nat"
 )
 
 func TestGenerateUniqueTag(t *testing.T) {
@@ -23,30 +37,194 @@ func TestGenerateUniqueTag(t *testing.T) {
 		testNow, testRngReader = nil, nil
 	}()
 	testNow = func() time.Time { return time.Unix(0, 1593224653252075123) }
-	testRngReader = strings.NewReader("abcdefgh")
-	if want, got := "1593224653252075123-c5h66p35cpjmg", generateUniqueTag(); got != want {
-		t.Errorf("got %q, want %q", got, want)
+	for prefix, want := range map[string]string{
+		"":        "1593224653252075123-c5h66p35cpjmg",
+		"ci-1234": "ci-1234-1593224653252075123-c5h66p35cpjmg",
+	} {
+		testRngReader = strings.NewReader("abcdefgh")
+		if got, err := generateUniqueTag(prefix); err != nil || got != want {
+			t.Errorf("got %q, %v, want %q", got, err, want)
+		}
+	}
+
+	// A short read of the entropy source is an error, not a panic.
+	testRngReader = strings.NewReader("abc")
+	in := &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "www"}
+	_, err := PushImage(context.Background(), discardLog, in, &fakeLightsailImageOperator{}, &fakeImageOperator{})
+	if !errors.Is(err, ErrTagFailed) || !strings.Contains(err.Error(), "could not generate a unique tag") {
+		t.Errorf("got err: %v", err)
+	}
+}
+
+func TestCheckTagPrefix(t *testing.T) {
+	repo, err := reference.ParseNamed("example.com/sr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range []struct {
+		prefix string
+		pass   bool
+	}{
+		{"", true},
+		{"build", true},
+		{"ci-1234", true},
+		{"release_1.2", true},
+		{"_x", true},
+		{strings.Repeat("a", maxTagPrefixLen), true},
+		{strings.Repeat("a", maxTagPrefixLen+1), false},
+		{"-build", false},
+		{".build", false},
+		{"build/42", false},
+		{"build:42", false},
+		{"build 42", false},
+		{"b\u00fcild", false},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			err := checkTagPrefix(test.prefix)
+			if (err == nil) != test.pass {
+				t.Errorf("%q: got err: %v", test.prefix, err)
+			}
+			if test.pass {
+				// Valid prefixes make valid tags.
+				tag, err := generateUniqueTag(test.prefix)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := reference.WithTag(repo, tag); err != nil {
+					t.Errorf("%q: %v", test.prefix, err)
+				}
+			}
+		})
 	}
 }
 
 func TestGetServiceRegistryAuth(t *testing.T) {
 	ctx := context.Background()
-	if got, err := getServiceRegistryAuth(ctx, &fakeRegistryLoginCreator{failToCreateLogin: true}); err == nil || got != nil {
+	if got, err := getServiceRegistryAuth(ctx, nil, &fakeRegistryLoginCreator{failToCreateLogin: true}, "", DefaultRegistryRepo); err == nil || got != nil {
 		t.Errorf("got err: %v", err)
 		t.Errorf("got out: %#v", got)
 	}
 
-	want := &registry.AuthConfig{
+	want := &RegistryLogin{AuthConfig: registry.AuthConfig{
 		Username:      "gollum",
 		Password:      "precious",
 		ServerAddress: "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr",
-	}
-	if got, err := getServiceRegistryAuth(ctx, &fakeRegistryLoginCreator{}); err != nil {
+	}}
+	if got, err := getServiceRegistryAuth(ctx, nil, &fakeRegistryLoginCreator{}, "", DefaultRegistryRepo); err != nil {
 		t.Errorf("got err: %v", err)
 	} else if !reflect.DeepEqual(got, want) {
 		t.Errorf("got: %#v", got)
 		t.Logf("want: %#v", want)
 	}
+
+	if got, err := getServiceRegistryAuth(ctx, nil, &fakeRegistryLoginCreator{}, "", "test/repo"); err != nil {
+		t.Errorf("got err: %v", err)
+	} else if want := "123456789012.dkr.ecr.so-fake-2.amazonaws.com/test/repo"; got.ServerAddress != want {
+		t.Errorf("got server address %q, want %q", got.ServerAddress, want)
+	}
+
+	if got, err := getServiceRegistryAuth(ctx, nil, &fakeRegistryLoginCreator{}, "", ""); err == nil || got != nil {
+		t.Errorf("got err: %v", err)
+		t.Errorf("got out: %#v", got)
+	}
+}
+
+func TestCheckRegistryRepo(t *testing.T) {
+	for i, test := range []struct {
+		repo string
+		pass bool
+	}{
+		{"", true},
+		{"sr", true},
+		{"test/repo", true},
+		{"test-repo_1", true},
+		{"/sr", false},
+		{"sr/", false},
+		{"test//repo", false},
+		{"SR", false},
+		{"sr:latest", false},
+		{"sr@sha256:" + strings.Repeat("0", 64), false},
+		{"s r", false},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			if err := checkRegistryRepo(test.repo); (err == nil) != test.pass {
+				t.Errorf("%q: got err: %v", test.repo, err)
+			}
+		})
+	}
+}
+
+func TestGetServiceRegistryAuthDenied(t *testing.T) {
+	ctx := context.Background()
+	for i, test := range []struct {
+		err        error
+		wantDenied bool
+	}{
+		{&smithy.OperationError{
+			ServiceID:     "Lightsail",
+			OperationName: "CreateContainerServiceRegistryLogin",
+			Err:           &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized"},
+		}, true},
+		{&smithy.GenericAPIError{Code: "UnauthorizedException"}, true},
+		{&smithy.GenericAPIError{Code: "ServiceException"}, false},
+		{errors.New("connection reset"), false},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			_, err := getServiceRegistryAuth(ctx, nil, &fakeRegistryLoginCreator{loginErr: test.err}, "", DefaultRegistryRepo)
+			if !errors.Is(err, ErrLoginFailed) || !errors.Is(err, test.err) {
+				t.Fatalf("got err: %v", err)
+			}
+			denied := strings.Contains(err.Error(), "lightsail:CreateContainerServiceRegistryLogin permission, see "+iamDocsURL)
+			if denied != test.wantDenied {
+				t.Errorf("got err: %v", err)
+			}
+			var ae smithy.APIError
+			if test.wantDenied && !errors.As(err, &ae) {
+				t.Errorf("err doesn't wrap the API error: %v", err)
+			}
+		})
+	}
+}
+
+func TestRegistryRegionCheck(t *testing.T) {
+	stdLog := new(bytes.Buffer)
+	logger := internal.NewLogger(log.New(stdLog, "", 0), internal.LevelInfo)
+
+	ctx := context.Background()
+	for i, test := range []struct {
+		region, wantWarning string
+	}{
+		{region: ""},
+		{region: "so-fake-2"},
+		{
+			region:      "us-east-1",
+			wantWarning: `WARNING: service registry 123456789012.dkr.ecr.so-fake-2.amazonaws.com is in region "so-fake-2", but region "us-east-1" is configured`,
+		},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			stdLog.Reset()
+			if _, err := getServiceRegistryAuth(ctx, logger, &fakeRegistryLoginCreator{}, test.region, DefaultRegistryRepo); err != nil {
+				t.Fatal(err)
+			}
+			if test.wantWarning == "" && stdLog.Len() != 0 {
+				t.Errorf("unexpected log: %q", stdLog)
+			}
+			if !strings.Contains(stdLog.String(), test.wantWarning) {
+				t.Errorf("got log %q, that doesn't contain %q", stdLog, test.wantWarning)
+			}
+		})
+	}
+
+	for host, want := range map[string]string{
+		"123456789012.dkr.ecr.us-west-2.amazonaws.com":     "us-west-2",
+		"123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn": "cn-north-1",
+		"localhost:5000":       "",
+		"registry.example.com": "",
+	} {
+		if got, ok := registryRegion(host); got != want || ok != (want != "") {
+			t.Errorf("%s: got %q, %v", host, got, ok)
+		}
+	}
 }
 
 func TestPushImageErrors(t *testing.T) {
@@ -60,34 +238,46 @@ func TestPushImageErrors(t *testing.T) {
 		ls   fakeLightsailImageOperator
 		imgo fakeImageOperator
 		want string
+		// wantIs is the step that failed.
+		wantIs error
 	}
 	ctx := context.Background()
 	in := &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "www"}
 	for i, test := range []test{
 		{
-			ls:   fakeLightsailImageOperator{fakeRegistryLoginCreator: fakeRegistryLoginCreator{failToCreateLogin: true}},
-			want: "failed: create login",
+			ls:     fakeLightsailImageOperator{fakeRegistryLoginCreator: fakeRegistryLoginCreator{failToCreateLogin: true}},
+			want:   "failed: create login",
+			wantIs: ErrLoginFailed,
 		},
 		{
-			ls:   fakeLightsailImageOperator{failToRegister: true},
-			want: "failed: register (doge, www, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa)",
+			ls:     fakeLightsailImageOperator{failToRegister: true},
+			want:   "failed: register (doge, www, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa)",
+			wantIs: ErrRegisterFailed,
 		},
 		{
-			imgo: fakeImageOperator{failToTag: true},
-			want: `failed: tag "nginx:latest" as "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg"`,
+			ls: fakeLightsailImageOperator{registeredDigest: "sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+			want: `registered image digest "sha256:0000000000000000000000000000000000000000000000000000000000000000" ` +
+				`does not match pushed image digest "sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa"`,
+			wantIs: ErrRegisterFailed,
+		},
+		{
+			imgo:   fakeImageOperator{failToTag: true},
+			want:   `failed: tag "nginx:latest" as "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg"`,
+			wantIs: ErrTagFailed,
 		},
 		{
 			imgo: fakeImageOperator{failToUntag: true},
-			want: "", // Untagging errors are ignored in current implementation.
+			want: "", // Untagging errors don't fail the push, see TestPushImageUntagFailure.
 		},
 		{
-			imgo: fakeImageOperator{failToPush: true},
-			want: `failed: push "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg"`,
+			imgo:   fakeImageOperator{failToPush: true},
+			want:   `failed: push "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg"`,
+			wantIs: ErrPushFailed,
 		},
 	} {
 		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
 			testRngReader = strings.NewReader("abcdefgh")
-			err := PushImage(ctx, in, &test.ls, &test.imgo)
+			_, err := PushImage(ctx, discardLog, in, &test.ls, &test.imgo)
 			if err == nil && test.want == "" {
 				// succeeded as expected
 				return
@@ -100,6 +290,11 @@ func TestPushImageErrors(t *testing.T) {
 				t.Errorf("got: %v", err)
 				t.Logf("want: %v", test.want)
 			}
+			for _, step := range []error{ErrLoginFailed, ErrTagFailed, ErrPushFailed, ErrRegisterFailed} {
+				if errors.Is(err, step) != (step == test.wantIs) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, step, !(step == test.wantIs))
+				}
+			}
 		})
 	}
 }
@@ -114,7 +309,7 @@ func ExamplePushImage() {
 	ctx := context.Background()
 	fls := &fakeLightsailImageOperator{}
 	fimgo := &fakeImageOperator{}
-	if err := PushImage(ctx, &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "www"}, fls, fimgo); err != nil {
+	if _, err := PushImage(ctx, discardLog, &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "www"}, fls, fimgo); err != nil {
 		fmt.Println(err)
 		return
 	}
@@ -131,6 +326,7 @@ func ExamplePushImage() {
 	// Image "nginx:latest" registered.
 	// Refer to this image as ":doge.www.12345" in deployments.
 	// docker engine call log:
+	//   inspect "nginx:latest"
 	//   tag "nginx:latest" as "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611796436000000000-c5h66p35cpjmg"
 	//   push "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611796436000000000-c5h66p35cpjmg"
 	//   untag "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611796436000000000-c5h66p35cpjmg"
@@ -139,84 +335,1431 @@ func ExamplePushImage() {
 	//   register (doge, www, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa)
 }
 
-type fakeRegistryLoginCreator struct {
-	failToCreateLogin bool
-	log               []string
+func ExamplePushImage_keepLocalTag() {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611796436, 0) }
+	testRngReader = strings.NewReader("abcdefgh")
+
+	ctx := context.Background()
+	fimgo := &fakeImageOperator{}
+	in := &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "www", KeepLocalTag: true}
+	if _, err := PushImage(ctx, discardLog, in, &fakeLightsailImageOperator{}, fimgo); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("docker engine call log:")
+	for _, s := range fimgo.log {
+		fmt.Println(" ", s)
+	}
+	// Output:
+	// Digest: sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa
+	// Image "nginx:latest" registered.
+	// Refer to this image as ":doge.www.12345" in deployments.
+	// Local tag "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611796436000000000-c5h66p35cpjmg" was kept.
+	// docker engine call log:
+	//   inspect "nginx:latest"
+	//   tag "nginx:latest" as "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611796436000000000-c5h66p35cpjmg"
+	//   push "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611796436000000000-c5h66p35cpjmg"
 }
 
-func (f *fakeRegistryLoginCreator) CreateContainerServiceRegistryLogin(
-	context.Context,
-	*lightsail.CreateContainerServiceRegistryLoginInput,
-	...func(*lightsail.Options),
-) (*lightsail.CreateContainerServiceRegistryLoginOutput, error) {
-	op := "create login"
-	if f.failToCreateLogin {
-		return nil, fmt.Errorf("failed: %s", op)
+func TestPushImageLocalImageNotFound(t *testing.T) {
+	ctx := context.Background()
+	imgo := &fakeImageOperator{images: map[string]dockertypes.ImageInspect{}}
+	lio := &fakeLightsailImageOperator{}
+	_, err := PushImage(ctx, discardLog, &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "www"}, lio, imgo)
+
+	var notFound *LocalImageNotFoundError
+	if !errors.As(err, &notFound) || notFound.Image != "nginx:latest" {
+		t.Fatalf("got err: %v", err)
+	}
+	if want := `local image "nginx:latest" not found; pull or build it first`; err.Error() != want {
+		t.Errorf("got err: %v, want: %v", err, want)
+	}
+	if len(imgo.log) != 0 || len(lio.log) != 0 {
+		t.Errorf("unexpected calls: %q, %q", imgo.log, lio.log)
 	}
-	f.log = append(f.log, op)
-	return &lightsail.CreateContainerServiceRegistryLoginOutput{
-		RegistryLogin: &types.ContainerServiceRegistryLogin{
-			Username: aws.String("gollum"),
-			Password: aws.String("precious"),
-			Registry: aws.String("123456789012.dkr.ecr.so-fake-2.amazonaws.com"),
-		},
-	}, nil
 }
 
-type fakeLightsailImageOperator struct {
-	fakeRegistryLoginCreator
-	failToRegister bool
+func TestPushImageByDigest(t *testing.T) {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611800397, 0) }
+	testRngReader = strings.NewReader("abcdefgh")
+
+	const (
+		id     = "sha256:a6bd71f48f6839d9faae1f29d3babef831e76bc213107682c5cc80f0cbb30866"
+		digest = "sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa"
+	)
+	ctx := context.Background()
+	imgo := &fakeImageOperator{images: map[string]dockertypes.ImageInspect{
+		"nginx@" + digest: {ID: id, Os: "linux"},
+	}}
+	lio := &fakeLightsailImageOperator{}
+	in := &PushImageInput{Service: "doge", Image: "docker.io/library/nginx@" + digest, Label: "www"}
+	if _, err := PushImage(ctx, discardLog, in, lio, imgo); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`inspect "nginx@` + digest + `"`,
+		`tag "` + id + `" as "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg"`,
+		`push "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg"`,
+		`untag "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg"`,
+	}
+	if !reflect.DeepEqual(imgo.log, want) {
+		t.Errorf("got %q, want %q", imgo.log, want)
+	}
+
+	in = &PushImageInput{Service: "doge", Image: "nginx@sha256:b95cf9b496720e43b12ce435775d5e337a6648147825c0fc8fc0ff93616c69a0", Label: "www"}
+	var notFound *LocalImageNotFoundError
+	if _, err := PushImage(ctx, discardLog, in, lio, imgo); !errors.As(err, &notFound) || notFound.Image != in.Image {
+		t.Errorf("got err: %v", err)
+	}
 }
 
-func (f *fakeLightsailImageOperator) RegisterContainerImage(
-	_ context.Context,
-	in *lightsail.RegisterContainerImageInput,
-	_ ...func(*lightsail.Options),
-) (*lightsail.RegisterContainerImageOutput, error) {
-	op := fmt.Sprintf("register (%s, %s, %s)",
-		aws.ToString(in.ServiceName),
-		aws.ToString(in.Label),
-		aws.ToString(in.Digest))
-	if f.failToRegister {
-		return nil, fmt.Errorf("failed: %s", op)
+func TestIsDigestRef(t *testing.T) {
+	for image, want := range map[string]bool{
+		"nginx@sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa":           true,
+		"example.com/app@sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa": true,
+		"sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa":                 true,
+		"nginx:1@sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa":         false,
+		"nginx:latest":       false,
+		"nginx":              false,
+		"10b8cc432d56":       false,
+		"nginx@sha256:bogus": false,
+	} {
+		if got := isDigestRef(image); got != want {
+			t.Errorf("%s: got %v, want %v", image, got, want)
+		}
 	}
-	f.log = append(f.log, op)
-	return &lightsail.RegisterContainerImageOutput{
-		ContainerImage: &types.ContainerImage{
-			Digest: in.Digest,
-			Image:  aws.String(":" + aws.ToString(in.ServiceName) + "." + aws.ToString(in.Label) + ".12345"),
-		},
-	}, nil
 }
 
-type fakeImageOperator struct {
-	failToTag, failToUntag, failToPush bool
-	log                                []string
+func TestPushImageInvalidOptions(t *testing.T) {
+	ctx := context.Background()
+	for i, test := range []struct {
+		in   PushImageInput
+		want string
+	}{
+		{in: PushImageInput{TagPrefix: "-ci"}, want: `tag prefix "-ci" is invalid`},
+		{in: PushImageInput{Platform: "arm64"}, want: `invalid platform "arm64"`},
+		{in: PushImageInput{Platform: "linux//v8"}, want: `invalid platform "linux//v8"`},
+		{in: PushImageInput{RegistryRepo: "/sr"}, want: `registry repo "/sr" is invalid`},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			imgo := &fakeImageOperator{}
+			lio := &fakeLightsailImageOperator{}
+			in := test.in
+			in.Service, in.Image, in.Label = "doge", "nginx:latest", "www"
+			if _, err := PushImage(ctx, discardLog, &in, lio, imgo); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got err: %v, that doesn't contain %q", err, test.want)
+			}
+			if len(imgo.log) != 0 || len(lio.log) != 0 {
+				t.Errorf("unexpected calls: %q, %q", imgo.log, lio.log)
+			}
+		})
+	}
 }
 
-func (f *fakeImageOperator) TagImage(_ context.Context, source, target string) error {
-	op := fmt.Sprintf("tag %q as %q", source, target)
-	if f.failToTag {
-		return fmt.Errorf("failed: %s", op)
+func TestPushImageRegistryRepo(t *testing.T) {
+	ctx := context.Background()
+	buf := new(bytes.Buffer)
+	in := &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "www", DryRun: true, RegistryRepo: "test/repo", Output: buf}
+	if _, err := PushImage(ctx, discardLog, in, &fakeLightsailImageOperator{}, &fakeImageOperator{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "pushed to 123456789012.dkr.ecr.so-fake-2.amazonaws.com/test/repo and"; !strings.Contains(buf.String(), want) {
+		t.Errorf("got output %q, that doesn't contain %q", buf, want)
 	}
-	f.log = append(f.log, op)
-	return nil
 }
 
-func (f *fakeImageOperator) UntagImage(_ context.Context, image string) error {
-	op := fmt.Sprintf("untag %q", image)
-	if f.failToUntag {
-		return fmt.Errorf("failed: %s", op)
+func ExamplePushImage_dryRun() {
+	ctx := context.Background()
+	fls := &fakeLightsailImageOperator{}
+	fimgo := &fakeImageOperator{}
+	in := &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "www", DryRun: true}
+	if _, err := PushImage(ctx, discardLog, in, fls, fimgo); err != nil {
+		fmt.Println(err)
+		return
 	}
-	f.log = append(f.log, op)
-	return nil
+	fmt.Println("docker engine call log:", fimgo.log)
+	fmt.Println("lightsail api call log:", fls.log)
+
+	// Output:
+	// Dry run: image "nginx:latest" would be pushed to 123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr and registered with service "doge" under label "www".
+	// docker engine call log: [inspect "nginx:latest"]
+	// lightsail api call log: [create login]
 }
 
-func (f *fakeImageOperator) PushImage(_ context.Context, remoteImage RemoteImage) (string, error) {
-	op := fmt.Sprintf("push %q", remoteImage.Ref())
-	if f.failToPush {
-		return "", fmt.Errorf("failed: %s", op)
+func ExamplePushImage_registerOnly() {
+	ctx := context.Background()
+	fls := &fakeLightsailImageOperator{}
+	in := &PushImageInput{
+		Service:      "doge",
+		Label:        "www",
+		RegisterOnly: true,
+		Digest:       "sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa",
+	}
+	res, err := PushImage(ctx, discardLog, in, fls, nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("result: %+v\n", *res)
+	fmt.Println("lightsail api call log:", fls.log)
+
+	in.Digest = "sha256:10b8cc43"
+	_, err = PushImage(ctx, discardLog, in, fls, nil)
+	fmt.Println(err)
+
+	// Output:
+	// Digest: sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa
+	// Image "sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa" registered.
+	// Refer to this image as ":doge.www.12345" in deployments.
+	// result: {Image:sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa Digest:sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa Reference::doge.www.12345 ExtraReferences:[] ExistingReferences:[] AlreadyRegistered:false Account: Region:}
+	// lightsail api call log: [register (doge, www, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa)]
+	// image digest "sha256:10b8cc43" is invalid: it must be sha256: followed by 64 hexadecimal digits
+}
+
+func ExamplePushImage_autoLabel() {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611796436, 0) }
+	testRngReader = strings.NewReader("abcdefghijklmnopqrstuvwxyz")
+
+	ctx := context.Background()
+	fls := &fakeLightsailImageOperator{fakeImageLister: fakeImageLister{
+		"doge": {{Image: aws.String(":doge.www.3")}, {Image: aws.String(":doge.4.1")}},
+	}}
+	in := &PushImageInput{Service: "doge", Image: "nginx:latest", Label: AutoLabel}
+	if _, err := PushImage(ctx, discardLog, in, fls, &fakeImageOperator{}); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("lightsail api call log:", fls.log)
+
+	// Output:
+	// Using label "5".
+	// Digest: sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa
+	// Image "nginx:latest" registered.
+	// Refer to this image as ":doge.5.12345" in deployments.
+	// lightsail api call log: [create login register (doge, 5, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa)]
+}
+
+func ExamplePushImage_extraLabels() {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611796436, 0) }
+	testRngReader = strings.NewReader("abcdefgh")
+
+	ctx := context.Background()
+	fls := &fakeLightsailImageOperator{}
+	fimgo := &fakeImageOperator{}
+	in := &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "latest", ExtraLabels: []string{"v2"}}
+	res, err := PushImage(ctx, discardLog, in, fls, fimgo)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("references:", res.Reference, res.ExtraReferences)
+	fmt.Println("docker engine call log:", fimgo.log)
+	fmt.Println("lightsail api call log:", fls.log)
+
+	// Output:
+	// Digest: sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa
+	// Image "nginx:latest" registered.
+	// Refer to this image as ":doge.latest.12345" in deployments.
+	// Refer to this image as ":doge.v2.12345" in deployments.
+	// references: :doge.latest.12345 [:doge.v2.12345]
+	// docker engine call log: [inspect "nginx:latest" tag "nginx:latest" as "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611796436000000000-c5h66p35cpjmg" push "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611796436000000000-c5h66p35cpjmg" untag "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611796436000000000-c5h66p35cpjmg"]
+	// lightsail api call log: [create login register (doge, latest, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa) register (doge, v2, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa)]
+}
+
+func TestNormalizeImageRef(t *testing.T) {
+	const digest = "sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa"
+	for i, test := range []struct {
+		image, want string
+	}{
+		{image: "nginx", want: "nginx"},
+		{image: "nginx:1.27", want: "nginx:1.27"},
+		{image: "docker.io/library/nginx:1.27", want: "nginx:1.27"},
+		{image: "nginx:1.27@" + digest, want: "nginx:1.27"},
+		{image: "docker.io/library/nginx:1.27@" + digest, want: "nginx:1.27"},
+		{image: "nginx@" + digest, want: "nginx@" + digest},
+		{image: "localhost:5000/team/web:v2@" + digest, want: "localhost:5000/team/web:v2"},
+		{image: "10b8cc432d56", want: "10b8cc432d56"},
+		{image: digest, want: digest},
+		{image: "Not A Reference", want: "Not A Reference"},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			if got := normalizeImageRef(test.image); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPushImageNormalizesImage(t *testing.T) {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611796436, 0) }
+	testRngReader = strings.NewReader("abcdefgh")
+
+	ctx := context.Background()
+	debugBuf := new(bytes.Buffer)
+	image := "docker.io/library/nginx:latest@sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa"
+	in := &PushImageInput{Service: "doge", Image: image, Label: "www", RequireExposedPorts: true}
+	imgo := &fakeImageOperator{images: map[string]dockertypes.ImageInspect{
+		"nginx:latest": {Config: &container.Config{ExposedPorts: nat.PortSet{"80/tcp": {}}}},
+	}}
+	if _, err := PushImage(ctx, internal.NewLogger(log.New(debugBuf, "", 0), internal.LevelDebug), in, &fakeLightsailImageOperator{}, imgo); err != nil {
+		t.Fatal(err)
+	}
+	if in.Image != image {
+		t.Errorf("input was modified: %q", in.Image)
+	}
+	want := []string{
+		`inspect "nginx:latest"`,
+		`tag "nginx:latest" as "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611796436000000000-c5h66p35cpjmg"`,
+	}
+	if !reflect.DeepEqual(imgo.log[:2], want) {
+		t.Errorf("got: %q", imgo.log)
+		t.Logf("want: %q", want)
+	}
+	if want := `image "` + image + `" is normalized to "nginx:latest"`; !strings.Contains(debugBuf.String(), want) {
+		t.Errorf("got debug log %q, that doesn't contain %q", debugBuf, want)
+	}
+}
+
+func TestRequireExposedPorts(t *testing.T) {
+	imgo := &fakeImageOperator{images: map[string]dockertypes.ImageInspect{
+		"web:latest":  {Config: &container.Config{ExposedPorts: nat.PortSet{"80/tcp": {}}}},
+		"batch:1":     {Config: &container.Config{}},
+		"scratch:old": {},
+	}}
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		image string
+		pass  bool
+	}{
+		{image: "web:latest", pass: true},
+		{image: "batch:1"},
+		{image: "scratch:old"},
+	} {
+		in := &PushImageInput{Service: "doge", Image: test.image, Label: "www", RequireExposedPorts: true}
+		_, err := PushImage(ctx, discardLog, in, &fakeLightsailImageOperator{}, imgo)
+		if test.pass {
+			if err != nil {
+				t.Errorf("%s: %v", test.image, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "does not expose any ports") {
+			t.Errorf("%s: got err: %v", test.image, err)
+		}
+	}
+
+	// The check is off by default.
+	in := &PushImageInput{Service: "doge", Image: "batch:1", Label: "www"}
+	if _, err := PushImage(ctx, discardLog, in, &fakeLightsailImageOperator{}, imgo); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPushAttemptTimeout(t *testing.T) {
+	defer func() {
+		testNow, testRngReader, testSleep = nil, nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611800397, 0) }
+	testRngReader = strings.NewReader("abcdefgh")
+	var slept []time.Duration
+	testSleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+
+	ctx := context.Background()
+	in := &PushImageInput{
+		Service:            "doge",
+		Image:              "nginx:latest",
+		Label:              "www",
+		PushAttempts:       2,
+		PushAttemptTimeout: 10 * time.Millisecond,
+	}
+	imgo := &fakeImageOperator{pushHangs: 1}
+	if _, err := PushImage(ctx, discardLog, in, &fakeLightsailImageOperator{}, imgo); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`inspect "nginx:latest"`,
+		`tag "nginx:latest" as "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg"`,
+		`push "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg": context deadline exceeded`,
+		`push "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg"`,
+		`untag "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg"`,
+	}
+	if !reflect.DeepEqual(imgo.log, want) {
+		t.Errorf("got: %q", imgo.log)
+		t.Logf("want: %q", want)
+	}
+	if want := []time.Duration{time.Second}; !reflect.DeepEqual(slept, want) {
+		t.Errorf("slept %v, want %v", slept, want)
+	}
+
+	// Running out of attempts reports the timeout.
+	testRngReader = strings.NewReader("abcdefgh")
+	imgo = &fakeImageOperator{pushHangs: 2}
+	_, err := PushImage(ctx, discardLog, in, &fakeLightsailImageOperator{}, imgo)
+	if want := "push attempt timed out after 10ms: context deadline exceeded"; err == nil || err.Error() != want {
+		t.Errorf("got err: %v", err)
+		t.Logf("want: %v", want)
+	}
+}
+
+func TestPushTimeout(t *testing.T) {
+	defer func() {
+		testNow, testRngReader, testSleep = nil, nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611800397, 0) }
+	testRngReader = strings.NewReader("abcdefgh")
+	testSleep = func(context.Context, time.Duration) error { return nil }
+
+	ctx := context.Background()
+	in := &PushImageInput{
+		Service:      "doge",
+		Image:        "nginx:latest",
+		Label:        "www",
+		PushAttempts: 3,
+		PushTimeout:  10 * time.Millisecond,
+	}
+	imgo := &fakeImageOperator{pushHangs: 3}
+	lio := &fakeLightsailImageOperator{}
+	_, err := PushImage(ctx, discardLog, in, lio, imgo)
+	if want := "push timed out after 10ms: context deadline exceeded"; err == nil || !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("got err: %v, want it to end with %q", err, want)
+	}
+	// The timeout ends the attempts, and nothing is registered.
+	pushes := 0
+	for _, op := range imgo.log {
+		if strings.HasPrefix(op, "push ") {
+			pushes++
+		}
+	}
+	if want := []string{"create login"}; pushes != 1 || !reflect.DeepEqual(lio.log, want) {
+		t.Errorf("got %d pushes and lightsail calls %q", pushes, lio.log)
+	}
+}
+
+func TestPushImagePlatformNotRetried(t *testing.T) {
+	defer func() {
+		testNow, testRngReader, testSleep = nil, nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611800397, 0) }
+	testRngReader = strings.NewReader("abcdefgh")
+	testSleep = func(context.Context, time.Duration) error { return nil }
+
+	in := &PushImageInput{
+		Service:      "doge",
+		Image:        "nginx:latest",
+		Label:        "www",
+		Platform:     "linux/amd64",
+		PushAttempts: 3,
+	}
+	imgo := &fakeImageOperator{pushErr: &platformError{
+		platform:  "linux/amd64",
+		available: []string{"linux/arm64"},
+		err:       errors.New("local image is built for linux/arm64"),
+	}}
+	_, err := PushImage(context.Background(), discardLog, in, &fakeLightsailImageOperator{}, imgo)
+	var pe *platformError
+	if !errors.As(err, &pe) {
+		t.Fatalf("got err: %v", err)
+	}
+	pushes := 0
+	for _, op := range imgo.log {
+		if strings.HasPrefix(op, "push ") {
+			pushes++
+		}
+	}
+	if pushes != 1 {
+		t.Errorf("got %d pushes, want 1: %q", pushes, imgo.log)
+	}
+}
+
+func TestPushImageCanceled(t *testing.T) {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611800397, 0) }
+	testRngReader = strings.NewReader("abcdefgh")
+
+	// The fakes don't mind ctx being canceled from the start,
+	// except for the push, the same as if Ctrl-C was pressed during it.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	imgo := &fakeImageOperator{pushHangs: 1}
+	in := &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "www"}
+	_, err := PushImage(ctx, discardLog, in, &fakeLightsailImageOperator{}, imgo)
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrPushFailed) {
+		t.Errorf("got err: %v", err)
+	}
+
+	want := []string{
+		`inspect "nginx:latest"`,
+		`tag "nginx:latest" as "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg"`,
+		`push "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg": context canceled`,
+		`untag "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-c5h66p35cpjmg"`,
+	}
+	if !reflect.DeepEqual(imgo.log, want) {
+		t.Errorf("got: %q", imgo.log)
+		t.Logf("want: %q", want)
+	}
+}
+
+func TestPushImages(t *testing.T) {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611800397, 0) }
+
+	ctx := context.Background()
+	ins := []*PushImageInput{
+		{Service: "doge", Image: "web:latest", Label: "web"},
+		{Service: "doge", Image: "api:latest", Label: "api"},
+	}
+
+	testRngReader = strings.NewReader("abcdefghABCDEFGH")
+	lio := &fakeLightsailImageOperator{}
+	results, err := PushImages(ctx, discardLog, &PushImagesInput{Images: ins}, lio, &fakeImageOperator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const digest = "sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa"
+	wantResults := []*PushImageResult{
+		{Image: "web:latest", Digest: digest, Reference: ":doge.web.12345"},
+		{Image: "api:latest", Digest: digest, Reference: ":doge.api.12345"},
+	}
+	if !reflect.DeepEqual(results, wantResults) {
+		t.Errorf("got results: %+v", results)
+	}
+	want := []string{
+		"create login",
+		"register (doge, web, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa)",
+		"register (doge, api, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa)",
+	}
+	if !reflect.DeepEqual(lio.log, want) {
+		t.Errorf("got: %q", lio.log)
+		t.Logf("want: %q", want)
+	}
+
+	testRngReader = strings.NewReader("abcdefghABCDEFGH")
+	boom := errors.New("boom")
+	lio = &fakeLightsailImageOperator{registerErrs: []error{boom}}
+	_, err = PushImages(ctx, discardLog, &PushImagesInput{Images: ins}, lio, &fakeImageOperator{})
+	var batchErr *BatchPushError
+	if !errors.As(err, &batchErr) || !errors.Is(err, boom) {
+		t.Fatalf("got err: %v", err)
+	}
+	if batchErr.Failed != ins[0] || len(batchErr.Pushed) != 0 {
+		t.Errorf("unexpected batch error: %#v", batchErr)
+	}
+
+	secondRef := "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-" +
+		b32.EncodeToString([]byte("ABCDEFGH"))
+	testRngReader = strings.NewReader("abcdefghABCDEFGH")
+	_, err = PushImages(ctx, discardLog, &PushImagesInput{Images: ins}, &fakeLightsailImageOperator{}, &fakeImageOperator{
+		images:        map[string]dockertypes.ImageInspect{"web:latest": {}, "api:latest": {}},
+		failToPushRef: secondRef,
+	})
+	wantErr := `image "api:latest" with label "api": failed: push "` + secondRef + `" ` +
+		`(already pushed: "web:latest" with label "web")`
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("got err: %v", err)
+		t.Logf("want: %v", wantErr)
+	}
+
+	lio = &fakeLightsailImageOperator{}
+	mixed := []*PushImageInput{
+		{Service: "doge", Image: "web:latest", Label: "web"},
+		{Service: "doge", Image: "api:latest", Label: "api", RegistryRepo: "test/repo"},
+	}
+	_, err = PushImages(ctx, discardLog, &PushImagesInput{Images: mixed}, lio, &fakeImageOperator{})
+	if !errors.As(err, &batchErr) || batchErr.Failed != mixed[1] ||
+		!strings.Contains(err.Error(), `registry repo "test/repo" differs from "sr"`) {
+		t.Errorf("got err: %v", err)
+	}
+	if len(lio.log) != 0 {
+		t.Errorf("unexpected calls: %q", lio.log)
+	}
+}
+
+func TestPushImagesAtomic(t *testing.T) {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611800397, 0) }
+
+	ctx := context.Background()
+	in := &PushImagesInput{
+		Images: []*PushImageInput{
+			{Service: "doge", Image: "web:latest", Label: "web"},
+			{Service: "doge", Image: "api:latest", Label: "api"},
+			{Service: "doge", Image: "worker:latest", Label: "worker"},
+		},
+		Atomic: true,
+	}
+	thirdRef := "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-" +
+		b32.EncodeToString([]byte("12345678"))
+
+	testRngReader = strings.NewReader("abcdefghABCDEFGH12345678")
+	lio := &fakeLightsailImageOperator{}
+	_, err := PushImages(ctx, discardLog, in, lio, &fakeImageOperator{failToPushRef: thirdRef})
+	var batchErr *BatchPushError
+	if !errors.As(err, &batchErr) || !batchErr.RolledBack || batchErr.RollbackErr != nil {
+		t.Fatalf("got err: %v", err)
+	}
+	wantErr := `image "worker:latest" with label "worker": failed: push "` + thirdRef + `" ` +
+		`(rolled back: deleted ":doge.api.12345", ":doge.web.12345")`
+	if err.Error() != wantErr {
+		t.Errorf("got err: %v", err)
+		t.Logf("want: %v", wantErr)
+	}
+	want := []string{
+		"create login",
+		"register (doge, web, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa)",
+		"register (doge, api, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa)",
+		"delete (doge, :doge.api.12345)",
+		"delete (doge, :doge.web.12345)",
+	}
+	if !reflect.DeepEqual(lio.log, want) {
+		t.Errorf("got: %q", lio.log)
+		t.Logf("want: %q", want)
+	}
+
+	// Failed cleanup is reported, but the original error is still there.
+	testRngReader = strings.NewReader("abcdefghABCDEFGH12345678")
+	lio = &fakeLightsailImageOperator{failToDelete: true}
+	_, err = PushImages(ctx, discardLog, in, lio, &fakeImageOperator{failToPushRef: thirdRef})
+	if !errors.As(err, &batchErr) || batchErr.RollbackErr == nil {
+		t.Fatalf("got err: %v", err)
+	}
+	if !strings.Contains(err.Error(), `failed: push "`+thirdRef+`"`) ||
+		!strings.Contains(err.Error(), "rollback failed: could not delete image \":doge.api.12345\"") {
+		t.Errorf("got err: %v", err)
+	}
+
+	// Labels registered before an extra label fails are rolled back too.
+	testRngReader = strings.NewReader("abcdefghABCDEFGH12345678")
+	lio = &fakeLightsailImageOperator{failToRegisterLabel: "stable"}
+	extra := *in
+	extra.Images = []*PushImageInput{
+		in.Images[0],
+		{Service: "doge", Image: "api:latest", Label: "api", ExtraLabels: []string{"stable"}},
+	}
+	_, err = PushImages(ctx, discardLog, &extra, lio, &fakeImageOperator{})
+	if !errors.As(err, &batchErr) || !batchErr.RolledBack || batchErr.RollbackErr != nil {
+		t.Fatalf("got err: %v", err)
+	}
+	if want := []string{":doge.api.12345", ":doge.web.12345"}; !reflect.DeepEqual(batchErr.Deleted, want) {
+		t.Errorf("got deleted: %q", batchErr.Deleted)
+	}
+	want = []string{
+		"create login",
+		"register (doge, web, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa)",
+		"register (doge, api, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa)",
+		"delete (doge, :doge.api.12345)",
+		"delete (doge, :doge.web.12345)",
+	}
+	if !reflect.DeepEqual(lio.log, want) {
+		t.Errorf("got: %q", lio.log)
+		t.Logf("want: %q", want)
+	}
+
+	// Images that ifNotPresent found registered are not deleted.
+	testRngReader = strings.NewReader("abcdefghABCDEFGH12345678")
+	const digest = "sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa"
+	lio = &fakeLightsailImageOperator{fakeImageLister: fakeImageLister{"doge": {
+		{Image: aws.String(":doge.api.3"), Digest: aws.String(digest)},
+	}}}
+	present := *in
+	present.Images = []*PushImageInput{
+		in.Images[0],
+		{Service: "doge", Image: "api:latest", Label: "api", IfNotPresent: true},
+		in.Images[2],
+	}
+	images := map[string]dockertypes.ImageInspect{
+		"web:latest":    {},
+		"api:latest":    {RepoDigests: []string{"123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr@" + digest}},
+		"worker:latest": {},
+	}
+	// The image found registered takes no tag.
+	secondRef := "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-" +
+		b32.EncodeToString([]byte("ABCDEFGH"))
+	_, err = PushImages(ctx, discardLog, &present, lio, &fakeImageOperator{images: images, failToPushRef: secondRef})
+	wantErr = `image "worker:latest" with label "worker": failed: push "` + secondRef + `" ` +
+		`(rolled back: deleted ":doge.web.12345")`
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("got err: %v", err)
+		t.Logf("want: %v", wantErr)
+	}
+
+	// Nothing to roll back when the first image fails.
+	testRngReader = strings.NewReader("abcdefghABCDEFGH12345678")
+	boom := errors.New("boom")
+	lio = &fakeLightsailImageOperator{registerErrs: []error{boom}}
+	_, err = PushImages(ctx, discardLog, in, lio, &fakeImageOperator{})
+	if !errors.As(err, &batchErr) || !errors.Is(err, boom) || batchErr.RolledBack {
+		t.Fatalf("got err: %v", err)
+	}
+}
+
+func TestPushImagesRollbackOutput(t *testing.T) {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611800397, 0) }
+	testRngReader = strings.NewReader("abcdefghABCDEFGH")
+
+	out := new(bytes.Buffer)
+	in := &PushImagesInput{
+		Images: []*PushImageInput{
+			{Service: "doge", Image: "web:latest", Label: "web", Output: out},
+			{Service: "doge", Image: "api:latest", Label: "api", Output: out},
+		},
+		Atomic: true,
+	}
+	lio := &fakeLightsailImageOperator{failToRegisterLabel: "api"}
+	if _, err := PushImages(context.Background(), discardLog, in, lio, &fakeImageOperator{}); err == nil {
+		t.Fatal("got no error")
+	}
+	if want := "Image \":doge.web.12345\" deleted from service \"doge\".\n"; !strings.HasSuffix(out.String(), want) {
+		t.Errorf("got output: %q, want it to end with %q", out, want)
+	}
+}
+
+func TestPushImagesContinueOnError(t *testing.T) {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611800397, 0) }
+
+	ctx := context.Background()
+	in := &PushImagesInput{
+		Images: []*PushImageInput{
+			{Service: "doge", Image: "web:latest", Label: "web"},
+			{Service: "doge", Image: "api:latest", Label: "api"},
+			{Service: "doge", Image: "worker:latest", Label: "worker"},
+			{Service: "doge", Image: "cron:latest", Label: "cron", IfNotPresent: true},
+		},
+		ContinueOnError: true,
+	}
+	const digest = "sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa"
+	secondRef := "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr:1611800397000000000-" +
+		b32.EncodeToString([]byte("ABCDEFGH"))
+
+	testRngReader = strings.NewReader("abcdefghABCDEFGH12345678")
+	lio := &fakeLightsailImageOperator{fakeImageLister: fakeImageLister{"doge": {
+		{Image: aws.String(":doge.cron.3"), Digest: aws.String(digest)},
+	}}}
+	results, err := PushImages(ctx, discardLog, in, lio, &fakeImageOperator{
+		images: map[string]dockertypes.ImageInspect{
+			"web:latest": {}, "api:latest": {},
+			"cron:latest": {RepoDigests: []string{"123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr@" + digest}},
+		},
+		failToPushRef: secondRef,
+	})
+	var imagesErr *PushImagesError
+	if !errors.As(err, &imagesErr) {
+		t.Fatalf("got err: %v", err)
+	}
+	var notFound *LocalImageNotFoundError
+	if !errors.As(err, &notFound) || notFound.Image != "worker:latest" {
+		t.Errorf("got err: %v, want it to wrap LocalImageNotFoundError", err)
+	}
+	wantErr := "2 of 4 images failed:\n" +
+		`image "api:latest" with label "api": failed: push "` + secondRef + `"` + "\n" +
+		`image "worker:latest" with label "worker": ` + notFound.Error() + "\n" +
+		`(pushed: "web:latest" with label "web"; already registered: "cron:latest" with label "cron")`
+	if err.Error() != wantErr {
+		t.Errorf("got err: %v", err)
+		t.Logf("want: %v", wantErr)
+	}
+
+	wantResults := []*PushImageResult{
+		{Image: "web:latest", Digest: digest, Reference: ":doge.web.12345"},
+		{
+			Image:              "cron:latest",
+			Digest:             digest,
+			Reference:          ":doge.cron.3",
+			ExistingReferences: []string{":doge.cron.3"},
+			AlreadyRegistered:  true,
+		},
+	}
+	if !reflect.DeepEqual(results, wantResults) {
+		t.Errorf("got results: %+v", results)
+	}
+	want := []string{
+		"create login",
+		"register (doge, web, sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa)",
+	}
+	if !reflect.DeepEqual(lio.log, want) {
+		t.Errorf("got: %q", lio.log)
+		t.Logf("want: %q", want)
+	}
+
+	// Atomic pushes still stop at the first failure.
+	in.Atomic = true
+	testRngReader = strings.NewReader("abcdefghABCDEFGH12345678")
+	_, err = PushImages(ctx, discardLog, in, &fakeLightsailImageOperator{}, &fakeImageOperator{failToPushRef: secondRef})
+	var batchErr *BatchPushError
+	if !errors.As(err, &batchErr) || batchErr.Failed != in.Images[1] {
+		t.Errorf("got err: %v", err)
+	}
+}
+
+func TestPushImagesReusesRegistryLogin(t *testing.T) {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	clock := time.Unix(1611800397, 0)
+	testNow = func() time.Time { return clock }
+
+	ctx := context.Background()
+	ins := []*PushImageInput{
+		{Service: "doge", Image: "web:latest", Label: "web"},
+		{Service: "doge", Image: "api:latest", Label: "api"},
+		{Service: "doge", Image: "db:latest", Label: "db"},
+	}
+
+	for _, test := range []struct {
+		expiresIn  time.Duration
+		wantLogins int
+	}{
+		{expiresIn: 0, wantLogins: 1},
+		{expiresIn: time.Hour, wantLogins: 1},
+		{expiresIn: loginRefreshMargin + 15*time.Minute, wantLogins: 2},
+		{expiresIn: loginRefreshMargin, wantLogins: 3},
+	} {
+		testRngReader = strings.NewReader(strings.Repeat("abcdefgh", len(ins)))
+		lio := &fakeLightsailImageOperator{fakeRegistryLoginCreator: fakeRegistryLoginCreator{expiresIn: test.expiresIn}}
+		imgo := &fakeImageOperator{}
+		// Pretend that each push takes 10 minutes.
+		imgo.onPush = func() { clock = clock.Add(10 * time.Minute) }
+		if _, err := PushImages(ctx, discardLog, &PushImagesInput{Images: ins}, lio, imgo); err != nil {
+			t.Fatal(err)
+		}
+		gotLogins := 0
+		for _, op := range lio.log {
+			if op == "create login" {
+				gotLogins++
+			}
+		}
+		if gotLogins != test.wantLogins {
+			t.Errorf("expiring in %v: got %d logins, want %d", test.expiresIn, gotLogins, test.wantLogins)
+		}
+	}
+}
+
+func TestWarnIncompatible(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := internal.NewLogger(log.New(buf, "", 0), internal.LevelInfo)
+
+	imgo := &fakeImageOperator{images: map[string]dockertypes.ImageInspect{
+		"normal:1": {Os: "linux", RootFS: dockertypes.RootFS{Type: "layers"}},
+		"weird:1":  {Os: "linux", RootFS: dockertypes.RootFS{Type: "squashfs"}},
+	}}
+	ctx := context.Background()
+
+	in := &PushImageInput{Image: "normal:1", WarnIncompatible: true}
+	if err := checkImage(ctx, logger, imgo, in); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected warning: %q", buf)
+	}
+
+	in = &PushImageInput{Image: "weird:1", WarnIncompatible: true}
+	if err := checkImage(ctx, logger, imgo, in); err != nil {
+		t.Fatal(err)
+	}
+	want := `WARNING: image "weird:1" has root file system of unsupported type "squashfs", it may not run on Lightsail`
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("got log: %q", buf)
+		t.Logf("want: %q", want)
+	}
+
+	// Warnings are opt-in.
+	buf.Reset()
+	if err := checkImage(ctx, logger, imgo, &PushImageInput{Image: "weird:1"}); err != nil || buf.Len() != 0 {
+		t.Errorf("got err: %v, log: %q", err, buf)
+	}
+}
+
+func TestPushImageIfNotPresent(t *testing.T) {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611800397, 0) }
+
+	const pushedDigest = "sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa"
+	const otherDigest = "sha256:b95cf9b496720e43b12ce435775d5e337a6648147825c0fc8fc0ff93616c69a0"
+
+	registered := fakeImageLister{"doge": {
+		{Image: aws.String(":doge.www.3"), Digest: aws.String(pushedDigest)},
+		{Image: aws.String(":doge.latest.2"), Digest: aws.String(pushedDigest)},
+		{Image: aws.String(":doge.api.1"), Digest: aws.String(otherDigest)},
+	}}
+	images := map[string]dockertypes.ImageInspect{
+		"fresh:1": {},
+		"same:1":  {RepoDigests: []string{"123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr@" + pushedDigest}},
+		"other:1": {RepoDigests: []string{"123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr@" + otherDigest}},
+	}
+
+	ctx := context.Background()
+	for i, test := range []struct {
+		image          string
+		label          string
+		extraLabels    []string
+		failLabel      string
+		wantPushed     bool
+		wantRegistered []string
+		wantRes        *PushImageResult
+		wantErr        bool
+	}{
+		{
+			image:          "fresh:1",
+			label:          "www",
+			wantPushed:     true,
+			wantRegistered: []string{"www"},
+			wantRes:        &PushImageResult{Reference: ":doge.www.12345"},
+		},
+		{
+			image: "same:1",
+			label: "www",
+			wantRes: &PushImageResult{
+				Reference:          ":doge.www.3",
+				ExistingReferences: []string{":doge.www.3"},
+				AlreadyRegistered:  true,
+			},
+		},
+		{
+			image:       "same:1",
+			label:       "www",
+			extraLabels: []string{"latest"},
+			wantRes: &PushImageResult{
+				Reference:          ":doge.www.3",
+				ExtraReferences:    []string{":doge.latest.2"},
+				ExistingReferences: []string{":doge.www.3", ":doge.latest.2"},
+				AlreadyRegistered:  true,
+			},
+		},
+		// Registered under the first label only: the rest are registered
+		// without pushing the image again.
+		{
+			image:          "same:1",
+			label:          "www",
+			extraLabels:    []string{"stable", "latest"},
+			wantRegistered: []string{"stable"},
+			wantRes: &PushImageResult{
+				Reference:          ":doge.www.3",
+				ExtraReferences:    []string{":doge.stable.12345", ":doge.latest.2"},
+				ExistingReferences: []string{":doge.www.3", ":doge.latest.2"},
+			},
+		},
+		{
+			image:          "same:1",
+			label:          "stable",
+			extraLabels:    []string{"www"},
+			wantRegistered: []string{"stable"},
+			wantRes: &PushImageResult{
+				Reference:          ":doge.stable.12345",
+				ExtraReferences:    []string{":doge.www.3"},
+				ExistingReferences: []string{":doge.www.3"},
+			},
+		},
+		// The references registered before a label fails are in the result.
+		{
+			image:          "same:1",
+			label:          "stable",
+			extraLabels:    []string{"www", "beta"},
+			failLabel:      "beta",
+			wantRegistered: []string{"stable"},
+			wantRes: &PushImageResult{
+				Reference:          ":doge.stable.12345",
+				ExtraReferences:    []string{":doge.www.3"},
+				ExistingReferences: []string{":doge.www.3"},
+			},
+			wantErr: true,
+		},
+		// Same digest is registered, but under a different label.
+		{
+			image:          "other:1",
+			label:          "www",
+			wantPushed:     true,
+			wantRegistered: []string{"www"},
+			wantRes:        &PushImageResult{Reference: ":doge.www.12345"},
+		},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			testRngReader = strings.NewReader("abcdefgh")
+			lio := &fakeLightsailImageOperator{fakeImageLister: registered, failToRegisterLabel: test.failLabel}
+			imgo := &fakeImageOperator{images: images}
+			in := &PushImageInput{
+				Service:      "doge",
+				Image:        test.image,
+				Label:        test.label,
+				ExtraLabels:  test.extraLabels,
+				IfNotPresent: true,
+				Output:       io.Discard,
+			}
+			res, err := PushImage(ctx, discardLog, in, lio, imgo)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got err: %v", err)
+			}
+			if res == nil {
+				t.Fatal("got no result")
+			}
+			test.wantRes.Image = test.image
+			test.wantRes.Digest = pushedDigest
+			if !reflect.DeepEqual(res, test.wantRes) {
+				t.Errorf("got result: %+v", res)
+				t.Logf("want: %+v", test.wantRes)
+			}
+
+			pushed := false
+			for _, op := range imgo.log {
+				pushed = pushed || strings.HasPrefix(op, "push ")
+			}
+			if pushed != test.wantPushed {
+				t.Errorf("pushed: %v, want: %v, log: %q", pushed, test.wantPushed, imgo.log)
+			}
+			var labels []string
+			for _, op := range lio.log {
+				if rest, ok := strings.CutPrefix(op, "register (doge, "); ok {
+					label, _, _ := strings.Cut(rest, ",")
+					labels = append(labels, label)
+				}
+			}
+			if !reflect.DeepEqual(labels, test.wantRegistered) {
+				t.Errorf("registered labels: %q, want: %q", labels, test.wantRegistered)
+			}
+		})
+	}
+}
+
+func TestTryUntagImageLogsOnlyToDebugLog(t *testing.T) {
+	defer func(w io.Writer) { log.SetOutput(w) }(log.Writer())
+	stdLog := new(bytes.Buffer)
+	log.SetOutput(stdLog)
+
+	debugBuf := new(bytes.Buffer)
+	logger := internal.NewLogger(log.New(debugBuf, "", 0), internal.LevelDebug)
+
+	tryUntagImage(context.Background(), discardLog, &fakeImageOperator{failToUntag: true}, "x:y")
+	if stdLog.Len() != 0 {
+		t.Errorf("untag error unexpectedly logged at default verbosity: %q", stdLog)
+	}
+
+	tryUntagImage(context.Background(), logger, &fakeImageOperator{failToUntag: true}, "x:y")
+	if want := "could not remove temporary tag: failed: untag \"x:y\"\n"; debugBuf.String() != want {
+		t.Errorf("got debug log: %q", debugBuf)
+		t.Logf("want: %q", want)
+	}
+	if stdLog.Len() != 0 {
+		t.Errorf("untag error unexpectedly logged to standard logger: %q", stdLog)
+	}
+}
+
+func TestPushImageUntagFailure(t *testing.T) {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611800397, 0) }
+
+	const note = "could not remove temporary tag"
+	for _, level := range []internal.Level{internal.LevelInfo, internal.LevelDebug} {
+		testRngReader = strings.NewReader("abcdefgh")
+		stdLog := new(bytes.Buffer)
+		logger := internal.NewLogger(log.New(stdLog, "", 0), level)
+		in := &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "www", Output: io.Discard}
+		imgo := &fakeImageOperator{failToUntag: true}
+		if _, err := PushImage(context.Background(), logger, in, &fakeLightsailImageOperator{}, imgo); err != nil {
+			t.Fatalf("%v: %v", level, err)
+		}
+		if logged := strings.Contains(stdLog.String(), note); logged != (level == internal.LevelDebug) {
+			t.Errorf("%v: got log %q", level, stdLog)
+		}
+	}
+}
+
+func TestRegisterGracePeriod(t *testing.T) {
+	defer func() { testSleep, testNow = nil, nil }()
+	clock := time.Unix(1611800397, 0)
+	testNow = func() time.Time { return clock }
+	var slept []time.Duration
+	testSleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+
+	notReady := &types.InvalidInputException{Message: aws.String("Container service doge is not ready.")}
+	pending := &types.NotFoundException{Message: aws.String("Container service doge is in PENDING state.")}
+	notFound := &types.NotFoundException{Message: aws.String("Container service doge was not found.")}
+	denied := &types.AccessDeniedException{Message: aws.String("Nope.")}
+	withRetryAfter := func(err error, retryAfter string) error {
+		return &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{
+				StatusCode: 400,
+				Header:     http.Header{"Retry-After": {retryAfter}},
+			}},
+			Err: err,
+		}}
+	}
+
+	ctx := context.Background()
+	for i, test := range []struct {
+		grace     time.Duration
+		errs      []error
+		wantErr   error
+		wantSlept []time.Duration
+	}{
+		{grace: 10 * time.Second, errs: []error{pending, notReady}, wantSlept: []time.Duration{time.Second, 2 * time.Second}},
+		{grace: 10 * time.Second, errs: []error{notFound}, wantErr: notFound},
+		{grace: 2 * time.Second, errs: []error{notReady, notReady}, wantErr: notReady, wantSlept: []time.Duration{time.Second}},
+		{grace: 0, errs: []error{notReady}, wantErr: notReady},
+		{grace: 10 * time.Second, errs: []error{denied}, wantErr: denied},
+		{
+			grace:     10 * time.Second,
+			errs:      []error{withRetryAfter(notReady, "3"), pending},
+			wantSlept: []time.Duration{3 * time.Second, 2 * time.Second},
+		},
+		{
+			grace:     10 * time.Second,
+			errs:      []error{withRetryAfter(pending, clock.Add(4*time.Second).UTC().Format(http.TimeFormat))},
+			wantSlept: []time.Duration{4 * time.Second},
+		},
+		{
+			grace:     10 * time.Second,
+			errs:      []error{withRetryAfter(notReady, "soon")},
+			wantSlept: []time.Duration{time.Second},
+		},
+		{grace: 2 * time.Second, errs: []error{withRetryAfter(notReady, "5")}, wantErr: notReady},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			slept = nil
+			lio := &fakeLightsailImageOperator{registerErrs: test.errs}
+			in := &PushImageInput{Service: "doge", Label: "www", RegisterGracePeriod: test.grace}
+			_, err := registerImage(ctx, discardLog, in, lio, "sha256:abc")
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got err: %v, want: %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(slept, test.wantSlept) {
+				t.Errorf("slept %v, want %v", slept, test.wantSlept)
+			}
+		})
+	}
+}
+
+func TestRegisterThrottled(t *testing.T) {
+	defer func() { testSleep = nil }()
+	var slept []time.Duration
+	testSleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+	tooMany := &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusTooManyRequests}},
+		Err:      errors.New("too many requests"),
+	}}
+	tooManyLater := &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Header:     http.Header{"Retry-After": {"3"}},
+		}},
+		Err: errors.New("too many requests"),
+	}}
+	denied := &types.AccessDeniedException{Message: aws.String("Nope.")}
+
+	ctx := context.Background()
+	for i, test := range []struct {
+		errs      []error
+		wantErr   error
+		wantSlept []time.Duration
+	}{
+		{errs: []error{throttled}, wantSlept: []time.Duration{time.Second}},
+		{errs: []error{tooMany, throttled}, wantSlept: []time.Duration{time.Second, 2 * time.Second}},
+		{errs: []error{tooManyLater, throttled}, wantSlept: []time.Duration{3 * time.Second, 2 * time.Second}},
+		{
+			errs:      []error{throttled, throttled, throttled, throttled, throttled},
+			wantErr:   throttled,
+			wantSlept: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		{errs: []error{denied}, wantErr: denied},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			slept = nil
+			lio := &fakeLightsailImageOperator{registerErrs: test.errs}
+			in := &PushImageInput{Service: "doge", Label: "www"}
+			out, err := registerImage(ctx, discardLog, in, lio, "sha256:abc")
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got err: %v, want: %v", err, test.wantErr)
+			}
+			if err == nil && out.ContainerImage == nil {
+				t.Error("got no container image")
+			}
+			if !reflect.DeepEqual(slept, test.wantSlept) {
+				t.Errorf("slept %v, want %v", slept, test.wantSlept)
+			}
+		})
+	}
+}
+
+var discardLog *internal.Logger
+
+type fakeRegistryLoginCreator struct {
+	failToCreateLogin bool
+	// loginErr, when set, is the error of creating a login.
+	loginErr error
+	// expiresIn, when set, makes logins expire this long after creation.
+	expiresIn time.Duration
+	log       []string
+}
+
+func (f *fakeRegistryLoginCreator) CreateContainerServiceRegistryLogin(
+	context.Context,
+	*lightsail.CreateContainerServiceRegistryLoginInput,
+	...func(*lightsail.Options),
+) (*lightsail.CreateContainerServiceRegistryLoginOutput, error) {
+	op := "create login"
+	if f.failToCreateLogin {
+		return nil, fmt.Errorf("failed: %s", op)
+	}
+	if f.loginErr != nil {
+		return nil, f.loginErr
+	}
+	f.log = append(f.log, op)
+	login := &types.ContainerServiceRegistryLogin{
+		Username: aws.String("gollum"),
+		Password: aws.String("precious"),
+		Registry: aws.String("123456789012.dkr.ecr.so-fake-2.amazonaws.com"),
+	}
+	if f.expiresIn > 0 {
+		login.ExpiresAt = aws.Time(now().Add(f.expiresIn))
+	}
+	return &lightsail.CreateContainerServiceRegistryLoginOutput{RegistryLogin: login}, nil
+}
+
+type fakeLightsailImageOperator struct {
+	fakeRegistryLoginCreator
+	failToRegister bool
+	// failToRegisterLabel makes registering under this label fail.
+	failToRegisterLabel string
+	// registerErrs are returned by RegisterContainerImage calls,
+	// one error per call, before it starts succeeding.
+	registerErrs []error
+	// registeredDigest, when set, replaces the digest in registration output.
+	registeredDigest string
+	failToDelete     bool
+	fakeImageLister
+}
+
+func (f *fakeLightsailImageOperator) DeleteContainerImage(
+	_ context.Context,
+	in *lightsail.DeleteContainerImageInput,
+	_ ...func(*lightsail.Options),
+) (*lightsail.DeleteContainerImageOutput, error) {
+	op := fmt.Sprintf("delete (%s, %s)", aws.ToString(in.ServiceName), aws.ToString(in.Image))
+	if f.failToDelete {
+		return nil, fmt.Errorf("failed: %s", op)
+	}
+	f.log = append(f.log, op)
+	return &lightsail.DeleteContainerImageOutput{}, nil
+}
+
+func (f *fakeLightsailImageOperator) RegisterContainerImage(
+	_ context.Context,
+	in *lightsail.RegisterContainerImageInput,
+	_ ...func(*lightsail.Options),
+) (*lightsail.RegisterContainerImageOutput, error) {
+	op := fmt.Sprintf("register (%s, %s, %s)",
+		aws.ToString(in.ServiceName),
+		aws.ToString(in.Label),
+		aws.ToString(in.Digest))
+	if f.failToRegister || f.failToRegisterLabel == aws.ToString(in.Label) {
+		return nil, fmt.Errorf("failed: %s", op)
+	}
+	if len(f.registerErrs) > 0 {
+		err := f.registerErrs[0]
+		f.registerErrs = f.registerErrs[1:]
+		f.log = append(f.log, fmt.Sprintf("%s: %v", op, err))
+		return nil, err
+	}
+	f.log = append(f.log, op)
+	digest := in.Digest
+	if f.registeredDigest != "" {
+		digest = &f.registeredDigest
+	}
+	return &lightsail.RegisterContainerImageOutput{
+		ContainerImage: &types.ContainerImage{
+			Digest: digest,
+			Image:  aws.String(":" + aws.ToString(in.ServiceName) + "." + aws.ToString(in.Label) + ".12345"),
+		},
+	}, nil
+}
+
+type fakeImageOperator struct {
+	failToTag, failToUntag, failToPush bool
+	// failToPushRef makes pushing fail only for this reference.
+	failToPushRef string
+	// pushErr, if any, is what every PushImage call fails with.
+	pushErr error
+	// onPush is called by every successful PushImage call.
+	onPush func()
+	// pushHangs is the number of PushImage calls
+	// that block until their context is done.
+	pushHangs int
+	// images are what InspectImage finds locally,
+	// when nil any image is found as a bare linux/amd64 one.
+	images map[string]dockertypes.ImageInspect
+	// loaded are the images that LoadImage finds in any archive.
+	loaded []string
+	log    []string
+}
+
+func (f *fakeImageOperator) InspectImage(_ context.Context, image string) (dockertypes.ImageInspect, error) {
+	op := fmt.Sprintf("inspect %q", image)
+	if f.images == nil {
+		f.log = append(f.log, op)
+		return dockertypes.ImageInspect{Os: "linux", Architecture: "amd64"}, nil
+	}
+	info, ok := f.images[image]
+	if !ok {
+		return dockertypes.ImageInspect{}, &LocalImageNotFoundError{Image: image}
+	}
+	f.log = append(f.log, op)
+	return info, nil
+}
+
+func (f *fakeImageOperator) TagImage(_ context.Context, source, target string) error {
+	op := fmt.Sprintf("tag %q as %q", source, target)
+	if f.failToTag {
+		return fmt.Errorf("failed: %s", op)
+	}
+	f.log = append(f.log, op)
+	return nil
+}
+
+func (f *fakeImageOperator) UntagImage(ctx context.Context, image string) error {
+	op := fmt.Sprintf("untag %q", image)
+	if f.failToUntag || ctx.Err() != nil {
+		return fmt.Errorf("failed: %s", op)
+	}
+	f.log = append(f.log, op)
+	return nil
+}
+
+func (f *fakeImageOperator) LoadImage(_ context.Context, archive io.Reader) ([]string, error) {
+	b, err := io.ReadAll(archive)
+	if err != nil {
+		return nil, err
+	}
+	f.log = append(f.log, fmt.Sprintf("load %d bytes", len(b)))
+	return f.loaded, nil
+}
+
+func (f *fakeImageOperator) PushImage(ctx context.Context, remoteImage RemoteImage) (PushSummary, error) {
+	op := fmt.Sprintf("push %q", remoteImage.Ref())
+	if f.failToPush || f.failToPushRef == remoteImage.Ref() {
+		return PushSummary{}, fmt.Errorf("failed: %s", op)
+	}
+	if f.pushErr != nil {
+		f.log = append(f.log, fmt.Sprintf("%s: %v", op, f.pushErr))
+		return PushSummary{}, f.pushErr
+	}
+	if f.pushHangs > 0 {
+		f.pushHangs--
+		<-ctx.Done()
+		f.log = append(f.log, fmt.Sprintf("%s: %v", op, ctx.Err()))
+		return PushSummary{}, ctx.Err()
+	}
+	f.log = append(f.log, op)
+	if f.onPush != nil {
+		f.onPush()
+	}
+	return PushSummary{Digest: "sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa"}, nil
+}
+
+func TestPushImageEvents(t *testing.T) {
+	defer func() { testRngReader = nil }()
+	testRngReader = strings.NewReader("abcdefgh")
+
+	out := new(bytes.Buffer)
+	in := &PushImageInput{
+		Service:     "doge",
+		Image:       "nginx:latest",
+		Label:       "www",
+		ExtraLabels: []string{"latest"},
+		Events:      internal.NewEvents(out),
+	}
+	if _, err := PushImage(context.Background(), discardLog, in, &fakeLightsailImageOperator{}, &fakeImageOperator{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	dec := json.NewDecoder(out)
+	for dec.More() {
+		var ev internal.Event
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ev.Type+" "+ev.Registry+ev.Image+" "+ev.Reference)
+	}
+	want := []string{
+		"loginCreated 123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr ",
+		"pushStarted nginx:latest ",
+		"registered nginx:latest :doge.www.12345",
+		"registered nginx:latest :doge.latest.12345",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
+
+func TestPushImageOutput(t *testing.T) {
+	defer func() {
+		testNow, testRngReader = nil, nil
+	}()
+	testNow = func() time.Time { return time.Unix(1611796436, 0) }
+	testRngReader = strings.NewReader("abcdefghabcdefgh")
+
+	ctx := context.Background()
+	out := new(bytes.Buffer)
+	in := &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "www", ExtraLabels: []string{"latest"}, Output: out}
+	if _, err := PushImage(ctx, discardLog, in, &fakeLightsailImageOperator{}, &fakeImageOperator{}); err != nil {
+		t.Fatal(err)
+	}
+	want := `Digest: sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa
+Image "nginx:latest" registered.
+Refer to this image as ":doge.www.12345" in deployments.
+Refer to this image as ":doge.latest.12345" in deployments.
+`
+	if out.String() != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", out, want)
+	}
+
+	out.Reset()
+	in = &PushImageInput{Service: "doge", Image: "nginx:latest", Label: "www", DryRun: true, Output: out}
+	if _, err := PushImage(ctx, discardLog, in, &fakeLightsailImageOperator{}, &fakeImageOperator{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Dry run: image \"nginx:latest\" would be pushed to "; !strings.HasPrefix(out.String(), want) {
+		t.Errorf("got output %q, want it to start with %q", out, want)
 	}
-	f.log = append(f.log, op)
-	return "sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa", nil
 }
@@ -0,0 +1,31 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// WriteRegistryHost writes the address of the service registry repo
+// (host and DefaultRegistryRepo path) that images are pushed to, either as
+// a line of text or as JSON. Registry credentials are never written.
+func WriteRegistryHost(ctx context.Context, w io.Writer, rlc RegistryLoginCreator, asJSON bool) error {
+	// There is no region to check the registry against, so nothing is logged.
+	login, err := getServiceRegistryAuth(ctx, nil, rlc, "", DefaultRegistryRepo)
+	if err != nil {
+		return err
+	}
+
+	if !asJSON {
+		_, err := fmt.Fprintln(w, login.ServerAddress)
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(struct {
+		Registry string `json:"registry"`
+	}{login.ServerAddress})
+}
@@ -0,0 +1,44 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWriteRegistryHost(t *testing.T) {
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		asJSON bool
+		want   string
+	}{
+		{want: "123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr\n"},
+		{asJSON: true, want: `{"registry":"123456789012.dkr.ecr.so-fake-2.amazonaws.com/sr"}` + "\n"},
+	} {
+		buf := new(bytes.Buffer)
+		if err := WriteRegistryHost(ctx, buf, &fakeRegistryLoginCreator{}, test.asJSON); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+		for _, secret := range []string{"gollum", "precious"} {
+			if strings.Contains(buf.String(), secret) {
+				t.Errorf("output contains credentials: %q", buf)
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteRegistryHost(ctx, buf, &fakeRegistryLoginCreator{failToCreateLogin: true}, false); err == nil {
+		t.Error("unexpectedly succeeded")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected output: %q", buf)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// maxRetryAfter caps the delays that Retry-After headers ask for,
+// so that a bogus one can't stall an invocation for long.
+const maxRetryAfter = time.Minute
+
+// WithRetryAfter returns r, except that its retries wait as long as
+// the Retry-After header of the failed response asks, if there is one,
+// up to a minute, rather than as long as r would on its own.
+func WithRetryAfter(r aws.RetryerV2) aws.RetryerV2 {
+	return retryAfterRetryer{r}
+}
+
+type retryAfterRetryer struct {
+	aws.RetryerV2
+}
+
+func (r retryAfterRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	if d, ok := retryAfter(err); ok {
+		return min(d, maxRetryAfter), nil
+	}
+	return r.RetryerV2.RetryDelay(attempt, err)
+}
@@ -0,0 +1,89 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestWithRetryAfter(t *testing.T) {
+	throttled := func(retryAfter string) error {
+		return &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Header:     http.Header{"Retry-After": {retryAfter}},
+			}},
+			Err: &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"},
+		}}
+	}
+	r := WithRetryAfter(retry.NewStandard(func(o *retry.StandardOptions) {
+		o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return time.Second, nil })
+	}))
+
+	for i, test := range []struct {
+		err  error
+		want time.Duration
+	}{
+		{throttled("3"), 3 * time.Second},
+		{throttled("3600"), maxRetryAfter},
+		{throttled("soon"), time.Second},
+		{errors.New("boom"), time.Second},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			got, err := r.RetryDelay(1, test.err)
+			if err != nil || got != test.want {
+				t.Errorf("got delay %v, %v, want %v", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestWithRetryAfterClient(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"__type": "ThrottlingException", "message": "Rate exceeded"}`))
+			return
+		}
+		w.Write([]byte(`{"containerServices": []}`))
+	}))
+	defer srv.Close()
+
+	var delays []time.Duration
+	client := lightsail.New(lightsail.Options{
+		Region:       "us-west-2",
+		BaseEndpoint: aws.String(srv.URL),
+		Credentials:  aws.AnonymousCredentials{},
+		Retryer: WithRetryAfter(retry.NewStandard(func(o *retry.StandardOptions) {
+			o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) {
+				delays = append(delays, time.Hour)
+				return time.Hour, nil
+			})
+		})),
+	})
+	if _, err := client.GetContainerServices(context.Background(), &lightsail.GetContainerServicesInput{}); err != nil {
+		t.Fatal(err)
+	}
+	// The retry waited as the response asked, not as long as the backoff.
+	if calls.Load() != 2 || len(delays) != 0 {
+		t.Errorf("got %d calls and backoff delays %v", calls.Load(), delays)
+	}
+}
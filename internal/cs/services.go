@@ -0,0 +1,190 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail/types"
+	"github.com/aws/lightsailctl/internal"
+)
+
+type ListServicesInput struct {
+	// Service, when set, limits the listing to that service.
+	Service string
+	// Output receives the table, os.Stdout when it's nil.
+	Output io.Writer
+}
+
+type ServiceLister interface {
+	GetContainerServices(
+		context.Context,
+		*lightsail.GetContainerServicesInput,
+		...func(*lightsail.Options),
+	) (*lightsail.GetContainerServicesOutput, error)
+}
+
+// ListServices prints a table of Lightsail container services
+// and the state of their current deployments.
+func ListServices(ctx context.Context, in *ListServicesInput, l ServiceLister) error {
+	req := &lightsail.GetContainerServicesInput{}
+	if in.Service != "" {
+		req.ServiceName = &in.Service
+	}
+	out, err := l.GetContainerServices(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(outputOrStdout(in.Output), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tPOWER\tSCALE\tSTATE\tDEPLOYMENT")
+	for _, s := range out.ContainerServices {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n",
+			aws.ToString(s.ContainerServiceName), s.Power, aws.ToInt32(s.Scale), s.State, deploymentState(s.CurrentDeployment))
+	}
+	return tw.Flush()
+}
+
+// deploymentState describes the deployment as "<state> (version <n>)",
+// or "-" when there's no deployment.
+func deploymentState(d *types.ContainerServiceDeployment) string {
+	switch {
+	case d == nil:
+		return "-"
+	case d.Version == nil:
+		return string(d.State)
+	}
+	return fmt.Sprintf("%s (version %d)", d.State, *d.Version)
+}
+
+type ListDeploymentsInput struct {
+	Service string
+	// Output receives the table, os.Stdout when it's nil.
+	Output io.Writer
+}
+
+type DeploymentLister interface {
+	GetContainerServiceDeployments(
+		context.Context,
+		*lightsail.GetContainerServiceDeploymentsInput,
+		...func(*lightsail.Options),
+	) (*lightsail.GetContainerServiceDeploymentsOutput, error)
+}
+
+// ListDeployments prints a table of the deployments of Lightsail service
+// with the image of each of their containers, one container per row.
+func ListDeployments(ctx context.Context, in *ListDeploymentsInput, l DeploymentLister) error {
+	out, err := l.GetContainerServiceDeployments(ctx, &lightsail.GetContainerServiceDeploymentsInput{
+		ServiceName: &in.Service,
+	})
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(outputOrStdout(in.Output), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tSTATE\tCREATED\tCONTAINER\tIMAGE")
+	for _, d := range out.Deployments {
+		created := ""
+		if d.CreatedAt != nil {
+			created = d.CreatedAt.UTC().Format(time.RFC3339)
+		}
+		names := make([]string, 0, len(d.Containers))
+		for name := range d.Containers {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		if len(names) == 0 {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t-\t-\n", aws.ToInt32(d.Version), d.State, created)
+		}
+		for _, name := range names {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n",
+				aws.ToInt32(d.Version), d.State, created, name, aws.ToString(d.Containers[name].Image))
+		}
+	}
+	return tw.Flush()
+}
+
+type CreateServiceInput struct {
+	Service string
+	// Power is the size of each node, e.g. "nano" or "micro".
+	Power string
+	// Scale is the number of nodes.
+	Scale int32
+
+	// Output is told how the creation goes, os.Stdout when it's nil.
+	Output io.Writer
+}
+
+type ServiceCreator interface {
+	ServiceLister
+	CreateContainerService(
+		context.Context,
+		*lightsail.CreateContainerServiceInput,
+		...func(*lightsail.Options),
+	) (*lightsail.CreateContainerServiceOutput, error)
+}
+
+const (
+	// serviceReadyTimeout is how long CreateService waits for
+	// a new service to become ready, which takes a few minutes.
+	serviceReadyTimeout = 20 * time.Minute
+	// serviceStatePollInterval is how often CreateService checks
+	// the state of the new service.
+	serviceStatePollInterval = 10 * time.Second
+)
+
+// CreateService creates a Lightsail container service and waits until
+// it's ready, so that images can be pushed to it right away.
+// State transitions of the service are logged at debug level.
+func CreateService(ctx context.Context, logger *internal.Logger, in *CreateServiceInput, c ServiceCreator) error {
+	out, err := c.CreateContainerService(ctx, &lightsail.CreateContainerServiceInput{
+		ServiceName: &in.Service,
+		Power:       types.ContainerServicePowerName(in.Power),
+		Scale:       &in.Scale,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(outputOrStdout(in.Output), "Service %q is being created.\n", in.Service)
+
+	var state types.ContainerServiceState
+	if out.ContainerService != nil {
+		state = out.ContainerService.State
+		logger.Debugf("service %q is %s", in.Service, state)
+	}
+	deadline := now().Add(serviceReadyTimeout)
+	for state != types.ContainerServiceStateReady {
+		switch state {
+		case types.ContainerServiceStateDeleting, types.ContainerServiceStateDisabled:
+			return fmt.Errorf("service %q is %s instead of becoming ready", in.Service, state)
+		}
+		if !now().Before(deadline) {
+			return fmt.Errorf("service %q is still %s after %v", in.Service, state, serviceReadyTimeout)
+		}
+		if err := sleep(ctx, serviceStatePollInterval); err != nil {
+			return err
+		}
+
+		out, err := c.GetContainerServices(ctx, &lightsail.GetContainerServicesInput{ServiceName: &in.Service})
+		if err != nil {
+			return err
+		}
+		if len(out.ContainerServices) == 0 {
+			return fmt.Errorf("service %q is not found after it was created", in.Service)
+		}
+		if s := out.ContainerServices[0].State; s != state {
+			logger.Debugf("service %q is %s", in.Service, s)
+			state = s
+		}
+	}
+	fmt.Fprintf(outputOrStdout(in.Output), "Service %q is ready.\n", in.Service)
+	return nil
+}
@@ -0,0 +1,206 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail/types"
+	"github.com/aws/lightsailctl/internal"
+)
+
+type fakeServiceLister []types.ContainerService
+
+func (f fakeServiceLister) GetContainerServices(
+	_ context.Context,
+	in *lightsail.GetContainerServicesInput,
+	_ ...func(*lightsail.Options),
+) (*lightsail.GetContainerServicesOutput, error) {
+	if in.ServiceName == nil {
+		return &lightsail.GetContainerServicesOutput{ContainerServices: f}, nil
+	}
+	for _, s := range f {
+		if aws.ToString(s.ContainerServiceName) == *in.ServiceName {
+			return &lightsail.GetContainerServicesOutput{ContainerServices: []types.ContainerService{s}}, nil
+		}
+	}
+	return nil, fmt.Errorf("failed: service %q not found", *in.ServiceName)
+}
+
+func ExampleListServices() {
+	ctx := context.Background()
+	l := fakeServiceLister{
+		{
+			ContainerServiceName: aws.String("doge"),
+			Power:                types.ContainerServicePowerNameMicro,
+			Scale:                aws.Int32(2),
+			State:                types.ContainerServiceStateRunning,
+			CurrentDeployment: &types.ContainerServiceDeployment{
+				State:   types.ContainerServiceDeploymentStateActive,
+				Version: aws.Int32(12),
+			},
+		},
+		{
+			ContainerServiceName: aws.String("fresh"),
+			Power:                types.ContainerServicePowerNameNano,
+			Scale:                aws.Int32(1),
+			State:                types.ContainerServiceStateReady,
+		},
+	}
+
+	for _, service := range []string{"", "fresh", "missing"} {
+		if err := ListServices(ctx, &ListServicesInput{Service: service}, l); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	// Output:
+	// SERVICE  POWER  SCALE  STATE    DEPLOYMENT
+	// doge     micro  2      RUNNING  ACTIVE (version 12)
+	// fresh    nano   1      READY    -
+	// SERVICE  POWER  SCALE  STATE  DEPLOYMENT
+	// fresh    nano   1      READY  -
+	// failed: service "missing" not found
+}
+
+type fakeDeploymentLister map[string][]types.ContainerServiceDeployment
+
+func (f fakeDeploymentLister) GetContainerServiceDeployments(
+	_ context.Context,
+	in *lightsail.GetContainerServiceDeploymentsInput,
+	_ ...func(*lightsail.Options),
+) (*lightsail.GetContainerServiceDeploymentsOutput, error) {
+	deployments, ok := f[aws.ToString(in.ServiceName)]
+	if !ok {
+		return nil, fmt.Errorf("failed: service %q not found", aws.ToString(in.ServiceName))
+	}
+	return &lightsail.GetContainerServiceDeploymentsOutput{Deployments: deployments}, nil
+}
+
+func ExampleListDeployments() {
+	ctx := context.Background()
+	l := fakeDeploymentLister{
+		"doge": {
+			{
+				Version:   aws.Int32(12),
+				State:     types.ContainerServiceDeploymentStateActivating,
+				CreatedAt: aws.Time(time.Unix(1611800397, 0)),
+				Containers: map[string]types.Container{
+					"www":   {Image: aws.String(":doge.www.13")},
+					"cache": {Image: aws.String("redis:7")},
+				},
+			},
+			{
+				Version:    aws.Int32(11),
+				State:      types.ContainerServiceDeploymentStateActive,
+				CreatedAt:  aws.Time(time.Unix(1611796436, 0)),
+				Containers: map[string]types.Container{"www": {Image: aws.String(":doge.www.12")}},
+			},
+			{Version: aws.Int32(10), State: types.ContainerServiceDeploymentStateFailed},
+		},
+	}
+
+	for _, service := range []string{"doge", "missing"} {
+		if err := ListDeployments(ctx, &ListDeploymentsInput{Service: service}, l); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	// Output:
+	// VERSION  STATE       CREATED               CONTAINER  IMAGE
+	// 12       ACTIVATING  2021-01-28T02:19:57Z  cache      redis:7
+	// 12       ACTIVATING  2021-01-28T02:19:57Z  www        :doge.www.13
+	// 11       ACTIVE      2021-01-28T01:13:56Z  www        :doge.www.12
+	// 10       FAILED                            -          -
+	// failed: service "missing" not found
+}
+
+// fakeServiceCreator creates services that go through states,
+// one state per GetContainerServices call.
+type fakeServiceCreator struct {
+	states []types.ContainerServiceState
+	log    []string
+}
+
+func (f *fakeServiceCreator) CreateContainerService(
+	_ context.Context,
+	in *lightsail.CreateContainerServiceInput,
+	_ ...func(*lightsail.Options),
+) (*lightsail.CreateContainerServiceOutput, error) {
+	op := fmt.Sprintf("create (%s, %s, %d)", aws.ToString(in.ServiceName), in.Power, aws.ToInt32(in.Scale))
+	f.log = append(f.log, op)
+	if len(f.states) == 0 {
+		return nil, fmt.Errorf("failed: %s", op)
+	}
+	return &lightsail.CreateContainerServiceOutput{ContainerService: &types.ContainerService{
+		ContainerServiceName: in.ServiceName,
+		State:                f.states[0],
+	}}, nil
+}
+
+func (f *fakeServiceCreator) GetContainerServices(
+	_ context.Context,
+	in *lightsail.GetContainerServicesInput,
+	_ ...func(*lightsail.Options),
+) (*lightsail.GetContainerServicesOutput, error) {
+	f.log = append(f.log, "get "+aws.ToString(in.ServiceName))
+	if len(f.states) > 1 {
+		f.states = f.states[1:]
+	}
+	return &lightsail.GetContainerServicesOutput{ContainerServices: []types.ContainerService{
+		{ContainerServiceName: in.ServiceName, State: f.states[0]},
+	}}, nil
+}
+
+func ExampleCreateService() {
+	defer func() {
+		testNow, testSleep = nil, nil
+	}()
+	clock := time.Unix(1611796436, 0)
+	testNow = func() time.Time { return clock }
+	testSleep = func(_ context.Context, d time.Duration) error {
+		clock = clock.Add(d)
+		return nil
+	}
+
+	ctx := context.Background()
+	logger := internal.NewLogger(log.New(os.Stdout, "debug: ", 0), internal.LevelDebug)
+	in := &CreateServiceInput{Service: "doge", Power: "nano", Scale: 1}
+	for _, states := range [][]types.ContainerServiceState{
+		{"PENDING", "PENDING", "READY"},
+		{"PENDING", "DELETING"},
+		{"PENDING"},
+		nil,
+	} {
+		c := &fakeServiceCreator{states: states}
+		if err := CreateService(ctx, logger, in, c); err != nil {
+			fmt.Println(err)
+		}
+		fmt.Println("lightsail api calls:", len(c.log))
+	}
+
+	// Output:
+	// Service "doge" is being created.
+	// debug: service "doge" is PENDING
+	// debug: service "doge" is READY
+	// Service "doge" is ready.
+	// lightsail api calls: 3
+	// Service "doge" is being created.
+	// debug: service "doge" is PENDING
+	// debug: service "doge" is DELETING
+	// service "doge" is DELETING instead of becoming ready
+	// lightsail api calls: 2
+	// Service "doge" is being created.
+	// debug: service "doge" is PENDING
+	// service "doge" is still PENDING after 20m0s
+	// lightsail api calls: 121
+	// failed: create (doge, nano, 1)
+	// lightsail api calls: 1
+}
@@ -0,0 +1,103 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/aws/lightsailctl/internal"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+)
+
+// SignatureVerifier checks the signature of an image before it's pushed.
+type SignatureVerifier interface {
+	// VerifySignature returns an error unless the image that ref
+	// refers to by digest, e.g. "example.com/app@sha256:...",
+	// has a valid signature.
+	VerifySignature(ctx context.Context, ref string) error
+}
+
+// CommandVerifier verifies signatures by running a signing tool,
+// so that lightsailctl depends on none of their libraries.
+// The tool is run with Args followed by the image reference,
+// and the signature is valid when the tool succeeds.
+type CommandVerifier struct {
+	Args []string
+}
+
+// NewCosignVerifier returns a verifier of cosign signatures made with key,
+// which is a public key file, a KMS URI such as "awskms:///alias/signer",
+// or whatever else cosign verify --key accepts.
+func NewCosignVerifier(key string) *CommandVerifier {
+	return &CommandVerifier{Args: []string{"cosign", "verify", "--key", key}}
+}
+
+// NewNotationVerifier returns a verifier of Notary Project signatures,
+// which are checked against the trust policy and trust store
+// that notation is configured with.
+func NewNotationVerifier() *CommandVerifier {
+	return &CommandVerifier{Args: []string{"notation", "verify"}}
+}
+
+func (v *CommandVerifier) VerifySignature(ctx context.Context, ref string) error {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, v.Args[0], append(v.Args[1:], ref)...)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return fmt.Errorf("%s: %w: %s", v.Args[0], err, msg)
+		}
+		return fmt.Errorf("%s: %w", v.Args[0], err)
+	}
+	return nil
+}
+
+// verifySignature verifies the signature of the local image in.Image,
+// whose details are in info, by its digest in the registry it came from.
+// The image must have come from a registry, because that's where
+// signatures are kept.
+func verifySignature(
+	ctx context.Context,
+	logger *internal.Logger,
+	in *PushImageInput,
+	info types.ImageInspect,
+) error {
+	ref := signedRef(in.Image, info.RepoDigests)
+	if ref == "" {
+		return &stepError{
+			step: ErrSignatureInvalid,
+			err: fmt.Errorf("image %q has no repository digest, so its signature can't be verified; "+
+				"pull it from the registry where it's signed first", in.Image),
+		}
+	}
+	if err := in.SignatureVerifier.VerifySignature(ctx, ref); err != nil {
+		return &stepError{
+			step: ErrSignatureInvalid,
+			err:  fmt.Errorf("signature of image %q is not valid: %w", in.Image, err),
+		}
+	}
+	logger.Debugf("signature of image %q is verified as %s", in.Image, ref)
+	return nil
+}
+
+// signedRef returns the repo digest of image, preferring the one
+// in the repository that image is named after, or "" if there is none.
+func signedRef(image string, repoDigests []string) string {
+	if len(repoDigests) == 0 {
+		return ""
+	}
+	if named, err := reference.ParseNormalizedNamed(image); err == nil {
+		for _, rd := range repoDigests {
+			if rdNamed, err := reference.ParseNormalizedNamed(rd); err == nil && rdNamed.Name() == named.Name() {
+				return rd
+			}
+		}
+	}
+	return repoDigests[0]
+}
@@ -0,0 +1,99 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	dockertypes "github.com/docker/docker/api/types"
+)
+
+type fakeSignatureVerifier struct {
+	// signed are the references that have valid signatures.
+	signed map[string]bool
+	log    []string
+}
+
+func (f *fakeSignatureVerifier) VerifySignature(_ context.Context, ref string) error {
+	op := fmt.Sprintf("verify %q", ref)
+	f.log = append(f.log, op)
+	if !f.signed[ref] {
+		return fmt.Errorf("failed: %s", op)
+	}
+	return nil
+}
+
+func TestPushImageVerifiesSignature(t *testing.T) {
+	defer func() { testRngReader = nil }()
+
+	const (
+		signedDigest   = "sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa"
+		unsignedDigest = "sha256:0b159cd1ee1203dad901967ac55eee18c24da84ba3be384690304be93538bea8"
+	)
+	images := map[string]dockertypes.ImageInspect{
+		"example.com/app:1": {RepoDigests: []string{
+			"mirror.example.com/app@" + unsignedDigest,
+			"example.com/app@" + signedDigest,
+		}},
+		"example.com/app:2": {RepoDigests: []string{"example.com/app@" + unsignedDigest}},
+		"app:local":         {},
+	}
+	v := &fakeSignatureVerifier{signed: map[string]bool{"example.com/app@" + signedDigest: true}}
+
+	ctx := context.Background()
+	for i, test := range []struct {
+		image      string
+		wantErr    string
+		wantPushed bool
+	}{
+		{image: "example.com/app:1", wantPushed: true},
+		{image: "example.com/app:2", wantErr: `signature of image "example.com/app:2" is not valid`},
+		{image: "app:local", wantErr: "has no repository digest"},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			testRngReader = strings.NewReader("abcdefgh")
+			imgo := &fakeImageOperator{images: images}
+			in := &PushImageInput{Service: "doge", Image: test.image, Label: "www", SignatureVerifier: v}
+			_, err := PushImage(ctx, discardLog, in, &fakeLightsailImageOperator{}, imgo)
+			if test.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr) ||
+				!errors.Is(err, ErrSignatureInvalid)) {
+				t.Errorf("got err: %v, want it to contain %q", err, test.wantErr)
+			}
+			pushed := false
+			for _, op := range imgo.log {
+				pushed = pushed || strings.HasPrefix(op, "push ")
+			}
+			if pushed != test.wantPushed {
+				t.Errorf("pushed: %v, want: %v, log: %q", pushed, test.wantPushed, imgo.log)
+			}
+		})
+	}
+}
+
+func TestCommandVerifier(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh:", err)
+	}
+	ctx := context.Background()
+
+	v := &CommandVerifier{Args: []string{"sh", "-c", `test "$1" = example.com/app@sha256:abc`, "sh"}}
+	if err := v.VerifySignature(ctx, "example.com/app@sha256:abc"); err != nil {
+		t.Error(err)
+	}
+
+	v = &CommandVerifier{Args: []string{"sh", "-c", "echo no matching signatures >&2; exit 1", "sh"}}
+	err := v.VerifySignature(ctx, "example.com/app@sha256:abc")
+	if want := "sh: exit status 1: no matching signatures"; err == nil || err.Error() != want {
+		t.Errorf("got err: %v, want: %s", err, want)
+	}
+}
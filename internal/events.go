@@ -0,0 +1,91 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Types of events, in the order they usually occur.
+const (
+	// EventConfigResolved tells the region and profile in use.
+	EventConfigResolved = "configResolved"
+	// EventLoginCreated tells the service registry that was logged in to.
+	EventLoginCreated = "loginCreated"
+	// EventPushStarted tells that pushing Image to Service has started.
+	EventPushStarted = "pushStarted"
+	// EventPushProgress is a step of the push, see Phase.
+	EventPushProgress = "pushProgress"
+	// EventRegistered tells the Reference of the Image that was registered.
+	EventRegistered = "registered"
+	// EventDone ends every invocation, with Error if it failed,
+	// and with Result if the operation has one.
+	EventDone = "done"
+)
+
+// Event is a step of an invocation, written for programs that follow
+// along, such as GUIs and CI dashboards. Every event has Type and
+// Timestamp, and the other fields are set as far as they apply.
+type Event struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+
+	Operation string `json:"operation,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Profile   string `json:"profile,omitempty"`
+	Registry  string `json:"registry,omitempty"`
+	Service   string `json:"service,omitempty"`
+	Image     string `json:"image,omitempty"`
+	Reference string `json:"reference,omitempty"`
+	Digest    string `json:"digest,omitempty"`
+
+	// Phase is the status of the push, or of Layer when it's set,
+	// e.g. "Pushing", and Current and Total are the numbers of bytes
+	// of the layer pushed so far and in all.
+	Phase   string `json:"phase,omitempty"`
+	Layer   string `json:"layer,omitempty"`
+	Current int64  `json:"current,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+
+	// Error is why the invocation failed, and Result is what the
+	// operation produced, if it has a result, which may come along
+	// with Error when the operation only partly failed.
+	Error  string `json:"error,omitempty"`
+	Result any    `json:"result,omitempty"`
+}
+
+// Events writes events as newline-delimited JSON.
+// A nil *Events discards all events.
+type Events struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	// now, when set, replaces time.Now for timestamps.
+	now func() time.Time
+}
+
+func NewEvents(out io.Writer) *Events {
+	return &Events{enc: json.NewEncoder(out)}
+}
+
+// Emit writes ev with the current time as its timestamp.
+// Events may be emitted from several goroutines.
+func (e *Events) Emit(ev Event) {
+	if e == nil {
+		return
+	}
+	now := time.Now
+	if e.now != nil {
+		now = e.now
+	}
+	ev.Timestamp = now().UTC()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// Events are a side channel, so failing to write one
+	// mustn't fail the operation.
+	_ = e.enc.Encode(ev)
+}
@@ -0,0 +1,25 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package internal
+
+import (
+	"os"
+	"time"
+)
+
+func ExampleEvents() {
+	events := NewEvents(os.Stdout)
+	events.now = func() time.Time { return time.Unix(1611796436, 0) }
+	events.Emit(Event{Type: EventPushStarted, Service: "doge", Image: "nginx:latest"})
+	events.Emit(Event{Type: EventPushProgress, Phase: "Pushing", Layer: "3e5288f7a70f", Current: 512, Total: 1024})
+	events.Emit(Event{Type: EventDone, Operation: "PushContainerImage"})
+
+	var discard *Events
+	discard.Emit(Event{Type: EventDone})
+
+	// Output:
+	// {"type":"pushStarted","timestamp":"2021-01-28T01:13:56Z","service":"doge","image":"nginx:latest"}
+	// {"type":"pushProgress","timestamp":"2021-01-28T01:13:56Z","phase":"Pushing","layer":"3e5288f7a70f","current":512,"total":1024}
+	// {"type":"done","timestamp":"2021-01-28T01:13:56Z","operation":"PushContainerImage"}
+}
@@ -0,0 +1,76 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package internal
+
+import (
+	"fmt"
+	"log"
+)
+
+// Level is how much a Logger tells, each level includes the ones before it.
+type Level int
+
+const (
+	// LevelError is for failures only.
+	LevelError Level = iota
+	// LevelInfo adds warnings and other messages meant for users,
+	// it's the default level.
+	LevelInfo
+	// LevelDebug adds extra diagnostics.
+	LevelDebug
+)
+
+var levelNames = [...]string{LevelError: "error", LevelInfo: "info", LevelDebug: "debug"}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named s, which is one of "error", "info" and "debug".
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames {
+		if s == name {
+			return Level(l), nil
+		}
+	}
+	return 0, fmt.Errorf("log level %q is invalid: it must be %q, %q or %q",
+		s, LevelError, LevelInfo, LevelDebug)
+}
+
+// Logger writes the messages of its level and the levels before it to out.
+// A nil *Logger discards all messages.
+type Logger struct {
+	out   *log.Logger
+	level Level
+}
+
+func NewLogger(out *log.Logger, level Level) *Logger {
+	return &Logger{out: out, level: level}
+}
+
+// Enabled tells whether messages of level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level <= l.level
+}
+
+func (l *Logger) Errorf(format string, v ...any) {
+	l.logf(LevelError, format, v...)
+}
+
+func (l *Logger) Infof(format string, v ...any) {
+	l.logf(LevelInfo, format, v...)
+}
+
+func (l *Logger) Debugf(format string, v ...any) {
+	l.logf(LevelDebug, format, v...)
+}
+
+func (l *Logger) logf(level Level, format string, v ...any) {
+	if l.Enabled(level) {
+		l.out.Output(3, fmt.Sprintf(format, v...))
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package internal
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func ExampleLogger() {
+	for _, level := range []Level{LevelError, LevelInfo, LevelDebug} {
+		logger := NewLogger(log.New(os.Stdout, level.String()+": ", 0), level)
+		logger.Errorf("boom")
+		logger.Infof("WARNING: hmm")
+		logger.Debugf("details")
+	}
+
+	var discard *Logger
+	discard.Errorf("nobody hears this")
+
+	// Output:
+	// error: boom
+	// info: boom
+	// info: WARNING: hmm
+	// debug: boom
+	// debug: WARNING: hmm
+	// debug: details
+}
+
+func ExampleParseLevel() {
+	for _, s := range []string{"error", "info", "debug", "verbose"} {
+		level, err := ParseLevel(s)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Println(level)
+	}
+
+	// Output:
+	// error
+	// info
+	// debug
+	// log level "verbose" is invalid: it must be "error", "info" or "debug"
+}
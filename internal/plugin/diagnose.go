@@ -0,0 +1,141 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/lightsailctl/internal"
+)
+
+// diagnosis is a check of the environment that lightsailctl runs in.
+type diagnosis struct {
+	name string
+	// run returns what it found out, or why the check failed.
+	run func(context.Context) (string, error)
+	// hint tells what to do when the check fails.
+	hint string
+	// needsAWS tells that the check can't be done without AWS config,
+	// which the first check loads.
+	needsAWS bool
+}
+
+// diagnose checks that the container engine, AWS credentials, Lightsail
+// API and the update check all work, and prints a report of the checks
+// with hints about the failed ones. It changes nothing, neither locally
+// nor in AWS.
+func diagnose(ctx context.Context, _ json.RawMessage, c *OperationConfig, deps *operationDeps) error {
+	var (
+		cfg aws.Config
+		ls  *lightsail.Client
+	)
+	checks := []diagnosis{
+		{
+			name: "AWS configuration",
+			run: func(ctx context.Context) (string, error) {
+				var err error
+				if cfg, err = c.awsConfig(ctx); err != nil {
+					return "", err
+				}
+				if ls, err = c.lightsailClient(cfg); err != nil {
+					return "", err
+				}
+				if cfg.Region == "" {
+					return "", errors.New("no region is configured")
+				}
+				return "region " + cfg.Region, nil
+			},
+			hint: `set the region and profile with "aws configure", or in the configuration`,
+		},
+		{
+			name: "AWS credentials",
+			run: func(ctx context.Context) (string, error) {
+				out, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, new(sts.GetCallerIdentityInput))
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("account %s, %s", aws.ToString(out.Account), aws.ToString(out.Arn)), nil
+			},
+			hint:     `check the credentials of the profile, e.g. with "aws sts get-caller-identity", and sign in again if they have expired`,
+			needsAWS: true,
+		},
+		{
+			name: "Lightsail API",
+			run: func(ctx context.Context) (string, error) {
+				if _, err := ls.GetContainerAPIMetadata(ctx, new(lightsail.GetContainerAPIMetadataInput)); err != nil {
+					return "", err
+				}
+				return "reachable", nil
+			},
+			hint:     "check network access to the Lightsail endpoint, the proxyUrl and caBundle settings, and that Lightsail is available in the region",
+			needsAWS: true,
+		},
+		{
+			name: "Update check",
+			run: func(ctx context.Context) (string, error) {
+				available, outdated, err := internal.CheckUpdate(ctx, ls, internal.Version)
+				switch {
+				case err != nil:
+					return "", err
+				case outdated:
+					return fmt.Sprintf("%s is available, %s is in use", available, internal.Version), nil
+				}
+				return fmt.Sprintf("%s is up to date", internal.Version), nil
+			},
+			hint:     "the check isn't essential, set " + internal.NoUpdateCheckEnv + "=1 to skip it when it can't be done",
+			needsAWS: true,
+		},
+		{
+			name: "Container engine",
+			run: func(ctx context.Context) (string, error) {
+				dc, err := c.imageEngine(ctx, deps.logger, deps.stderr, nil)
+				if err != nil {
+					return "", err
+				}
+				defer dc.Close()
+				return "reachable", nil
+			},
+			hint: "start Docker Engine or Podman, or set dockerHost in the configuration, or DOCKER_HOST, to where it listens",
+		},
+	}
+	return runDiagnoses(ctx, deps.stdout, checks)
+}
+
+// runDiagnoses runs the checks in order and writes a report of them to w.
+// The checks that need AWS config are skipped when the first check,
+// which loads it, fails. It returns an error if any check failed.
+func runDiagnoses(ctx context.Context, w io.Writer, checks []diagnosis) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	failed, noAWS := 0, false
+	for i, d := range checks {
+		if d.needsAWS && noAWS {
+			fmt.Fprintf(tw, "SKIP\t%s\tneeds %s\n", d.name, checks[0].name)
+			continue
+		}
+		detail, err := d.run(ctx)
+		if err != nil {
+			failed++
+			noAWS = noAWS || i == 0
+			fmt.Fprintf(tw, "FAIL\t%s\t%v\n", d.name, err)
+			fmt.Fprintf(tw, "\t\thint: %s\n", d.hint)
+			continue
+		}
+		fmt.Fprintf(tw, "PASS\t%s\t%s\n", d.name, detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+func Example_runDiagnoses() {
+	pass := func(detail string) func(context.Context) (string, error) {
+		return func(context.Context) (string, error) { return detail, nil }
+	}
+	fail := func(context.Context) (string, error) { return "", errors.New("boom") }
+
+	ctx := context.Background()
+	for _, first := range []func(context.Context) (string, error){pass("region us-west-2"), fail} {
+		err := runDiagnoses(ctx, os.Stdout, []diagnosis{
+			{name: "AWS configuration", run: first, hint: "configure"},
+			{name: "AWS credentials", run: pass("account 111122223333"), hint: "sign in", needsAWS: true},
+			{name: "Lightsail API", run: fail, hint: "check the network", needsAWS: true},
+			{name: "Container engine", run: pass("reachable"), hint: "start it"},
+		})
+		fmt.Println(err)
+	}
+
+	// Output:
+	// PASS  AWS configuration  region us-west-2
+	// PASS  AWS credentials    account 111122223333
+	// FAIL  Lightsail API      boom
+	//                          hint: check the network
+	// PASS  Container engine   reachable
+	// 1 of 4 checks failed
+	// FAIL  AWS configuration  boom
+	//                          hint: configure
+	// SKIP  AWS credentials    needs AWS configuration
+	// SKIP  Lightsail API      needs AWS configuration
+	// PASS  Container engine   reachable
+	// 1 of 4 checks failed
+}
@@ -0,0 +1,81 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/aws/lightsailctl/internal/cs"
+	"github.com/aws/smithy-go"
+)
+
+// Exit codes of lightsailctl --plugin, by what went wrong,
+// so that scripts can tell failures apart.
+const (
+	exitFailure   = 1 // anything not covered below
+	exitBadInput  = 2 // invalid input, payload or configuration
+	exitAuth      = 3 // missing, expired or insufficient credentials
+	exitContainer = 4 // local container engine failure
+	exitAWS       = 5 // AWS API failure
+)
+
+// inputError is a problem with the plugin input,
+// as opposed to a failure of the operation it asks for.
+type inputError struct {
+	err error
+}
+
+func (e *inputError) Error() string {
+	return e.err.Error()
+}
+
+func (e *inputError) Unwrap() error {
+	return e.err
+}
+
+func inputErrorf(format string, a ...any) error {
+	return &inputError{err: fmt.Errorf(format, a...)}
+}
+
+// authErrorCodes are AWS API error codes of credentials problems.
+var authErrorCodes = map[string]bool{
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"InvalidClientTokenId":        true,
+	"InvalidSignatureException":   true,
+	"SignatureDoesNotMatch":       true,
+	"UnauthorizedException":       true,
+	"UnrecognizedClientException": true,
+}
+
+// credentialsErrorRE matches AWS SDK errors about getting credentials,
+// which are not of any particular type.
+var credentialsErrorRE = regexp.MustCompile(`get identity: |failed to (retrieve|refresh cached) credentials`)
+
+// exitCode returns the process exit code that tells what kind of error err is.
+func exitCode(err error) int {
+	var (
+		inErr    *inputError
+		notFound *cs.LocalImageNotFoundError
+		apiErr   smithy.APIError
+		opErr    *smithy.OperationError
+	)
+	switch {
+	case errors.As(err, &inErr), errors.As(err, &notFound):
+		return exitBadInput
+	case errors.Is(err, cs.ErrLoginFailed) && cs.IsAccessDenied(err),
+		errors.As(err, &apiErr) && authErrorCodes[apiErr.ErrorCode()],
+		credentialsErrorRE.MatchString(err.Error()):
+		return exitAuth
+	case errors.Is(err, cs.ErrEngineUnavailable), errors.Is(err, cs.ErrTagFailed), errors.Is(err, cs.ErrPushFailed):
+		return exitContainer
+	case errors.Is(err, cs.ErrRegisterFailed), errors.As(err, &opErr):
+		return exitAWS
+	}
+	return exitFailure
+}
@@ -0,0 +1,57 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/aws/lightsailctl/internal/cs"
+	"github.com/aws/smithy-go"
+)
+
+func TestExitCode(t *testing.T) {
+	opErr := func(err error) error {
+		return &smithy.OperationError{ServiceID: "Lightsail", OperationName: "RegisterContainerImage", Err: err}
+	}
+	_, badEngine := (&OperationConfig{Engine: "containerd"}).imageEngine(context.Background(), nil, nil, nil)
+	loginErr := func(err error) error {
+		return fmt.Errorf("%w: %w", cs.ErrLoginFailed, opErr(err))
+	}
+	invoke := func(in *Input) error {
+		_, err := invokeOperation(context.Background(), in, nil)
+		return err
+	}
+
+	for i, test := range []struct {
+		err  error
+		want int
+	}{
+		{errors.New("boom"), exitFailure},
+		{invoke(&Input{Operation: "Bogus"}), exitBadInput},
+		{invoke(&Input{Operation: "DeleteContainerImage", Payload: []byte(`{}`)}), exitBadInput},
+		{badEngine, exitBadInput},
+		{&cs.BatchPushError{Failed: &cs.PushImageInput{Image: "nginx"}, Err: &cs.LocalImageNotFoundError{Image: "nginx"}}, exitBadInput},
+		{fmt.Errorf("push: %w", cs.ErrLoginFailed), exitFailure},
+		{loginErr(&smithy.GenericAPIError{Code: "UnauthorizedOperation", Message: "not allowed"}), exitAuth},
+		{loginErr(&smithy.GenericAPIError{Code: "ThrottlingException", Message: "slow down"}), exitAWS},
+		{opErr(&smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not allowed"}), exitAuth},
+		{opErr(fmt.Errorf("get identity: get credentials: failed to refresh cached credentials")), exitAuth},
+		{fmt.Errorf("engine: %w", cs.ErrEngineUnavailable), exitContainer},
+		{&cs.BatchPushError{Failed: &cs.PushImageInput{Image: "nginx"}, Err: fmt.Errorf("push: %w", cs.ErrPushFailed)}, exitContainer},
+		{&cs.PushImagesError{Failed: []*cs.PushImageInput{{Image: "nginx"}}, Errs: []error{&cs.LocalImageNotFoundError{Image: "nginx"}}}, exitBadInput},
+		{fmt.Errorf("tag: %w", cs.ErrTagFailed), exitContainer},
+		{fmt.Errorf("register: %w", cs.ErrRegisterFailed), exitAWS},
+		{opErr(&smithy.GenericAPIError{Code: "NotFoundException", Message: "no such service"}), exitAWS},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			if got := exitCode(test.err); got != test.want {
+				t.Errorf("%v: got exit code %d, want %d", test.err, got, test.want)
+			}
+		})
+	}
+}
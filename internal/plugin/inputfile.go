@@ -0,0 +1,58 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// maxInputFileSize bounds how much of the input file is read.
+const maxInputFileSize = 1 << 20
+
+// inputFileTimeout limits how long reading the input file may take,
+// it may be changed by tests.
+var inputFileTimeout = 30 * time.Second
+
+// readInputFile reads the named file until EOF. The file may be
+// a named pipe, whose writer may be slow to open it, slow to write
+// or may never close it, hence the timeout instead of os.ReadFile.
+func readInputFile(name string) ([]byte, error) {
+	type result struct {
+		b   []byte
+		err error
+	}
+	// Both opening and reading a named pipe may block, so this is done
+	// in a goroutine that's abandoned on timeout: the process exits soon
+	// after anyway.
+	done := make(chan result, 1)
+	go func() {
+		f, err := os.Open(name)
+		if err != nil {
+			done <- result{err: fmt.Errorf("read input file: %w", err)}
+			return
+		}
+		defer f.Close()
+
+		b, err := io.ReadAll(io.LimitReader(f, maxInputFileSize+1))
+		switch {
+		case err != nil:
+			err = fmt.Errorf("read input file: %w", err)
+		case len(b) > maxInputFileSize:
+			err = fmt.Errorf("input file %s is larger than %d bytes", name, maxInputFileSize)
+		}
+		done <- result{b, err}
+	}()
+
+	timer := time.NewTimer(inputFileTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.b, r.err
+	case <-timer.C:
+		return nil, fmt.Errorf("input file %s was not read to the end in %v", name, inputFileTimeout)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build unix
+
+package plugin
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func mkfifo(t *testing.T) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "input")
+	if err := syscall.Mkfifo(name, 0o600); err != nil {
+		t.Skipf("cannot create a named pipe: %v", err)
+	}
+	return name
+}
+
+func TestReadInputFileFromSlowFIFO(t *testing.T) {
+	name := mkfifo(t)
+	input := `{"inputVersion": "1", "operation": "GetContainerImages", "payload": {"service": "doge"}}`
+
+	go func() {
+		// Opening for writing blocks until the reader opens the pipe.
+		f, err := os.OpenFile(name, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		for _, chunk := range []string{input[:10], input[10:40], input[40:]} {
+			time.Sleep(20 * time.Millisecond)
+			if _, err := io.WriteString(f, chunk); err != nil {
+				return
+			}
+		}
+	}()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	in, err := readInput(fs, []string{"-input-file", name}, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Operation != "GetContainerImages" || string(in.Payload) != `{"service": "doge"}` {
+		t.Errorf("got %#v", in)
+	}
+}
+
+func TestReadInputFileFromUnclosedFIFO(t *testing.T) {
+	defer func(d time.Duration) { inputFileTimeout = d }(inputFileTimeout)
+	inputFileTimeout = 50 * time.Millisecond
+
+	name := mkfifo(t)
+	// Opening for both reading and writing doesn't block, and keeps
+	// the pipe open, so the reader never gets EOF.
+	w, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := io.WriteString(w, `{"inputVersion": "1",`); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = readInputFile(name)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("reading took %v", elapsed)
+	}
+	if want := "was not read to the end in 50ms"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got err: %v, that doesn't contain %q", err, want)
+	}
+}
+
+func TestReadInputFileSizeLimit(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "input")
+	if err := os.WriteFile(name, bytes.Repeat([]byte(" "), maxInputFileSize+1), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readInputFile(name); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("got err: %v", err)
+	}
+
+	if _, err := readInputFile(filepath.Join(t.TempDir(), "missing")); err == nil ||
+		!strings.Contains(err.Error(), "read input file") {
+		t.Errorf("got err: %v", err)
+	}
+}
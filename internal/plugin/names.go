@@ -0,0 +1,42 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Lightsail container service names and image labels are made of
+// lowercase letters, digits and hyphens, which may separate words
+// but may not start or end a name.
+var nameRE = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+const (
+	maxServiceNameLen = 63
+	maxLabelLen       = 53
+)
+
+// checkName returns an error telling what is wrong with name,
+// which is what describes, such as "service name".
+// Lightsail API would reject such names anyway,
+// but with an error that doesn't point at the field.
+func checkName(what, name string, maxLen int) error {
+	switch {
+	case len(name) > maxLen:
+		return fmt.Errorf("%s %q is too long: it must be at most %d characters", what, name, maxLen)
+	case !nameRE.MatchString(name):
+		return fmt.Errorf("%s %q is invalid: it must contain only lowercase letters, digits and hyphens, "+
+			"and must not start or end with a hyphen", what, name)
+	}
+	return nil
+}
+
+func checkServiceName(name string) error {
+	return checkName("service name", name, maxServiceNameLen)
+}
+
+func checkLabel(what, label string) error {
+	return checkName(what, label, maxLabelLen)
+}
@@ -0,0 +1,47 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCheckName(t *testing.T) {
+	for i, test := range []struct {
+		name, errContains string
+	}{
+		{name: "hello"},
+		{name: "my-service-2"},
+		{name: "7"},
+		{name: strings.Repeat("a", maxServiceNameLen)},
+		{name: "", errContains: "invalid"},
+		{name: "Hello", errContains: "invalid"},
+		{name: "-hello", errContains: "invalid"},
+		{name: "hello-", errContains: "invalid"},
+		{name: "hello--world", errContains: "invalid"},
+		{name: "hello_world", errContains: "invalid"},
+		{name: "hello.world", errContains: "invalid"},
+		{name: strings.Repeat("a", maxServiceNameLen+1), errContains: "at most 63 characters"},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			err := checkServiceName(test.name)
+			if test.errContains == "" {
+				if err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.errContains) {
+				t.Errorf("got err: %v, want one that contains %q", err, test.errContains)
+			}
+		})
+	}
+
+	if err := checkLabel("container label", strings.Repeat("a", maxLabelLen+1)); err == nil ||
+		!strings.Contains(err.Error(), "container label") {
+		t.Errorf("got err: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// payloadValidators check the payload of an operation the way
+// the input version calls for, before the operation parses it.
+// Every version from minInputVersion to maxInputVersion has one.
+var payloadValidators = map[int]func(op operation, payload json.RawMessage) error{
+	// Versions 0 and 1 predate the checks, their payloads are taken
+	// as they are; AWS CLI sends version 1.
+	0: acceptPayload,
+	1: acceptPayload,
+	2: checkPayloadFields,
+}
+
+func acceptPayload(operation, json.RawMessage) error { return nil }
+
+// payloadFields returns the payload fields named by the json tags
+// of the structs vs, and those of them that are required, which are
+// tagged `payload:"required"`. The fields of embedded structs without
+// a json tag count as their own, the same as encoding/json has it.
+func payloadFields(vs ...any) (fields, required []string) {
+	var add func(t reflect.Type)
+	add = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if f.Anonymous && name == "" {
+				add(f.Type)
+				continue
+			}
+			if name == "" || name == "-" || slices.Contains(fields, name) {
+				continue
+			}
+			fields = append(fields, name)
+			if f.Tag.Get("payload") == "required" {
+				required = append(required, name)
+			}
+		}
+	}
+	for _, v := range vs {
+		add(reflect.TypeOf(v))
+	}
+	return fields, required
+}
+
+// checkPayloadFields returns an error if payload has fields that
+// op doesn't accept, such as misspelt ones, or lacks required ones.
+// The fields of nested objects are left to the operation.
+func checkPayloadFields(op operation, payload json.RawMessage) error {
+	var fields map[string]json.RawMessage
+	if len(payload) != 0 {
+		if err := json.Unmarshal(payload, &fields); err != nil {
+			return errors.New("payload must be a JSON object")
+		}
+	}
+
+	var errs []error
+	unknown := make([]string, 0, len(fields))
+	for name := range fields {
+		if !slices.Contains(op.fields, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		if s := suggestField(name, op.fields); s != "" {
+			errs = append(errs, fmt.Errorf("unknown field %q, did you mean %q?", name, s))
+		} else {
+			errs = append(errs, fmt.Errorf("unknown field %q", name))
+		}
+	}
+	for _, name := range op.required {
+		if strings.Contains(name, " ") {
+			// Only the operation can tell which combinations are fine.
+			continue
+		}
+		if _, ok := fields[name]; !ok {
+			errs = append(errs, fmt.Errorf("required field %q is missing", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// suggestField returns the field of known that name is likely
+// a typo of, or "" if there's no such field.
+func suggestField(name string, known []string) string {
+	best, bestDist := "", 3
+	for _, k := range known {
+		if strings.EqualFold(name, k) {
+			return k
+		}
+		if d := editDistance(name, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
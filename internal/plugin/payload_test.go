@@ -0,0 +1,113 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPayloadValidators(t *testing.T) {
+	for ver := minInputVersion; ver <= maxInputVersion; ver++ {
+		if payloadValidators[ver] == nil {
+			t.Errorf("inputVersion %d has no payload validator", ver)
+		}
+	}
+	for name, op := range operations {
+		for _, field := range op.required {
+			if !strings.Contains(field, " ") && !slices.Contains(op.fields, field) {
+				t.Errorf("%s requires %q, which is not among its fields", name, field)
+			}
+		}
+	}
+}
+
+func TestPayloadFields(t *testing.T) {
+	type embedded struct {
+		Image string `json:"image" payload:"required"`
+		Label string `json:"label,omitempty"`
+	}
+	type first struct {
+		Service string `json:"service" payload:"required"`
+		embedded
+		Images  []embedded `json:"images"`
+		Skipped string     `json:"-"`
+	}
+	type second struct {
+		Service string `json:"service"`
+		Extra   string `json:"extra"`
+	}
+	fields, required := payloadFields(first{}, second{})
+	if want := []string{"service", "image", "label", "images", "extra"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("got fields %q, want %q", fields, want)
+	}
+	if want := []string{"service", "image"}; !reflect.DeepEqual(required, want) {
+		t.Errorf("got required %q, want %q", required, want)
+	}
+}
+
+func TestPayloadValidatorsByVersion(t *testing.T) {
+	push := operations["PushContainerImage"]
+	del := operations["DeleteContainerImage"]
+	for i, test := range []struct {
+		ver     int
+		op      operation
+		payload string
+		wantErr string
+	}{
+		// Versions 0 and 1 take payloads as they are.
+		{0, push, `{"service": "doge", "image": "nginx", "lable": "www"}`, ""},
+		{0, del, `{}`, ""},
+		{0, del, `[]`, ""},
+		{1, push, `{"service": "doge", "image": "nginx", "lable": "www"}`, ""},
+		{1, del, `{}`, ""},
+
+		{2, push, `{"service": "doge", "image": "nginx", "label": "www"}`, ""},
+		{2, push, `{"service": "doge", "images": [{"image": "nginx", "label": "www"}], "createDeployment": {}}`, ""},
+		{2, push, `{"service": "doge", "image": "nginx", "lable": "www"}`, `unknown field "lable", did you mean "label"?`},
+		{2, push, `{"service": "doge", "image": "nginx", "label": "www", "Platform": "linux/arm64"}`, `unknown field "Platform", did you mean "platform"?`},
+		{2, push, `{"service": "doge", "image": "nginx", "label": "www", "colour": "blue"}`, `unknown field "colour"`},
+		{2, push, `{"image": "nginx", "label": "www"}`, `required field "service" is missing`},
+		{2, del, `{"service": "doge", "image": ":doge.www.3"}`, ""},
+		{2, del, `{"servise": "doge"}`, "unknown field \"servise\", did you mean \"service\"?\n" +
+			"required field \"service\" is missing\nrequired field \"image\" is missing"},
+		{2, del, `[]`, "payload must be a JSON object"},
+		{2, operations["GetContainerServices"], ``, ""},
+		{2, operations["GetContainerServices"], `null`, ""},
+		{2, operations["Diagnose"], `{"verbose": true}`, `unknown field "verbose"`},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			err := payloadValidators[test.ver](test.op, json.RawMessage(test.payload))
+			switch {
+			case err == nil && test.wantErr != "":
+				t.Errorf("got no error, want %q", test.wantErr)
+			case err != nil && err.Error() != test.wantErr:
+				t.Errorf("got error %q, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestInvokeOperationChecksPayload(t *testing.T) {
+	in := &Input{
+		InputVersion:  "2",
+		Operation:     "DeleteContainerImage",
+		Payload:       json.RawMessage(`{"service": "doge", "imgae": ":doge.www.3"}`),
+		Configuration: OperationConfig{Region: "us-west-2", DryRun: true},
+	}
+	_, err := invokeOperation(context.Background(), in, nil)
+	want := "invalid payload of inputVersion 2: unknown field \"imgae\", did you mean \"image\"?\n" +
+		"required field \"image\" is missing"
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	if got := exitCode(err); got != exitBadInput {
+		t.Errorf("got exit code %d, want %d", got, exitBadInput)
+	}
+}
@@ -8,30 +8,88 @@ package plugin
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/lightsailctl/internal"
 	"github.com/aws/lightsailctl/internal/cs"
 	smithyMW "github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/distribution/reference"
 )
 
 func Main(progname string, args []string) {
-	input, inputStdin := "", false
+	in, err := readInput(flag.NewFlagSet(progname, flag.ExitOnError), args, os.Stdin)
+	if err != nil {
+		log.Print(err)
+		os.Exit(exitBadInput)
+	}
+
+	level, err := in.Configuration.logLevel()
+	if err != nil {
+		log.Print(err)
+		os.Exit(exitBadInput)
+	}
+	logger := internal.NewLogger(log.Default(), level)
+	if id := in.Configuration.CorrelationID; id != "" {
+		logger.Debugf("correlation ID: %s", id)
+	}
+
+	// Ctrl-C or termination cancels the operation instead of killing
+	// the process, so that it cleans up after itself, e.g. removes
+	// the temporary tag of the image being pushed.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	var res any
+	err = in.Configuration.withTimeout(ctx, func(ctx context.Context) (err error) {
+		res, err = invokeOperation(ctx, in, logger)
+		return err
+	})
+	stop()
+	in.Configuration.writeResult(in.Operation, res, err)
+	if err != nil {
+		logger.Errorf("%v", err)
+		os.Exit(exitCode(err))
+	}
+}
 
-	fs := flag.NewFlagSet(progname, flag.ExitOnError)
+// readInput parses command line args with fs and returns the plugin input
+// they specify, reading the payload from stdin if asked to.
+func readInput(fs *flag.FlagSet, args []string, stdin io.Reader) (*Input, error) {
+	input, inputStdin, inputFile, operation := "", false, "", ""
+
+	// Configuration values given as flags take precedence over the payload.
+	var flagConfig OperationConfig
 
 	const inputFlag = "input"
 	fs.StringVar(&input, inputFlag, "", "plugin `payload`")
@@ -39,34 +97,62 @@ func Main(progname string, args []string) {
 	const inputStdinFlag = "input-stdin"
 	fs.BoolVar(&inputStdin, inputStdinFlag, false, "receive plugin payload on stdin")
 
-	_ = fs.Parse(args)
+	const inputFileFlag = "input-file"
+	fs.StringVar(&inputFile, inputFileFlag, "", "read plugin payload from `file`, which may be a named pipe")
 
-	if input == "" && !inputStdin {
-		fs.Usage()
-		log.Fatalf("no plugin input: either %q or %q flag must be specified",
-			fs.Lookup(inputFlag).Name,
-			fs.Lookup(inputStdinFlag).Name)
-	}
+	fs.StringVar(&operation, "operation", "", "plugin `operation`, overrides the one in the payload; "+
+		"operations that need no payload can be invoked without any input")
 
-	var r io.Reader = strings.NewReader(input)
-	if inputStdin {
-		r = os.Stdin
-	}
+	fs.StringVar(&flagConfig.Endpoint, "endpoint", "", "Lightsail API endpoint `URL`, overrides the one in the payload")
 
-	in, err := parseInput(r)
-	if err != nil {
-		log.Fatalf("invalid plugin input: %v", err)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
 	}
 
-	// This is a logger used for extra diagnostics, when the debugging mode is on.
-	debugLog := log.New(log.Writer(), log.Prefix(), log.Flags())
-	if !in.Configuration.Debug {
-		debugLog.SetOutput(io.Discard)
+	var in *Input
+	if input == "" && !inputStdin && inputFile == "" {
+		if !noPayloadOperations[operation] {
+			fs.Usage()
+			return nil, fmt.Errorf("no plugin input: one of %q, %q or %q flags must be specified",
+				fs.Lookup(inputFlag).Name,
+				fs.Lookup(inputStdinFlag).Name,
+				fs.Lookup(inputFileFlag).Name)
+		}
+		in = &Input{InputVersion: "1"}
+	} else {
+		var r io.Reader = strings.NewReader(input)
+		switch {
+		case inputStdin:
+			r = stdin
+		case inputFile != "":
+			b, err := readInputFile(inputFile)
+			if err != nil {
+				return nil, err
+			}
+			r = bytes.NewReader(b)
+		}
+
+		var err error
+		if in, err = parseInput(r); err != nil {
+			return nil, fmt.Errorf("invalid plugin input: %w", err)
+		}
 	}
 
-	if err := invokeOperation(context.Background(), in, debugLog); err != nil {
-		log.Fatal(err)
+	if operation != "" {
+		in.Operation = operation
 	}
+	in.Configuration.override(&flagConfig)
+	return in, nil
+}
+
+// noPayloadOperations can be invoked with the operation flag alone.
+var noPayloadOperations = map[string]bool{
+	"Diagnose":                true,
+	"GetContainerAPIMetadata": true,
+	"GetContainerServices":    true,
+	"GetRegistryHost":         true,
+	"ListOperations":          true,
+	"SchemaInfo":              true,
 }
 
 type Input struct {
@@ -77,121 +163,1616 @@ type Input struct {
 }
 
 type OperationConfig struct {
-	Debug          bool   `json:"debug,omitempty"`
-	Endpoint       string `json:"endpoint,omitempty"`
+	// LogLevel is "error", "info" (default) or "debug",
+	// and Debug is the same as "debug".
+	LogLevel string `json:"logLevel,omitempty"`
+	Debug    bool   `json:"debug,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+	// Region and Profile take precedence over AWS_REGION and AWS_PROFILE
+	// environment variables (or AWS_DEFAULT_*), which take precedence
+	// over the shared config files.
 	Region         string `json:"region,omitempty"`
 	Profile        string `json:"profile,omitempty"`
 	CABundle       string `json:"caBundle,omitempty"`
 	DoNotVerifySSL bool   `json:"doNotVerifySSL,omitempty"`
+	// ConfigFiles and CredentialFiles replace the shared config and
+	// credentials files that profiles are read from, which are otherwise
+	// AWS_CONFIG_FILE and AWS_SHARED_CREDENTIALS_FILE, or ~/.aws/config
+	// and ~/.aws/credentials. Profiles with credential_process, SSO and
+	// the like are resolved from them the same way.
+	ConfigFiles     []string `json:"configFiles,omitempty"`
+	CredentialFiles []string `json:"credentialFiles,omitempty"`
+	// CABundlePEM is the CA bundle itself, as opposed to
+	// the path of a file that contains it in CABundle.
+	CABundlePEM string `json:"caBundlePem,omitempty"`
+	// ProxyURL is the HTTP proxy for AWS API calls and for connecting
+	// to a tcp Docker host. HTTP(S)_PROXY environment variables
+	// are used when it's not specified.
+	ProxyURL string `json:"proxyUrl,omitempty"`
+	// RegistryEndpointOverride replaces the host of the service registry
+	// that images are pushed to, e.g. "localhost:5000" for a local test
+	// registry, optionally followed by a path for path-style routing.
+	// The repo path, "/sr", is appended to it the same as to the original.
+	RegistryEndpointOverride string `json:"registryEndpointOverride,omitempty"`
+	// DockerHost is the address of Docker Engine to use instead of
+	// the one derived from DOCKER_HOST environment variable.
+	DockerHost string `json:"dockerHost,omitempty"`
+	// DockerAPIVersion pins Docker Engine API version, e.g. "1.41",
+	// which is otherwise negotiated with Docker Engine.
+	DockerAPIVersion string `json:"dockerApiVersion,omitempty"`
+	// DockerResponseHeaderTimeoutSeconds limits how long the container
+	// engine may take to start responding to a request, 120 seconds
+	// by default, and DockerTimeoutSeconds limits whole requests, such
+	// as pushes, which aren't limited by default.
+	DockerResponseHeaderTimeoutSeconds int `json:"dockerResponseHeaderTimeoutSeconds,omitempty"`
+	DockerTimeoutSeconds               int `json:"dockerTimeoutSeconds,omitempty"`
+	// Engine selects the local container engine: "docker" (default) or "podman".
+	Engine string `json:"engine,omitempty"`
+	// DryRun makes operations that change anything do all the checks
+	// they can and then tell what they would do, instead of doing it.
+	DryRun bool `json:"dryRun,omitempty"`
+	// ProgressFormat is "text" (default) for push progress displayed
+	// for humans, or "json" for JSON lines meant for programs.
+	ProgressFormat string `json:"progressFormat,omitempty"`
+	// ProgressLogFile is a file where push progress is appended
+	// as plain text or JSON lines, in addition to stderr.
+	ProgressLogFile string `json:"progressLogFile,omitempty"`
+	// MaxConcurrentUploads is a hint of how many image layers to upload
+	// at the same time. Docker Engine only honors its daemon-wide
+	// max-concurrent-uploads setting, so the hint is merely logged.
+	MaxConcurrentUploads int `json:"maxConcurrentUploads,omitempty"`
+	// Compression is the compression of image layers to push with,
+	// "gzip" or "zstd", where the container engine lets it be chosen.
+	// The engine decides when it's not specified.
+	Compression string `json:"compression,omitempty"`
+	// MetricsNamespace is a CloudWatch namespace where push duration,
+	// outcome and image size are published, if it's specified.
+	MetricsNamespace string `json:"metricsNamespace,omitempty"`
+	// TimeoutSeconds limits how long the whole operation may take,
+	// there is no limit when it's zero.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// RoleARN is an IAM role to assume with the credentials from
+	// the usual sources, which is handy for pushing into another account.
+	// RoleSessionName and ExternalID are optional parameters of AssumeRole.
+	RoleARN         string `json:"roleArn,omitempty"`
+	RoleSessionName string `json:"roleSessionName,omitempty"`
+	ExternalID      string `json:"externalId,omitempty"`
+	// SSOSession is the name of the sso-session that the profile signs in
+	// with, for telling how to sign in again when the SSO token expires.
+	// It's only needed when the shared config doesn't tell that.
+	SSOSession string `json:"ssoSession,omitempty"`
+	// CacheRegistryLogin keeps registry logins in the user's cache
+	// directory to reuse in later invocations until they expire,
+	// instead of creating a new one on every push. It's off by default,
+	// because the cache holds working registry credentials.
+	CacheRegistryLogin bool `json:"cacheRegistryLogin,omitempty"`
+	// MaxRetries is how many times a failed AWS API call may be retried,
+	// and RetryMode is "standard" or "adaptive". SDK defaults apply
+	// when they are not set.
+	MaxRetries *int   `json:"maxRetries,omitempty"`
+	RetryMode  string `json:"retryMode,omitempty"`
+	// CorrelationID, when set, is sent with every AWS API request
+	// in correlationIDHeader, so that the requests of this invocation
+	// can be found in server-side logs.
+	CorrelationID string `json:"correlationId,omitempty"`
+	// UserAgentExtra is added to the user agent of AWS API requests,
+	// e.g. "my-ci/1.2", so that the tools which run lightsailctl
+	// can tell their traffic apart.
+	UserAgentExtra string `json:"userAgentExtra,omitempty"`
+	// UpdateDownloadURL overrides the download page that the update
+	// warning points to, which otherwise depends on the AWS partition.
+	UpdateDownloadURL string `json:"updateDownloadUrl,omitempty"`
+	// EventsFormat is "ndjson" for writing the steps of the invocation
+	// to stdout as JSON lines of internal.Event, for programs that follow
+	// along. Messages meant for users go to stderr then. There are no
+	// events by default.
+	EventsFormat string `json:"eventsFormat,omitempty"`
 	// CLIVersion is the version of the calling CLI,
 	// for diagnostics and logging purposes.
 	CLIVersion string `json:"cliVersion"`
+
+	// logger, when set, receives the effective configuration
+	// at debug level once AWS config is loaded.
+	logger *internal.Logger
+	// events receives the steps of the invocation, see EventsFormat.
+	events *internal.Events
+}
+
+const correlationIDHeader = "X-Lightsailctl-Correlation-Id"
+
+// override replaces c's fields with those that are set in other.
+func (c *OperationConfig) override(other *OperationConfig) {
+	if other.Endpoint != "" {
+		c.Endpoint = other.Endpoint
+	}
+}
+
+// DebugEnv is the environment variable that enables debug logging,
+// including that of AWS SDK, when it's set to a true value, as understood
+// by strconv.ParseBool, the same as Debug does in the payload.
+const DebugEnv = "LIGHTSAILCTL_DEBUG"
+
+// logLevel returns the level of c.LogLevel, or of c.Debug
+// which predates it. Either c.Debug or DebugEnv enables debug.
+func (c *OperationConfig) logLevel() (internal.Level, error) {
+	level := internal.LevelInfo
+	if c.LogLevel != "" {
+		var err error
+		if level, err = internal.ParseLevel(c.LogLevel); err != nil {
+			return 0, inputErrorf("invalid logLevel: %w", err)
+		}
+	}
+	if debug, _ := strconv.ParseBool(os.Getenv(DebugEnv)); debug || c.Debug {
+		level = internal.LevelDebug
+	}
+	return level, nil
+}
+
+// withTimeout calls op with ctx limited by c.TimeoutSeconds, if any,
+// and makes it clear in the returned error when the time has run out.
+func (c *OperationConfig) withTimeout(ctx context.Context, op func(context.Context) error) error {
+	if c.TimeoutSeconds <= 0 {
+		return op(ctx)
+	}
+	timeout := time.Duration(c.TimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := op(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("operation timed out after %v: %w", timeout, err)
+	}
+	return err
 }
 
 func (c *OperationConfig) awsConfig(ctx context.Context) (aws.Config, error) {
 	var opts []func(*config.LoadOptions) error
 
-	opts = append(opts, config.WithAPIOptions([]func(*smithyMW.Stack) error{
+	apiOptions := []func(*smithyMW.Stack) error{
 		middleware.AddUserAgentKeyValue("lightsailctl", internal.Version.String()),
-	}))
+	}
+	if c.CorrelationID != "" {
+		apiOptions = append(apiOptions, smithyhttp.AddHeaderValue(correlationIDHeader, c.CorrelationID))
+	}
+	if c.UserAgentExtra != "" {
+		ua, err := userAgentExtra(c.UserAgentExtra)
+		if err != nil {
+			return aws.Config{}, err
+		}
+		apiOptions = append(apiOptions, ua)
+	}
+	opts = append(opts, config.WithAPIOptions(apiOptions))
+
+	// Region and profile of the payload take precedence over environment
+	// variables, which take precedence over the shared config files.
+	region, regionSource := settingSource(c.Region, "AWS_REGION", "AWS_DEFAULT_REGION")
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	profile, profileSource := settingSource(c.Profile, "AWS_PROFILE", "AWS_DEFAULT_PROFILE")
+	if profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	}
+
+	// The SDK skips shared files that don't exist, which would leave
+	// the profiles in them unnoticed.
+	if err := checkFilesExist("configFiles", c.ConfigFiles); err != nil {
+		return aws.Config{}, err
+	}
+	if len(c.ConfigFiles) > 0 {
+		opts = append(opts, config.WithSharedConfigFiles(c.ConfigFiles))
+	}
+	if err := checkFilesExist("credentialFiles", c.CredentialFiles); err != nil {
+		return aws.Config{}, err
+	}
+	if len(c.CredentialFiles) > 0 {
+		opts = append(opts, config.WithSharedCredentialsFiles(c.CredentialFiles))
+	}
 
-	if c.Region != "" {
-		opts = append(opts, config.WithRegion(c.Region))
+	if c.MaxRetries != nil {
+		if *c.MaxRetries < 0 {
+			return aws.Config{}, inputErrorf("maxRetries must not be negative")
+		}
+		opts = append(opts, config.WithRetryMaxAttempts(*c.MaxRetries+1))
 	}
 
-	if c.Profile != "" {
-		opts = append(opts, config.WithSharedConfigProfile(c.Profile))
+	if c.RetryMode != "" {
+		mode, err := aws.ParseRetryMode(c.RetryMode)
+		if err != nil {
+			return aws.Config{}, inputErrorf("invalid retryMode: %w", err)
+		}
+		opts = append(opts, config.WithRetryMode(mode))
 	}
 
-	if c.Debug {
+	if level, _ := c.logLevel(); level == internal.LevelDebug {
 		opts = append(opts, config.WithClientLogMode(aws.LogSigning|aws.LogRequestWithBody|aws.LogResponseWithBody))
 	}
 
-	if c.DoNotVerifySSL {
-		opts = append(opts, config.WithHTTPClient(&http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			},
-		}))
+	httpClient, err := c.httpClient()
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if httpClient != nil {
+		opts = append(opts, config.WithHTTPClient(httpClient))
 	}
 
-	if c.CABundle != "" {
+	switch {
+	case c.CABundle != "" && c.CABundlePEM != "":
+		return aws.Config{}, inputErrorf("caBundle and caBundlePem are mutually exclusive, specify only one of them")
+	case c.CABundle != "":
 		b, err := os.ReadFile(c.CABundle)
 		if err != nil {
 			return aws.Config{}, fmt.Errorf("read CA bundle file: %w", err)
 		}
 		opts = append(opts, config.WithCustomCABundle(bytes.NewReader(b)))
+	case c.CABundlePEM != "":
+		opts = append(opts, config.WithCustomCABundle(strings.NewReader(c.CABundlePEM)))
+	}
+
+	if c.RoleARN != "" {
+		if err := validateRoleARN(c.RoleARN); err != nil {
+			return aws.Config{}, err
+		}
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Retryer = retryer(cfg.RetryMode)
+	if c.logger.Enabled(internal.LevelDebug) {
+		c.logger.Debugf("region %q is from %s, profile %q is from %s",
+			cfg.Region, regionSource, orDefault(profile), profileSource)
+		c.logger.Debugf("effective config: %s", c.effective(cfg))
 	}
+	c.events.Emit(internal.Event{Type: internal.EventConfigResolved, Region: cfg.Region, Profile: profile})
+	if hint := c.ssoLoginHint(cfg, profile); hint != "" && cfg.Credentials != nil {
+		cfg.Credentials = &ssoCredentialsProvider{CredentialsProvider: cfg.Credentials, loginHint: hint}
+	}
+	if c.RoleARN == "" {
+		return cfg, nil
+	}
+	return c.assumeRole(ctx, cfg, sts.NewFromConfig(cfg))
+}
 
-	return config.LoadDefaultConfig(ctx, opts...)
+// retryer returns the retryer of AWS API clients for mode, which also
+// waits as long as Retry-After headers of throttled responses ask.
+// The clients still apply maxRetries to it.
+func retryer(mode aws.RetryMode) func() aws.Retryer {
+	return func() aws.Retryer {
+		if mode == aws.RetryModeAdaptive {
+			return cs.WithRetryAfter(retry.NewAdaptiveMode())
+		}
+		return cs.WithRetryAfter(retry.NewStandard())
+	}
 }
 
-func parseInput(r io.Reader) (*Input, error) {
-	in := new(Input)
-	if err := json.NewDecoder(r).Decode(in); err != nil {
-		return nil, fmt.Errorf("unable to unmarshal JSON input: %v", err)
+// userAgentExtraRE matches a name, optionally followed by a slash
+// and a version, both of them HTTP tokens, which is what AWS SDK
+// puts in the user agent without replacing any characters.
+var userAgentExtraRE = regexp.MustCompile("^([-!#$%&'*+.^_`|~0-9A-Za-z]+)(?:/([-!#$%&'*+.^_`|~0-9A-Za-z]+))?$")
+
+// userAgentExtra returns the API option that adds s
+// to the user agent, see OperationConfig.UserAgentExtra.
+func userAgentExtra(s string) (func(*smithyMW.Stack) error, error) {
+	m := userAgentExtraRE.FindStringSubmatch(s)
+	switch {
+	case m == nil:
+		return nil, inputErrorf("invalid userAgentExtra %q: it must be a name, optionally followed by a slash and "+
+			"a version, e.g. \"my-ci/1.2\", with letters, digits and any of !#$%%&'*+-.^_`|~ in them", s)
+	case m[2] == "":
+		return middleware.AddUserAgentKey(m[1]), nil
 	}
-	if ver, err := strconv.Atoi(in.InputVersion); err != nil || ver < 0 {
-		return nil, fmt.Errorf("invalid inputVersion: it must contain a non-negative number")
+	return middleware.AddUserAgentKeyValue(m[1], m[2]), nil
+}
+
+// checkFilesExist returns an input error naming the setting
+// if any of files doesn't exist.
+func checkFilesExist(setting string, files []string) error {
+	for _, name := range files {
+		if _, err := os.Stat(name); err != nil {
+			return inputErrorf("invalid %s: %w", setting, err)
+		}
 	}
-	return in, nil
+	return nil
 }
 
-func invokeOperation(ctx context.Context, in *Input, debugLog *log.Logger) error {
-	switch in.Operation {
-	case "PushContainerImage":
-		cfg, err := in.Configuration.awsConfig(ctx)
-		if err != nil {
-			return err
+// effective describes the configuration that is in effect with cfg,
+// taking defaults and environment variables into account.
+// It never includes credentials.
+func (c *OperationConfig) effective(cfg aws.Config) string {
+	profile, _ := settingSource(c.Profile, "AWS_PROFILE", "AWS_DEFAULT_PROFILE")
+	caBundle := c.CABundle
+	if c.CABundlePEM != "" {
+		caBundle = "inline PEM"
+	}
+	return fmt.Sprintf("region=%s profile=%s endpoint=%s doNotVerifySSL=%t caBundle=%s dockerHost=%s",
+		orDefault(cfg.Region),
+		orDefault(profile),
+		orDefault(c.Endpoint),
+		c.DoNotVerifySSL,
+		orDefault(caBundle, os.Getenv("AWS_CA_BUNDLE")),
+		orDefault(c.DockerHost, os.Getenv("DOCKER_HOST")))
+}
+
+// settingSource returns the value of a setting and where it's from:
+// payload when it's set there, otherwise the first of envVars
+// that is set, otherwise nothing, leaving it to the shared config files.
+func settingSource(payload string, envVars ...string) (value, source string) {
+	if payload != "" {
+		return payload, "payload"
+	}
+	for _, name := range envVars {
+		if v := os.Getenv(name); v != "" {
+			return v, name
+		}
+	}
+	return "", "shared config"
+}
+
+// orDefault returns the first of vals that is not empty, or "default".
+func orDefault(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
 		}
+	}
+	return "default"
+}
 
-		ls := lightsail.NewFromConfig(cfg, func(o *lightsail.Options) {
-			if ep := strings.TrimRight(in.Configuration.Endpoint, "/"); ep != "" {
-				o.BaseEndpoint = &ep
+// httpClient returns the HTTP client for AWS API calls,
+// or nil when the SDK's default one will do.
+// Like the default one, it takes the proxy from HTTP(S)_PROXY
+// environment variables, unless c.ProxyURL is specified.
+func (c *OperationConfig) httpClient() (*awshttp.BuildableClient, error) {
+	if !c.DoNotVerifySSL && c.ProxyURL == "" {
+		return nil, nil
+	}
+	proxy, err := c.proxy()
+	if err != nil {
+		return nil, err
+	}
+	return awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		if c.DoNotVerifySSL {
+			if tr.TLSClientConfig == nil {
+				tr.TLSClientConfig = &tls.Config{}
 			}
-		})
+			tr.TLSClientConfig.InsecureSkipVerify = true
+		}
+		if proxy != nil {
+			tr.Proxy = http.ProxyURL(proxy)
+		}
+	}), nil
+}
+
+// proxy returns c.ProxyURL parsed, or nil if it's not specified.
+func (c *OperationConfig) proxy() (*url.URL, error) {
+	if c.ProxyURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(c.ProxyURL)
+	if err != nil {
+		return nil, inputErrorf("invalid proxyUrl: %w", err)
+	}
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5":
+		return nil, inputErrorf("invalid proxyUrl %q: its scheme must be http, https or socks5", c.ProxyURL)
+	case u.Host == "":
+		return nil, inputErrorf("invalid proxyUrl %q: host is not specified", c.ProxyURL)
+	}
+	return u, nil
+}
 
-		internal.CheckForUpdates(ctx, debugLog, ls, internal.Version)
+// assumeRole returns a copy of cfg whose credentials are those of c.RoleARN.
+// The role is assumed right away, so that a failure is reported
+// before anything else is done.
+func (c *OperationConfig) assumeRole(
+	ctx context.Context,
+	cfg aws.Config,
+	client stscreds.AssumeRoleAPIClient,
+) (aws.Config, error) {
+	provider := stscreds.NewAssumeRoleProvider(client, c.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = "lightsailctl"
+		if c.RoleSessionName != "" {
+			o.RoleSessionName = c.RoleSessionName
+		}
+		if c.ExternalID != "" {
+			o.ExternalID = aws.String(c.ExternalID)
+		}
+	})
 
-		r, err := parsePushContainerImagePayload(in.Payload)
-		if err != nil {
-			return fmt.Errorf("unable to parse the input's payload field: %w", err)
+	cfg = cfg.Copy()
+	cfg.Credentials = aws.NewCredentialsCache(provider)
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		return aws.Config{}, fmt.Errorf("could not assume role %s: %w", c.RoleARN, err)
+	}
+	return cfg, nil
+}
+
+func validateRoleARN(s string) error {
+	a, err := arn.Parse(s)
+	if err != nil {
+		return inputErrorf("invalid role ARN %q: %w", s, err)
+	}
+	if a.Service != "iam" || !strings.HasPrefix(a.Resource, "role/") {
+		return inputErrorf("invalid role ARN %q: it must look like arn:aws:iam::123456789012:role/name", s)
+	}
+	return nil
+}
+
+func (c *OperationConfig) newLightsailClient(ctx context.Context) (*lightsail.Client, error) {
+	cfg, err := c.awsConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.lightsailClient(cfg)
+}
+
+func (c *OperationConfig) lightsailClient(cfg aws.Config) (*lightsail.Client, error) {
+	ep, err := c.baseEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	registry, err := c.registryEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	return lightsail.NewFromConfig(cfg, func(o *lightsail.Options) {
+		if ep != "" {
+			o.BaseEndpoint = &ep
 		}
+		if registry != "" {
+			o.APIOptions = append(o.APIOptions, overrideRegistry(registry))
+		}
+	}), nil
+}
 
-		dc, err := cs.NewDockerEngine(ctx)
+// loginCacheFile returns the file in cs.LoginCacheDir where registry logins
+// are cached for the credentials, region and endpoints of cfg and c.
+// The name is a hash, so that it tells nothing about the account.
+// Assumed roles are identified by their ARN, since each session
+// has different credentials.
+func (c *OperationConfig) loginCacheFile(ctx context.Context, cfg aws.Config) (string, error) {
+	dir, err := cs.LoginCacheDir()
+	if err != nil {
+		return "", err
+	}
+	principal := c.RoleARN
+	if principal == "" {
+		creds, err := cfg.Credentials.Retrieve(ctx)
 		if err != nil {
-			return err
+			return "", err
 		}
+		principal = creds.AccessKeyID
+	}
+	h := sha256.New()
+	for _, s := range []string{principal, cfg.Region, c.Endpoint, c.RegistryEndpointOverride} {
+		fmt.Fprintf(h, "%q\n", s)
+	}
+	return filepath.Join(dir, hex.EncodeToString(h.Sum(nil))+".json"), nil
+}
+
+// registryEndpoint returns c.RegistryEndpointOverride without trailing
+// slashes, or an error if it can't be a part of an image reference.
+func (c *OperationConfig) registryEndpoint() (string, error) {
+	ep := strings.TrimRight(c.RegistryEndpointOverride, "/")
+	if ep == "" {
+		return "", nil
+	}
+	if _, err := reference.ParseNamed(ep + "/" + cs.DefaultRegistryRepo); err != nil || strings.Contains(ep, "://") {
+		return "", inputErrorf("invalid registryEndpointOverride %q: it must look like localhost:5000 or example.com/path", c.RegistryEndpointOverride)
+	}
+	return ep, nil
+}
+
+// overrideRegistry replaces the registry address in the responses
+// of CreateContainerServiceRegistryLogin with registry.
+func overrideRegistry(registry string) func(*smithyMW.Stack) error {
+	return func(stack *smithyMW.Stack) error {
+		return stack.Initialize.Add(smithyMW.InitializeMiddlewareFunc(
+			"OverrideRegistry",
+			func(
+				ctx context.Context,
+				in smithyMW.InitializeInput,
+				next smithyMW.InitializeHandler,
+			) (smithyMW.InitializeOutput, smithyMW.Metadata, error) {
+				out, md, err := next.HandleInitialize(ctx, in)
+				if res, ok := out.Result.(*lightsail.CreateContainerServiceRegistryLoginOutput); ok && res.RegistryLogin != nil {
+					res.RegistryLogin.Registry = &registry
+				}
+				return out, md, err
+			},
+		), smithyMW.Before)
+	}
+}
+
+// baseEndpoint returns the endpoint without trailing slashes,
+// or an error if it is not an absolute URL.
+func (c *OperationConfig) baseEndpoint() (string, error) {
+	ep := strings.TrimRight(c.Endpoint, "/")
+	if ep == "" {
+		return "", nil
+	}
+	if u, err := url.Parse(ep); err != nil || u.Scheme == "" || u.Host == "" {
+		return "", inputErrorf("invalid endpoint %q: it must be an absolute URL", c.Endpoint)
+	}
+	return ep, nil
+}
+
+// imageEngine returns a client of the local container engine selected in c,
+// which shows push progress on progress, or on os.Stderr when it's nil.
+func (c *OperationConfig) imageEngine(
+	ctx context.Context,
+	logger *internal.Logger,
+	progress io.Writer,
+	progressLog io.Writer,
+) (*cs.DockerEngine, error) {
+	proxy, err := c.proxy()
+	if err != nil {
+		return nil, err
+	}
+	cfg := cs.DockerEngineConfig{
+		Host:        c.DockerHost,
+		APIVersion:  c.DockerAPIVersion,
+		Progress:    progress,
+		ProgressLog: progressLog,
+		Proxy:       proxy,
+		Logger:      logger,
+		Events:      c.events,
+		Compression: c.Compression,
+
+		MaxConcurrentUploads: c.MaxConcurrentUploads,
 
-		if err := cs.PushImage(ctx, r, ls, dc); err != nil {
-			return err
+		ResponseHeaderTimeout: time.Duration(c.DockerResponseHeaderTimeoutSeconds) * time.Second,
+		Timeout:               time.Duration(c.DockerTimeoutSeconds) * time.Second,
+	}
+	if c.MaxConcurrentUploads < 0 {
+		return nil, inputErrorf("maxConcurrentUploads %d is invalid: it must be a positive integer", c.MaxConcurrentUploads)
+	}
+	if c.DockerResponseHeaderTimeoutSeconds < 0 {
+		return nil, inputErrorf("dockerResponseHeaderTimeoutSeconds %d is invalid: it must not be negative", c.DockerResponseHeaderTimeoutSeconds)
+	}
+	if c.DockerTimeoutSeconds < 0 {
+		return nil, inputErrorf("dockerTimeoutSeconds %d is invalid: it must not be negative", c.DockerTimeoutSeconds)
+	}
+	switch c.Compression {
+	case "", "gzip", "zstd":
+	default:
+		return nil, inputErrorf("unsupported compression %q: it must be either \"gzip\" or \"zstd\"", c.Compression)
+	}
+	switch c.ProgressFormat {
+	case "", "text":
+	case "json":
+		cfg.ProgressEvents = progress
+		if progressLog != nil {
+			cfg.ProgressEvents = io.MultiWriter(progress, progressLog)
 		}
 	default:
-		return fmt.Errorf("unknown plugin operation: %q", in.Operation)
+		return nil, inputErrorf("unsupported progress format %q: it must be either \"text\" or \"json\"", c.ProgressFormat)
+	}
+	switch c.Engine {
+	case "", "docker":
+		return cs.NewDockerEngine(ctx, cfg)
+	case "podman":
+		return cs.NewPodmanEngine(ctx, cfg)
+	default:
+		return nil, inputErrorf("unsupported engine %q: it must be either \"docker\" or \"podman\"", c.Engine)
 	}
-	return nil
 }
 
-func parsePushContainerImagePayload(data json.RawMessage) (*cs.PushImageInput, error) {
-	p := struct {
-		Service string `json:"service"`
-		Image   string `json:"image"`
-		Label   string `json:"label"`
-	}{}
-	if err := json.Unmarshal(data, &p); err != nil {
-		return nil, err
+// The range of inputVersion values this build understands.
+const (
+	minInputVersion = 0
+	maxInputVersion = 2
+)
+
+func parseInput(r io.Reader) (*Input, error) {
+	in := new(Input)
+	if err := json.NewDecoder(r).Decode(in); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal JSON input: %v", err)
+	}
+	ver, err := strconv.Atoi(in.InputVersion)
+	if err != nil || ver < minInputVersion {
+		return nil, fmt.Errorf("invalid inputVersion: it must contain a non-negative number")
+	}
+	if ver > maxInputVersion {
+		return nil, fmt.Errorf("unsupported inputVersion %d, this build supports up to %d; "+
+			"upgrade lightsailctl to use it", ver, maxInputVersion)
 	}
+	return in, nil
+}
 
-	for _, check := range []struct{ what, input string }{
-		{"service name", p.Service},
-		{"container image", p.Image},
-		{"container label", p.Label},
-	} {
-		if len(check.input) != 0 {
-			continue
+// version returns InputVersion as a number, which selects
+// how the payload is checked, see payloadValidators.
+// Inputs that parseInput didn't check count as version 0.
+func (in *Input) version() int {
+	ver, err := strconv.Atoi(in.InputVersion)
+	if err != nil {
+		return 0
+	}
+	return ver
+}
+
+// handler carries out a plugin operation and returns its result,
+// which is rendered once it's done, or nil if it has none apart from
+// the output that it writes as it goes.
+type handler interface {
+	Handle(ctx context.Context, payload json.RawMessage, cfg *OperationConfig, deps *operationDeps) (any, error)
+}
+
+// handlerFunc lets plain functions that have no result be handlers.
+type handlerFunc func(ctx context.Context, payload json.RawMessage, cfg *OperationConfig, deps *operationDeps) error
+
+func (f handlerFunc) Handle(ctx context.Context, payload json.RawMessage, cfg *OperationConfig, deps *operationDeps) (any, error) {
+	return nil, f(ctx, payload, cfg, deps)
+}
+
+// operationDeps are what handlers need besides their input.
+type operationDeps struct {
+	// logger is for warnings and extra diagnostics, up to the configured level.
+	logger *internal.Logger
+	// stdout receives the output of the operation, and stderr
+	// the progress of the pushes, if any.
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// operation is a plugin operation.
+type operation struct {
+	handler
+	// payloads are the structs that the operation parses its payload
+	// into, whose json tags name the payload fields it accepts, and
+	// whose fields tagged `payload:"required"` must be specified.
+	payloads []any
+	// requiredNote tells which fields are required in combination,
+	// which only the operation can check, for people to read.
+	requiredNote string
+
+	// required lists the payload fields that must be specified,
+	// followed by requiredNote, and fields lists all the payload fields
+	// that the operation accepts. Both are derived from payloads.
+	required []string
+	fields   []string
+}
+
+// operations is the registry of plugin operations by name.
+var operations = map[string]operation{
+	"PushContainerImage": {
+		handler:      pushContainerImageHandler{},
+		payloads:     []any{pushContainerImagePayload{}, createDeploymentPayload{}},
+		requiredNote: "image and label or labels, or images, or digest and label or labels with registerOnly",
+	},
+	"GetContainerAPIMetadata": {handler: handlerFunc(getContainerAPIMetadata)},
+	"GetRegistryHost":         {handler: handlerFunc(getRegistryHost), payloads: []any{getRegistryHostPayload{}}},
+	"DeleteContainerImage": {
+		handler:  handlerFunc(deleteContainerImage),
+		payloads: []any{deleteContainerImagePayload{}},
+	},
+	"GetContainerImages": {
+		handler:  handlerFunc(getContainerImages),
+		payloads: []any{getContainerImagesPayload{}},
+	},
+	"GetContainerServices": {
+		handler:  handlerFunc(getContainerServices),
+		payloads: []any{getContainerServicesPayload{}},
+	},
+	"GetContainerLog": {
+		handler:  handlerFunc(getContainerLog),
+		payloads: []any{getContainerLogPayload{}},
+	},
+	"GetContainerServiceDeployments": {
+		handler:  handlerFunc(getContainerServiceDeployments),
+		payloads: []any{getContainerServiceDeploymentsPayload{}},
+	},
+	"CreateContainerService": {
+		handler:  handlerFunc(createContainerService),
+		payloads: []any{createContainerServicePayload{}},
+	},
+	"UpdateDeploymentImage": {
+		handler:  handlerFunc(updateDeploymentImage),
+		payloads: []any{updateDeploymentImagePayload{}},
+	},
+	"Diagnose": {handler: handlerFunc(diagnose)},
+}
+
+func init() {
+	// These list the operations, so they can't be in the map literal
+	// without an initialization cycle.
+	operations["SchemaInfo"] = operation{handler: handlerFunc(schemaInfo)}
+	operations["ListOperations"] = operation{handler: handlerFunc(listOperations)}
+
+	for name, op := range operations {
+		op.fields, op.required = payloadFields(op.payloads...)
+		if op.requiredNote != "" {
+			op.required = append(op.required, op.requiredNote)
 		}
-		return nil, fmt.Errorf("push container image: %s is not specified", check.what)
+		operations[name] = op
+	}
+}
+
+// invokeOperation carries out the operation of in and returns its result,
+// for writeResult to render. The events are set up first, so that even
+// an unknown operation gets its done event.
+func invokeOperation(ctx context.Context, in *Input, logger *internal.Logger) (any, error) {
+	cfg := &in.Configuration
+	cfg.logger = logger
+	deps := &operationDeps{logger: logger, stdout: os.Stdout, stderr: os.Stderr}
+
+	switch cfg.EventsFormat {
+	case "":
+	case "ndjson":
+		// The events take stdout, so the messages for users go to stderr.
+		cfg.events = internal.NewEvents(os.Stdout)
+		deps.stdout = os.Stderr
+	default:
+		return nil, inputErrorf("unsupported events format %q: it must be \"ndjson\"", cfg.EventsFormat)
+	}
+
+	op, ok := operations[in.Operation]
+	if !ok {
+		return nil, inputErrorf("unknown plugin operation: %q", in.Operation)
+	}
+
+	ver := in.version()
+	validate, ok := payloadValidators[ver]
+	if !ok {
+		return nil, inputErrorf("unsupported inputVersion %d", ver)
+	}
+	if err := validate(op, in.Payload); err != nil {
+		return nil, inputErrorf("invalid payload of inputVersion %d: %w", ver, err)
+	}
+	return op.Handle(ctx, in.Payload, cfg, deps)
+}
+
+// writeResult renders the result of the operation, or the error
+// that it failed with, as the done event. Only PushContainerImage has
+// a result, and only events render it: in text output, operations tell
+// everything that's in their results as they go.
+func (c *OperationConfig) writeResult(operation string, res any, err error) {
+	done := internal.Event{Type: internal.EventDone, Operation: operation, Result: res}
+	if err != nil {
+		done.Error = err.Error()
+	}
+	c.events.Emit(done)
+}
+
+// operationNames returns the names of supported operations in order.
+func operationNames() []string {
+	names := make([]string, 0, len(operations))
+	for name := range operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getContainerAPIMetadata(ctx context.Context, _ json.RawMessage, cfg *OperationConfig, deps *operationDeps) error {
+	ls, err := cfg.newLightsailClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	return internal.WriteContainerAPIMetadata(ctx, deps.stdout, ls)
+}
+
+func getRegistryHost(ctx context.Context, payload json.RawMessage, cfg *OperationConfig, deps *operationDeps) error {
+	asJSON, err := parseGetRegistryHostPayload(payload)
+	if err != nil {
+		return inputErrorf("unable to parse the input's payload field: %w", err)
+	}
+
+	ls, err := cfg.newLightsailClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	return cs.WriteRegistryHost(ctx, deps.stdout, ls, asJSON)
+}
+
+func deleteContainerImage(ctx context.Context, payload json.RawMessage, cfg *OperationConfig, deps *operationDeps) error {
+	r, err := parseDeleteContainerImagePayload(payload)
+	if err != nil {
+		return inputErrorf("unable to parse the input's payload field: %w", err)
+	}
+
+	ls, err := cfg.newLightsailClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	if cfg.DryRun {
+		fmt.Fprintf(deps.stdout, "Dry run: image %q would be deleted from service %q.\n", r.Image, r.Service)
+		return nil
+	}
+	r.Output = deps.stdout
+	return cs.DeleteImage(ctx, r, ls)
+}
+
+func getContainerImages(ctx context.Context, payload json.RawMessage, cfg *OperationConfig, deps *operationDeps) error {
+	r, err := parseGetContainerImagesPayload(payload)
+	if err != nil {
+		return inputErrorf("unable to parse the input's payload field: %w", err)
+	}
+
+	ls, err := cfg.newLightsailClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.Output = deps.stdout
+	return cs.ListImages(ctx, r, ls)
+}
+
+func getContainerServices(ctx context.Context, payload json.RawMessage, cfg *OperationConfig, deps *operationDeps) error {
+	r, err := parseGetContainerServicesPayload(payload)
+	if err != nil {
+		return inputErrorf("unable to parse the input's payload field: %w", err)
+	}
+
+	ls, err := cfg.newLightsailClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.Output = deps.stdout
+	return cs.ListServices(ctx, r, ls)
+}
+
+func getContainerServiceDeployments(ctx context.Context, payload json.RawMessage, cfg *OperationConfig, deps *operationDeps) error {
+	r, err := parseGetContainerServiceDeploymentsPayload(payload)
+	if err != nil {
+		return inputErrorf("unable to parse the input's payload field: %w", err)
 	}
 
-	return &cs.PushImageInput{Service: p.Service, Image: p.Image, Label: p.Label}, nil
+	ls, err := cfg.newLightsailClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.Output = deps.stdout
+	return cs.ListDeployments(ctx, r, ls)
+}
+
+func createContainerService(ctx context.Context, payload json.RawMessage, cfg *OperationConfig, deps *operationDeps) error {
+	r, err := parseCreateContainerServicePayload(payload)
+	if err != nil {
+		return inputErrorf("unable to parse the input's payload field: %w", err)
+	}
+
+	ls, err := cfg.newLightsailClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	if cfg.DryRun {
+		fmt.Fprintf(deps.stdout, "Dry run: service %q would be created with power %q and scale %d.\n", r.Service, r.Power, r.Scale)
+		return nil
+	}
+	r.Output = deps.stdout
+	return cs.CreateService(ctx, deps.logger, r, ls)
+}
+
+func updateDeploymentImage(ctx context.Context, payload json.RawMessage, cfg *OperationConfig, deps *operationDeps) error {
+	r, err := parseUpdateDeploymentImagePayload(payload)
+	if err != nil {
+		return inputErrorf("unable to parse the input's payload field: %w", err)
+	}
+
+	ls, err := cfg.newLightsailClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.DryRun = cfg.DryRun
+	r.Output = deps.stdout
+	return cs.UpdateDeploymentImage(ctx, deps.logger, r, ls)
+}
+
+func getContainerLog(ctx context.Context, payload json.RawMessage, cfg *OperationConfig, deps *operationDeps) error {
+	r, err := parseGetContainerLogPayload(payload)
+	if err != nil {
+		return inputErrorf("unable to parse the input's payload field: %w", err)
+	}
+
+	ls, err := cfg.newLightsailClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.Output = deps.stdout
+	return cs.GetLog(ctx, r, ls)
+}
+
+func schemaInfo(_ context.Context, _ json.RawMessage, _ *OperationConfig, deps *operationDeps) error {
+	return writeSchemaInfo(deps.stdout)
+}
+
+// writeSchemaInfo writes the range of supported input versions and
+// the names of supported operations as JSON, for integrators who
+// need to know what this build is compatible with.
+func writeSchemaInfo(w io.Writer) error {
+	info := struct {
+		MinInputVersion int      `json:"minInputVersion"`
+		MaxInputVersion int      `json:"maxInputVersion"`
+		Operations      []string `json:"operations"`
+	}{
+		MinInputVersion: minInputVersion,
+		MaxInputVersion: maxInputVersion,
+	}
+	info.Operations = operationNames()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(info)
+}
+
+func listOperations(_ context.Context, _ json.RawMessage, _ *OperationConfig, deps *operationDeps) error {
+	return writeOperations(deps.stdout)
+}
+
+// writeOperations writes a table of supported operations
+// and their required payload fields.
+func writeOperations(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPERATION\tREQUIRED PAYLOAD FIELDS")
+	for _, name := range operationNames() {
+		required := "-"
+		if r := operations[name].required; len(r) > 0 {
+			required = strings.Join(r, "; ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", name, required)
+	}
+	return tw.Flush()
+}
+
+// pushContainerImageHandler pushes and registers one or more images.
+type pushContainerImageHandler struct{}
+
+// pushContainerImageResult is the result of PushContainerImage.
+type pushContainerImageResult struct {
+	// Images are the results of the images in the order of the payload.
+	// With continueOnError, the images that failed are left out.
+	Images []*cs.PushImageResult `json:"images"`
+}
+
+func (h pushContainerImageHandler) Handle(
+	ctx context.Context,
+	payload json.RawMessage,
+	c *OperationConfig,
+	deps *operationDeps,
+) (any, error) {
+	results, err := h.push(ctx, payload, c, deps)
+	if results == nil || c.DryRun {
+		// Dry runs register nothing, so they have no result.
+		return nil, err
+	}
+	return pushContainerImageResult{Images: results}, err
+}
+
+func (pushContainerImageHandler) push(
+	ctx context.Context,
+	payload json.RawMessage,
+	c *OperationConfig,
+	deps *operationDeps,
+) ([]*cs.PushImageResult, error) {
+	logger := deps.logger
+
+	cfg, err := c.awsConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ls, err := c.lightsailClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadURL := c.UpdateDownloadURL
+	if downloadURL == "" {
+		downloadURL = internal.DownloadURL(cfg.Region)
+	}
+	internal.CheckForUpdates(ctx, logger, ls, internal.Version, downloadURL)
+
+	r, err := parsePushContainerImagePayload(payload)
+	if err != nil {
+		return nil, inputErrorf("unable to parse the input's payload field: %w", err)
+	}
+	deploy, err := parseCreateDeploymentPayload(payload)
+	if err != nil {
+		return nil, inputErrorf("unable to parse the input's payload field: %w", err)
+	}
+	accounts := cs.NewAccountResolver(sts.NewFromConfig(cfg), logger)
+	for _, img := range r.Images {
+		img.Output = deps.stdout
+		img.Region = cfg.Region
+		img.AccountResolver = accounts
+		img.Events = c.events
+		img.DryRun = c.DryRun
+	}
+
+	if r.Images[0].RegisterOnly {
+		res, err := cs.PushImage(ctx, logger, r.Images[0], ls, nil)
+		if err != nil {
+			return nil, err
+		}
+		results := []*cs.PushImageResult{res}
+		return results, c.deploy(ctx, deps, deploy, results, ls)
+	}
+
+	var progressLog io.Writer
+	if name := c.ProgressLogFile; name != "" {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("could not open progress log file: %w", err)
+		}
+		defer f.Close()
+		progressLog = f
+	}
+
+	dc, err := c.imageEngine(ctx, logger, deps.stderr, progressLog)
+	if err != nil {
+		return nil, err
+	}
+
+	var lio cs.LightsailImageOperator = ls
+	if c.CacheRegistryLogin {
+		file, err := c.loginCacheFile(ctx, cfg)
+		if err != nil {
+			logger.Debugf("not caching registry logins: %v", err)
+		} else {
+			lio = cs.WithLoginCache(ls, file, logger)
+		}
+	}
+
+	start := time.Now()
+	var results []*cs.PushImageResult
+	if len(r.Images) == 1 {
+		var res *cs.PushImageResult
+		res, err = cs.PushImage(ctx, logger, r.Images[0], lio, dc)
+		results = []*cs.PushImageResult{res}
+	} else {
+		results, err = cs.PushImages(ctx, logger, r, lio, dc)
+	}
+
+	if ns := c.MetricsNamespace; ns != "" && !c.DryRun {
+		m := cs.PushMetrics{Service: r.Images[0].Service, Duration: time.Since(start), Succeeded: err == nil}
+		m.ImageSize = imagesSize(ctx, logger, dc, results)
+		cs.PutPushMetrics(ctx, logger, cloudwatch.NewFromConfig(cfg), ns, m)
+	}
+
+	// The images that did make it are still worth reporting along with
+	// the ones that failed, but nothing is deployed.
+	var partial *cs.PushImagesError
+	if errors.As(err, &partial) {
+		return results, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, c.deploy(ctx, deps, deploy, results, ls)
+}
+
+// imagesSize returns the total size of the local images of results,
+// which name them the way they were pushed, after normalizing or loading
+// them, or zero if it can't be told.
+func imagesSize(
+	ctx context.Context,
+	logger *internal.Logger,
+	dc interface {
+		ImageSize(ctx context.Context, image string) (int64, error)
+	},
+	results []*cs.PushImageResult,
+) int64 {
+	// The push may have failed because ctx is done, but its metrics still matter.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	var total int64
+	for _, res := range results {
+		if res == nil {
+			continue
+		}
+		size, err := dc.ImageSize(ctx, res.Image)
+		if err != nil {
+			logger.Debugf("could not get image size for push metrics: %v", err)
+			return 0
+		}
+		total += size
+	}
+	return total
+}
+
+// deploy creates the deployment d, if any, of the images that were
+// pushed and waits for it to become active.
+func (c *OperationConfig) deploy(
+	ctx context.Context,
+	deps *operationDeps,
+	d *cs.DeployInput,
+	results []*cs.PushImageResult,
+	ls *lightsail.Client,
+) error {
+	if d == nil {
+		return nil
+	}
+	d.Output = deps.stdout
+	if c.DryRun {
+		fmt.Fprintf(deps.stdout, "Dry run: a deployment of %d containers would be created for service %q.\n", len(d.Containers), d.Service)
+		return nil
+	}
+	d.UseReferences(results)
+	return cs.Deploy(ctx, deps.logger, d, ls)
+}
+
+// imageLabel is an image of PushContainerImage payload
+// and the labels to register it under.
+type imageLabel struct {
+	Image string `json:"image"`
+	Label string `json:"label"`
+	// Labels may replace Label to register the image under several.
+	Labels     []string `json:"labels"`
+	SourceType string   `json:"sourceType"`
+	SourcePath string   `json:"sourcePath"`
+}
+
+type pushContainerImagePayload struct {
+	Service string `json:"service" payload:"required"`
+	imageLabel
+	Images []imageLabel `json:"images"`
+
+	RegisterGracePeriodSeconds int    `json:"registerGracePeriodSeconds"`
+	PushAttempts               int    `json:"pushAttempts"`
+	PushAttemptTimeoutSeconds  int    `json:"pushAttemptTimeoutSeconds"`
+	PushTimeoutSeconds         int    `json:"pushTimeoutSeconds"`
+	IfNotPresent               bool   `json:"ifNotPresent"`
+	RequireExposedPorts        bool   `json:"requireExposedPorts"`
+	WarnIncompatible           bool   `json:"warnIncompatible"`
+	KeepLocalTag               bool   `json:"keepLocalTag"`
+	TagPrefix                  string `json:"tagPrefix"`
+	Platform                   string `json:"platform"`
+	Atomic                     bool   `json:"atomic"`
+	ContinueOnError            bool   `json:"continueOnError"`
+	RegisterOnly               bool   `json:"registerOnly"`
+	Digest                     string `json:"digest"`
+	VerifySignature            string `json:"verifySignature"`
+	SignatureKey               string `json:"signatureKey"`
+}
+
+// parsePushContainerImagePayload accepts either a single image
+// with "image" and "label" fields, or several images to push to
+// the same service in "images" field.
+func parsePushContainerImagePayload(data json.RawMessage) (*cs.PushImagesInput, error) {
+	var p pushContainerImagePayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	if len(p.Service) == 0 {
+		return nil, errors.New("push container image: service name is not specified")
+	}
+	if err := checkServiceName(p.Service); err != nil {
+		return nil, fmt.Errorf("push container image: %w", err)
+	}
+
+	var verifier cs.SignatureVerifier
+	switch p.VerifySignature {
+	case "":
+		if p.SignatureKey != "" {
+			return nil, errors.New("push container image: signature key is only used with cosign signature verification")
+		}
+	case "cosign":
+		if p.SignatureKey == "" {
+			return nil, errors.New("push container image: signature key is not specified for cosign signature verification")
+		}
+		verifier = cs.NewCosignVerifier(p.SignatureKey)
+	case "notation":
+		if p.SignatureKey != "" {
+			return nil, errors.New("push container image: signature key is only used with cosign signature verification")
+		}
+		verifier = cs.NewNotationVerifier()
+	default:
+		return nil, fmt.Errorf("push container image: signature verification %q is invalid: it must be \"cosign\" or \"notation\"",
+			p.VerifySignature)
+	}
+
+	if p.RegisterOnly {
+		switch {
+		case verifier != nil:
+			return nil, errors.New("push container image: registerOnly pushes no local image, so it can't verify a signature")
+		case len(p.Images) != 0 || p.Image != "":
+			return nil, errors.New("push container image: registerOnly takes a digest and a label instead of images")
+		case p.Digest == "":
+			return nil, errors.New("push container image: digest is not specified")
+		}
+		label, extraLabels, err := splitLabels("", p.Label, p.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("push container image: %w", err)
+		}
+		return &cs.PushImagesInput{Images: []*cs.PushImageInput{{
+			Service:             p.Service,
+			Label:               label,
+			ExtraLabels:         extraLabels,
+			RegisterOnly:        true,
+			Digest:              p.Digest,
+			RegisterGracePeriod: time.Duration(p.RegisterGracePeriodSeconds) * time.Second,
+		}}}, nil
+	}
+	if p.Digest != "" {
+		return nil, errors.New("push container image: digest is only used with registerOnly")
+	}
+
+	images := p.Images
+	switch {
+	case len(images) == 0:
+		images = []imageLabel{p.imageLabel}
+	case p.Image != "" || p.Label != "" || len(p.Labels) != 0 || p.SourceType != "" || p.SourcePath != "":
+		return nil, errors.New("push container image: either image and label, or images must be specified, but not both")
+	}
+
+	extraLabels := make([][]string, len(images))
+	for i, img := range images {
+		source := struct{ what, input string }{"container image", img.Image}
+		switch img.SourceType {
+		case "", cs.SourceDaemon:
+			if img.SourcePath != "" {
+				return nil, errors.New("push container image: source path is only used with oci and tar source types")
+			}
+		case cs.SourceOCI, cs.SourceTar:
+			// The image may be named after the one that the source has.
+			source = struct{ what, input string }{"source path", img.SourcePath}
+		default:
+			return nil, fmt.Errorf("push container image: source type %q is invalid: it must be %q, %q or %q",
+				img.SourceType, cs.SourceDaemon, cs.SourceOCI, cs.SourceTar)
+		}
+
+		if len(source.input) == 0 {
+			if len(p.Images) == 0 {
+				return nil, fmt.Errorf("push container image: %s is not specified", source.what)
+			}
+			return nil, fmt.Errorf("push container image: %s is not specified in images[%d]", source.what, i)
+		}
+		where := ""
+		if len(p.Images) != 0 {
+			where = fmt.Sprintf(" in images[%d]", i)
+		}
+		var err error
+		images[i].Label, extraLabels[i], err = splitLabels(where, img.Label, img.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("push container image: %w", err)
+		}
+	}
+
+	for _, check := range []struct {
+		what  string
+		input int
+	}{
+		{"register grace period", p.RegisterGracePeriodSeconds},
+		{"push attempts", p.PushAttempts},
+		{"push attempt timeout", p.PushAttemptTimeoutSeconds},
+		{"push timeout", p.PushTimeoutSeconds},
+	} {
+		if check.input < 0 {
+			return nil, fmt.Errorf("push container image: %s must not be negative", check.what)
+		}
+	}
+
+	if p.Atomic && p.ContinueOnError {
+		return nil, errors.New("push container image: atomic and continueOnError can't be used together")
+	}
+
+	r := &cs.PushImagesInput{Atomic: p.Atomic, ContinueOnError: p.ContinueOnError}
+	for i, img := range images {
+		r.Images = append(r.Images, &cs.PushImageInput{
+			Service:     p.Service,
+			Image:       img.Image,
+			Label:       img.Label,
+			ExtraLabels: extraLabels[i],
+
+			SourceType: img.SourceType,
+			SourcePath: img.SourcePath,
+
+			RegisterGracePeriod: time.Duration(p.RegisterGracePeriodSeconds) * time.Second,
+			PushAttempts:        p.PushAttempts,
+			PushAttemptTimeout:  time.Duration(p.PushAttemptTimeoutSeconds) * time.Second,
+			PushTimeout:         time.Duration(p.PushTimeoutSeconds) * time.Second,
+			IfNotPresent:        p.IfNotPresent,
+			RequireExposedPorts: p.RequireExposedPorts,
+			WarnIncompatible:    p.WarnIncompatible,
+			KeepLocalTag:        p.KeepLocalTag,
+			TagPrefix:           p.TagPrefix,
+			Platform:            p.Platform,
+			SignatureVerifier:   verifier,
+		})
+	}
+	return r, nil
+}
+
+// splitLabels checks the label, or labels, of an image in the payload,
+// and returns the one to register the image under first, followed by
+// the rest. The where suffix tells which image it is in error messages.
+func splitLabels(where, label string, labels []string) (string, []string, error) {
+	switch {
+	case label != "" && len(labels) != 0:
+		return "", nil, fmt.Errorf("either container label or labels must be specified%s, but not both", where)
+	case label != "":
+		labels = []string{label}
+	case len(labels) == 0:
+		return "", nil, fmt.Errorf("container label is not specified%s", where)
+	}
+
+	seen := map[string]bool{}
+	for i, l := range labels {
+		if err := checkLabel("container label"+where, l); err != nil {
+			return "", nil, err
+		}
+		if i > 0 && l == cs.AutoLabel {
+			return "", nil, fmt.Errorf("container label %q%s can only be the first of labels", cs.AutoLabel, where)
+		}
+		if seen[l] {
+			return "", nil, fmt.Errorf("container label %q%s is repeated", l, where)
+		}
+		seen[l] = true
+	}
+	if len(labels) == 1 {
+		return labels[0], nil, nil
+	}
+	return labels[0], labels[1:], nil
+}
+
+type deleteContainerImagePayload struct {
+	Service string `json:"service" payload:"required"`
+	Image   string `json:"image" payload:"required"`
+}
+
+func parseDeleteContainerImagePayload(data json.RawMessage) (*cs.DeleteImageInput, error) {
+	var p deleteContainerImagePayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	for _, check := range []struct{ what, input string }{
+		{"service name", p.Service},
+		{"container image", p.Image},
+	} {
+		if len(check.input) != 0 {
+			continue
+		}
+		return nil, fmt.Errorf("delete container image: %s is not specified", check.what)
+	}
+	if err := checkServiceName(p.Service); err != nil {
+		return nil, fmt.Errorf("delete container image: %w", err)
+	}
+
+	return &cs.DeleteImageInput{Service: p.Service, Image: p.Image}, nil
+}
+
+type getContainerImagesPayload struct {
+	Service string `json:"service" payload:"required"`
+}
+
+func parseGetContainerImagesPayload(data json.RawMessage) (*cs.ListImagesInput, error) {
+	var p getContainerImagesPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	if len(p.Service) == 0 {
+		return nil, fmt.Errorf("get container images: service name is not specified")
+	}
+	if err := checkServiceName(p.Service); err != nil {
+		return nil, fmt.Errorf("get container images: %w", err)
+	}
+
+	return &cs.ListImagesInput{Service: p.Service}, nil
+}
+
+type getContainerServicesPayload struct {
+	Service string `json:"service"`
+}
+
+// parseGetContainerServicesPayload parses the optional service filter,
+// all services are listed without it.
+func parseGetContainerServicesPayload(data json.RawMessage) (*cs.ListServicesInput, error) {
+	var p getContainerServicesPayload
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, &p); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Service != "" {
+		if err := checkServiceName(p.Service); err != nil {
+			return nil, fmt.Errorf("get container services: %w", err)
+		}
+	}
+
+	return &cs.ListServicesInput{Service: p.Service}, nil
+}
+
+type getContainerServiceDeploymentsPayload struct {
+	Service string `json:"service" payload:"required"`
+}
+
+func parseGetContainerServiceDeploymentsPayload(data json.RawMessage) (*cs.ListDeploymentsInput, error) {
+	var p getContainerServiceDeploymentsPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	if len(p.Service) == 0 {
+		return nil, fmt.Errorf("get container service deployments: service name is not specified")
+	}
+	if err := checkServiceName(p.Service); err != nil {
+		return nil, fmt.Errorf("get container service deployments: %w", err)
+	}
+
+	return &cs.ListDeploymentsInput{Service: p.Service}, nil
+}
+
+type createDeploymentPayload struct {
+	Service          string `json:"service"`
+	CreateDeployment *struct {
+		Containers     map[string]types.Container `json:"containers"`
+		PublicEndpoint *types.EndpointRequest     `json:"publicEndpoint"`
+	} `json:"createDeployment"`
+}
+
+// parseCreateDeploymentPayload returns the deployment to create after
+// the images of a push are registered, which is in "createDeployment"
+// field, or nil if there's none.
+func parseCreateDeploymentPayload(data json.RawMessage) (*cs.DeployInput, error) {
+	var p createDeploymentPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	d := p.CreateDeployment
+	if d == nil {
+		return nil, nil
+	}
+
+	if len(d.Containers) == 0 {
+		return nil, fmt.Errorf("push container image: createDeployment has no containers")
+	}
+	for name, c := range d.Containers {
+		if aws.ToString(c.Image) == "" {
+			return nil, fmt.Errorf("push container image: container %q of createDeployment has no image", name)
+		}
+	}
+	if e := d.PublicEndpoint; e != nil {
+		if _, ok := d.Containers[aws.ToString(e.ContainerName)]; !ok {
+			return nil, fmt.Errorf("push container image: public endpoint container %q is not among the containers of createDeployment",
+				aws.ToString(e.ContainerName))
+		}
+		if aws.ToInt32(e.ContainerPort) <= 0 {
+			return nil, fmt.Errorf("push container image: public endpoint of createDeployment has no containerPort")
+		}
+	}
+
+	return &cs.DeployInput{Service: p.Service, Containers: d.Containers, PublicEndpoint: d.PublicEndpoint}, nil
+}
+
+// maxServiceScale is the largest number of nodes a service may have.
+const maxServiceScale = 20
+
+type createContainerServicePayload struct {
+	Service string `json:"service" payload:"required"`
+	Power   string `json:"power" payload:"required"`
+	Scale   int32  `json:"scale" payload:"required"`
+}
+
+func parseCreateContainerServicePayload(data json.RawMessage) (*cs.CreateServiceInput, error) {
+	var p createContainerServicePayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	if len(p.Service) == 0 {
+		return nil, fmt.Errorf("create container service: service name is not specified")
+	}
+	if err := checkServiceName(p.Service); err != nil {
+		return nil, fmt.Errorf("create container service: %w", err)
+	}
+	powers := types.ContainerServicePowerName("").Values()
+	if !slices.Contains(powers, types.ContainerServicePowerName(p.Power)) {
+		return nil, fmt.Errorf("create container service: power %q is invalid, it must be one of %q", p.Power, powers)
+	}
+	if p.Scale < 1 || p.Scale > maxServiceScale {
+		return nil, fmt.Errorf("create container service: scale %d is invalid, it must be from 1 to %d", p.Scale, maxServiceScale)
+	}
+
+	return &cs.CreateServiceInput{Service: p.Service, Power: p.Power, Scale: p.Scale}, nil
+}
+
+type updateDeploymentImagePayload struct {
+	Service       string `json:"service" payload:"required"`
+	ContainerName string `json:"containerName" payload:"required"`
+	Image         string `json:"image" payload:"required"`
+}
+
+func parseUpdateDeploymentImagePayload(data json.RawMessage) (*cs.UpdateImageInput, error) {
+	var p updateDeploymentImagePayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	for _, check := range []struct{ what, input string }{
+		{"service name", p.Service},
+		{"container name", p.ContainerName},
+		{"container image", p.Image},
+	} {
+		if len(check.input) != 0 {
+			continue
+		}
+		return nil, fmt.Errorf("update deployment image: %s is not specified", check.what)
+	}
+	if err := checkServiceName(p.Service); err != nil {
+		return nil, fmt.Errorf("update deployment image: %w", err)
+	}
+
+	return &cs.UpdateImageInput{Service: p.Service, Container: p.ContainerName, Image: p.Image}, nil
+}
+
+type getContainerLogPayload struct {
+	Service       string `json:"service" payload:"required"`
+	ContainerName string `json:"containerName" payload:"required"`
+	StartTime     string `json:"startTime"`
+	FilterPattern string `json:"filterPattern"`
+	Follow        bool   `json:"follow"`
+}
+
+func parseGetContainerLogPayload(data json.RawMessage) (*cs.GetLogInput, error) {
+	var p getContainerLogPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	for _, check := range []struct{ what, input string }{
+		{"service name", p.Service},
+		{"container name", p.ContainerName},
+	} {
+		if len(check.input) != 0 {
+			continue
+		}
+		return nil, fmt.Errorf("get container log: %s is not specified", check.what)
+	}
+	if err := checkServiceName(p.Service); err != nil {
+		return nil, fmt.Errorf("get container log: %w", err)
+	}
+
+	r := &cs.GetLogInput{
+		Service:       p.Service,
+		Container:     p.ContainerName,
+		FilterPattern: p.FilterPattern,
+		Follow:        p.Follow,
+	}
+	if p.StartTime != "" {
+		t, err := time.Parse(time.RFC3339, p.StartTime)
+		if err != nil {
+			return nil, fmt.Errorf("get container log: start time %q is invalid: it must look like 2006-01-02T15:04:05Z", p.StartTime)
+		}
+		r.StartTime = t
+	}
+	return r, nil
+}
+
+type getRegistryHostPayload struct {
+	Format string `json:"format"`
+}
+
+// parseGetRegistryHostPayload returns whether JSON output is requested,
+// the payload is optional for this operation.
+func parseGetRegistryHostPayload(data json.RawMessage) (asJSON bool, err error) {
+	var p getRegistryHostPayload
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, &p); err != nil {
+			return false, err
+		}
+	}
+
+	switch p.Format {
+	case "", "text":
+		return false, nil
+	case "json":
+		return true, nil
+	default:
+		return false, fmt.Errorf("get registry host: unsupported format %q, it must be either \"text\" or \"json\"", p.Format)
+	}
 }
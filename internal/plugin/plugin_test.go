@@ -4,27 +4,60 @@
 package plugin
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/lightsail"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/lightsailctl/internal"
 	"github.com/aws/lightsailctl/internal/cs"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 func TestInputVersion(t *testing.T) {
 	var tests = []struct {
-		pass  bool
-		input string
+		pass        bool
+		input       string
+		errContains string
 	}{
-		{input: `{"inputVersion": ""}`},
-		{input: `{"inputVersion": "v1"}`},
-		{input: `{"inputVersion": "bogus"}`},
+		{input: `{"inputVersion": ""}`, errContains: "non-negative number"},
+		{input: `{"inputVersion": "v1"}`, errContains: "non-negative number"},
+		{input: `{"inputVersion": "bogus"}`, errContains: "non-negative number"},
+		{
+			input:       `{"inputVersion": "7"}`,
+			errContains: "unsupported inputVersion 7, this build supports up to 2",
+		},
 		{
 			pass:  true,
-			input: `{"inputVersion": "55"}`,
+			input: `{"inputVersion": "1"}`,
 		},
 	}
 
@@ -36,15 +69,15 @@ func TestInputVersion(t *testing.T) {
 			}
 			continue
 		}
-		if err == nil {
-			t.Errorf("%s: unexpected succeeded", test.input)
+		if err == nil || !strings.Contains(err.Error(), test.errContains) {
+			t.Errorf("%s: got err: %v, that doesn't contain %q", test.input, err, test.errContains)
 		}
 	}
 
 	f := func(i int) bool {
 		input := fmt.Sprintf(`{"inputVersion": "%v"}`, i)
 		parsed, err := parseInput(strings.NewReader(input))
-		if i < 0 {
+		if i < minInputVersion || i > maxInputVersion {
 			return err != nil
 		}
 		if err != nil {
@@ -93,7 +126,9 @@ func TestParsePushContainerImagePayload(t *testing.T) {
 	for i, test := range []struct {
 		pass                 bool
 		payload, errContains string
-		want                 *cs.PushImageInput
+		want                 []*cs.PushImageInput
+		wantAtomic           bool
+		wantContinueOnError  bool
 	}{
 		{
 			payload:     `{"service": "dyservicev3", "image": "hello:latest"}`,
@@ -110,7 +145,231 @@ func TestParsePushContainerImagePayload(t *testing.T) {
 		{
 			pass:    true,
 			payload: `{"service": "dyservicev3", "image": "hello:latest", "label": "david16"}`,
-			want:    &cs.PushImageInput{Service: "dyservicev3", Image: "hello:latest", Label: "david16"},
+			want:    []*cs.PushImageInput{{Service: "dyservicev3", Image: "hello:latest", Label: "david16"}},
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "registerGracePeriodSeconds": -1}`,
+			errContains: "grace period",
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "pushAttempts": -3}`,
+			errContains: "push attempts",
+		},
+		{
+			pass: true,
+			payload: `{"service": "dyservicev3", "image": "hello:latest", "label": "david16",
+				"pushAttempts": 3, "pushAttemptTimeoutSeconds": 600}`,
+			want: []*cs.PushImageInput{{
+				Service: "dyservicev3", Image: "hello:latest", Label: "david16",
+				PushAttempts: 3, PushAttemptTimeout: 10 * time.Minute,
+			}},
+		},
+		{
+			pass:    true,
+			payload: `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "pushTimeoutSeconds": 3600}`,
+			want: []*cs.PushImageInput{{
+				Service: "dyservicev3", Image: "hello:latest", Label: "david16",
+				PushTimeout: time.Hour,
+			}},
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "pushTimeoutSeconds": -1}`,
+			errContains: "push timeout",
+		},
+		{
+			pass:    true,
+			payload: `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "registerGracePeriodSeconds": 30}`,
+			want: []*cs.PushImageInput{{
+				Service: "dyservicev3", Image: "hello:latest", Label: "david16",
+				RegisterGracePeriod: 30 * time.Second,
+			}},
+		},
+		{
+			pass:    true,
+			payload: `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "keepLocalTag": true}`,
+			want: []*cs.PushImageInput{{
+				Service: "dyservicev3", Image: "hello:latest", Label: "david16",
+				KeepLocalTag: true,
+			}},
+		},
+		{
+			pass:    true,
+			payload: `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "tagPrefix": "build-42"}`,
+			want: []*cs.PushImageInput{{
+				Service: "dyservicev3", Image: "hello:latest", Label: "david16",
+				TagPrefix: "build-42",
+			}},
+		},
+		{
+			pass:    true,
+			payload: `{"service": "dyservicev3", "image": "hello@sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa", "label": "david16"}`,
+			want: []*cs.PushImageInput{{
+				Service: "dyservicev3", Image: "hello@sha256:10b8cc432d56da8b61b070f4c7d2543a9ed17c2b23010b43af434fd40e2ca4aa", Label: "david16",
+			}},
+		},
+		{
+			pass: true,
+			payload: `{"service": "dyservicev3", "image": "hello:latest", "label": "david16",
+				"verifySignature": "cosign", "signatureKey": "awskms:///alias/signer"}`,
+			want: []*cs.PushImageInput{{
+				Service: "dyservicev3", Image: "hello:latest", Label: "david16",
+				SignatureVerifier: cs.NewCosignVerifier("awskms:///alias/signer"),
+			}},
+		},
+		{
+			pass:    true,
+			payload: `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "verifySignature": "notation"}`,
+			want: []*cs.PushImageInput{{
+				Service: "dyservicev3", Image: "hello:latest", Label: "david16",
+				SignatureVerifier: cs.NewNotationVerifier(),
+			}},
+		},
+		{
+			pass:    true,
+			payload: `{"service": "dyservicev3", "image": "hello:latest", "labels": ["latest", "v2"]}`,
+			want: []*cs.PushImageInput{{
+				Service: "dyservicev3", Image: "hello:latest", Label: "latest", ExtraLabels: []string{"v2"},
+			}},
+		},
+		{
+			pass:    true,
+			payload: `{"service": "dyservicev3", "images": [{"image": "hello:latest", "labels": ["web"]}]}`,
+			want:    []*cs.PushImageInput{{Service: "dyservicev3", Image: "hello:latest", Label: "web"}},
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "label": "latest", "labels": ["v2"]}`,
+			errContains: "either container label or labels must be specified, but not both",
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "labels": []}`,
+			errContains: "container label is not specified",
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "labels": ["latest", "auto"]}`,
+			errContains: `container label "auto" can only be the first of labels`,
+		},
+		{
+			payload:     `{"service": "dyservicev3", "images": [{"image": "hello:latest", "labels": ["v2", "v2"]}]}`,
+			errContains: `container label "v2" in images[0] is repeated`,
+		},
+		{
+			payload:     `{"service": "dyservicev3", "images": [{"image": "hello:latest", "labels": ["latest", "Bad"]}]}`,
+			errContains: "container label in images[0]",
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "verifySignature": "cosign"}`,
+			errContains: "signature key is not specified",
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "signatureKey": "cosign.pub"}`,
+			errContains: "signature key is only used",
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "verifySignature": "gpg"}`,
+			errContains: `signature verification "gpg" is invalid`,
+		},
+		{
+			pass:    true,
+			payload: `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "platform": "auto"}`,
+			want: []*cs.PushImageInput{{
+				Service: "dyservicev3", Image: "hello:latest", Label: "david16",
+				Platform: "auto",
+			}},
+		},
+		{
+			pass: true,
+			payload: `{"service": "dyservicev3", "pushAttempts": 2, "images": [
+				{"image": "web:latest", "label": "web"},
+				{"image": "api:latest", "label": "api"}
+			]}`,
+			want: []*cs.PushImageInput{
+				{Service: "dyservicev3", Image: "web:latest", Label: "web", PushAttempts: 2},
+				{Service: "dyservicev3", Image: "api:latest", Label: "api", PushAttempts: 2},
+			},
+		},
+		{
+			pass: true,
+			payload: `{"service": "dyservicev3", "atomic": true, "images": [
+				{"image": "web:latest", "label": "web"},
+				{"image": "api:latest", "label": "api"}
+			]}`,
+			want: []*cs.PushImageInput{
+				{Service: "dyservicev3", Image: "web:latest", Label: "web"},
+				{Service: "dyservicev3", Image: "api:latest", Label: "api"},
+			},
+			wantAtomic: true,
+		},
+		{
+			pass: true,
+			payload: `{"service": "dyservicev3", "continueOnError": true, "images": [
+				{"image": "web:latest", "label": "web"},
+				{"image": "api:latest", "label": "api"}
+			]}`,
+			want: []*cs.PushImageInput{
+				{Service: "dyservicev3", Image: "web:latest", Label: "web"},
+				{Service: "dyservicev3", Image: "api:latest", Label: "api"},
+			},
+			wantContinueOnError: true,
+		},
+		{
+			payload:     `{"service": "dyservicev3", "atomic": true, "continueOnError": true, "images": [{"image": "web:latest", "label": "web"}]}`,
+			errContains: "atomic and continueOnError can't be used together",
+		},
+		{
+			payload:     `{"service": "dyservicev3", "images": [{"image": "web:latest", "label": "web"}, {"image": "api:latest"}]}`,
+			errContains: "container label is not specified in images[1]",
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "images": [{"image": "web:latest", "label": "web"}]}`,
+			errContains: "not both",
+		},
+		{
+			pass:    true,
+			payload: `{"service": "dyservicev3", "label": "david16", "registerOnly": true, "digest": "sha256:10b8cc43"}`,
+			want: []*cs.PushImageInput{{
+				Service: "dyservicev3", Label: "david16",
+				RegisterOnly: true, Digest: "sha256:10b8cc43",
+			}},
+		},
+		{
+			pass:    true,
+			payload: `{"service": "dyservicev3", "label": "david16", "sourceType": "oci", "sourcePath": "build/oci"}`,
+			want: []*cs.PushImageInput{{
+				Service: "dyservicev3", Label: "david16",
+				SourceType: "oci", SourcePath: "build/oci",
+			}},
+		},
+		{
+			payload:     `{"service": "dyservicev3", "label": "david16", "sourceType": "tar"}`,
+			errContains: "source path is not specified",
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "sourcePath": "hello.tar"}`,
+			errContains: "only used with oci and tar",
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "sourceType": "zip"}`,
+			errContains: `source type "zip" is invalid`,
+		},
+		{
+			payload:     `{"service": "MyService", "image": "hello:latest", "label": "david16"}`,
+			errContains: `service name "MyService" is invalid`,
+		},
+		{
+			payload:     `{"service": "dyservicev3", "images": [{"image": "web:latest", "label": "web"}, {"image": "api:latest", "label": "api-"}]}`,
+			errContains: `container label in images[1] "api-" is invalid`,
+		},
+		{
+			payload:     `{"service": "dyservicev3", "label": "david16", "registerOnly": true}`,
+			errContains: "digest is not specified",
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "registerOnly": true, "digest": "sha256:10b8cc43"}`,
+			errContains: "instead of images",
+		},
+		{
+			payload:     `{"service": "dyservicev3", "image": "hello:latest", "label": "david16", "digest": "sha256:10b8cc43"}`,
+			errContains: "only used with registerOnly",
 		},
 	} {
 		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
@@ -124,9 +383,16 @@ func TestParsePushContainerImagePayload(t *testing.T) {
 			if test.pass {
 				if err != nil {
 					t.Error(err)
+					return
+				}
+				if !reflect.DeepEqual(got.Images, test.want) {
+					t.Errorf("got %#v, want %#v", got.Images, test.want)
 				}
-				if !reflect.DeepEqual(got, test.want) {
-					t.Errorf("got %#v, want %#v", got, test.want)
+				if got.Atomic != test.wantAtomic {
+					t.Errorf("got atomic %v, want %v", got.Atomic, test.wantAtomic)
+				}
+				if got.ContinueOnError != test.wantContinueOnError {
+					t.Errorf("got continueOnError %v, want %v", got.ContinueOnError, test.wantContinueOnError)
 				}
 				return
 			}
@@ -140,3 +406,1268 @@ func TestParsePushContainerImagePayload(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDeleteContainerImagePayload(t *testing.T) {
+	for i, test := range []struct {
+		payload, errContains string
+		want                 *cs.DeleteImageInput
+	}{
+		{payload: `{"service": "dyservicev3"}`, errContains: "container image"},
+		{payload: `{"image": ":dyservicev3.david16.3"}`, errContains: "service name"},
+		{payload: `[]`, errContains: "cannot unmarshal"},
+		{
+			payload: `{"service": "dyservicev3", "image": ":dyservicev3.david16.3"}`,
+			want:    &cs.DeleteImageInput{Service: "dyservicev3", Image: ":dyservicev3.david16.3"},
+		},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			got, err := parseDeleteContainerImagePayload([]byte(test.payload))
+			if test.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errContains) {
+					t.Errorf("got err: %v, that doesn't contain %q", err, test.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseGetContainerImagesPayload(t *testing.T) {
+	if _, err := parseGetContainerImagesPayload([]byte(`{}`)); err == nil ||
+		!strings.Contains(err.Error(), "service name") {
+		t.Errorf("got err: %v", err)
+	}
+
+	got, err := parseGetContainerImagesPayload([]byte(`{"service": "dyservicev3"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&cs.ListImagesInput{Service: "dyservicev3"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestParseGetContainerServiceDeploymentsPayload(t *testing.T) {
+	if _, err := parseGetContainerServiceDeploymentsPayload([]byte(`{}`)); err == nil ||
+		!strings.Contains(err.Error(), "service name") {
+		t.Errorf("got err: %v", err)
+	}
+
+	got, err := parseGetContainerServiceDeploymentsPayload([]byte(`{"service": "dyservicev3"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&cs.ListDeploymentsInput{Service: "dyservicev3"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestParseCreateDeploymentPayload(t *testing.T) {
+	got, err := parseCreateDeploymentPayload([]byte(`{"service": "doge", "image": "nginx:latest", "label": "www"}`))
+	if err != nil || got != nil {
+		t.Errorf("got %#v, %v for a payload without createDeployment", got, err)
+	}
+
+	got, err = parseCreateDeploymentPayload([]byte(`{
+		"service": "doge",
+		"image": "nginx:latest",
+		"label": "www",
+		"createDeployment": {
+			"containers": {
+				"web": {"image": "nginx:latest", "ports": {"80": "HTTP"}, "environment": {"MODE": "prod"}}
+			},
+			"publicEndpoint": {"containerName": "web", "containerPort": 80, "healthCheck": {"path": "/healthz"}}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := got.Containers["web"]
+	if got.Service != "doge" || aws.ToString(web.Image) != "nginx:latest" || web.Ports["80"] != "HTTP" ||
+		web.Environment["MODE"] != "prod" || aws.ToInt32(got.PublicEndpoint.ContainerPort) != 80 ||
+		aws.ToString(got.PublicEndpoint.HealthCheck.Path) != "/healthz" {
+		t.Errorf("got %#v", got)
+	}
+
+	for i, test := range []struct {
+		deployment string
+		wantErr    string
+	}{
+		{deployment: `{}`, wantErr: "createDeployment has no containers"},
+		{deployment: `{"containers": {"web": {}}}`, wantErr: `container "web" of createDeployment has no image`},
+		{
+			deployment: `{"containers": {"web": {"image": "nginx"}}, "publicEndpoint": {"containerName": "api", "containerPort": 80}}`,
+			wantErr:    `public endpoint container "api" is not among`,
+		},
+		{
+			deployment: `{"containers": {"web": {"image": "nginx"}}, "publicEndpoint": {"containerName": "web"}}`,
+			wantErr:    "has no containerPort",
+		},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			_, err := parseCreateDeploymentPayload([]byte(`{"service": "doge", "createDeployment": ` + test.deployment + `}`))
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got err: %v, want it to contain %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseCreateContainerServicePayload(t *testing.T) {
+	for i, test := range []struct {
+		payload string
+		want    *cs.CreateServiceInput
+		wantErr string
+	}{
+		{
+			payload: `{"service": "doge", "power": "nano", "scale": 1}`,
+			want:    &cs.CreateServiceInput{Service: "doge", Power: "nano", Scale: 1},
+		},
+		{payload: `{"power": "nano", "scale": 1}`, wantErr: "service name is not specified"},
+		{payload: `{"service": "doge", "scale": 1}`, wantErr: `power "" is invalid`},
+		{payload: `{"service": "doge", "power": "huge", "scale": 1}`, wantErr: `power "huge" is invalid`},
+		{payload: `{"service": "doge", "power": "nano"}`, wantErr: "scale 0 is invalid"},
+		{payload: `{"service": "doge", "power": "nano", "scale": 21}`, wantErr: "scale 21 is invalid"},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			got, err := parseCreateContainerServicePayload([]byte(test.payload))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("got err: %v, want it to contain %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseGetContainerServicesPayload(t *testing.T) {
+	for _, test := range []struct {
+		payload string
+		want    *cs.ListServicesInput
+	}{
+		{payload: ``, want: &cs.ListServicesInput{}},
+		{payload: `{}`, want: &cs.ListServicesInput{}},
+		{payload: `{"service": "dyservicev3"}`, want: &cs.ListServicesInput{Service: "dyservicev3"}},
+	} {
+		got, err := parseGetContainerServicesPayload([]byte(test.payload))
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, %v", test.payload, got, err)
+		}
+	}
+
+	if _, err := parseGetContainerServicesPayload([]byte(`{"service": 1}`)); err == nil {
+		t.Error("got no error")
+	}
+}
+
+func TestParseUpdateDeploymentImagePayload(t *testing.T) {
+	for i, test := range []struct {
+		payload, errContains string
+		want                 *cs.UpdateImageInput
+	}{
+		{
+			payload: `{"service": "doge", "containerName": "www", "image": ":doge.www.12"}`,
+			want:    &cs.UpdateImageInput{Service: "doge", Container: "www", Image: ":doge.www.12"},
+		},
+		{payload: `{"containerName": "www", "image": ":doge.www.12"}`, errContains: "service name is not specified"},
+		{payload: `{"service": "doge", "image": ":doge.www.12"}`, errContains: "container name is not specified"},
+		{payload: `{"service": "doge", "containerName": "www"}`, errContains: "container image is not specified"},
+		{payload: `{"service": "Doge", "containerName": "www", "image": ":doge.www.12"}`, errContains: `service name "Doge" is invalid`},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			got, err := parseUpdateDeploymentImagePayload([]byte(test.payload))
+			if test.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errContains) {
+					t.Errorf("got err: %v, want one that contains %q", err, test.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseGetContainerLogPayload(t *testing.T) {
+	for i, test := range []struct {
+		payload, errContains string
+		want                 *cs.GetLogInput
+	}{
+		{
+			payload: `{"service": "doge", "containerName": "www"}`,
+			want:    &cs.GetLogInput{Service: "doge", Container: "www"},
+		},
+		{
+			payload: `{"service": "doge", "containerName": "www", "startTime": "2021-01-28T01:13:56Z", "filterPattern": "GET", "follow": true}`,
+			want: &cs.GetLogInput{
+				Service: "doge", Container: "www",
+				StartTime: time.Unix(1611796436, 0).UTC(), FilterPattern: "GET", Follow: true,
+			},
+		},
+		{payload: `{"containerName": "www"}`, errContains: "service name is not specified"},
+		{payload: `{"service": "doge"}`, errContains: "container name is not specified"},
+		{payload: `{"service": "doge", "containerName": "www", "startTime": "yesterday"}`, errContains: `start time "yesterday" is invalid`},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			got, err := parseGetContainerLogPayload([]byte(test.payload))
+			if test.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errContains) {
+					t.Errorf("got err: %v, want one that contains %q", err, test.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseGetRegistryHostPayload(t *testing.T) {
+	for _, test := range []struct {
+		payload     string
+		want        bool
+		errContains string
+	}{
+		{payload: ``},
+		{payload: `{}`},
+		{payload: `{"format": "text"}`},
+		{payload: `{"format": "json"}`, want: true},
+		{payload: `{"format": "yaml"}`, errContains: `unsupported format "yaml"`},
+	} {
+		got, err := parseGetRegistryHostPayload([]byte(test.payload))
+		if test.errContains != "" {
+			if err == nil || !strings.Contains(err.Error(), test.errContains) {
+				t.Errorf("%s: got err: %v", test.payload, err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%s: got %v, %v", test.payload, got, err)
+		}
+	}
+}
+
+func TestReadInput(t *testing.T) {
+	for i, test := range []struct {
+		args        []string
+		stdin       string
+		want        *Input
+		errContains string
+	}{
+		{
+			args: []string{"-operation", "GetContainerAPIMetadata"},
+			want: &Input{InputVersion: "1", Operation: "GetContainerAPIMetadata"},
+		},
+		{
+			args: []string{"-operation", "GetRegistryHost", "-endpoint", "http://localhost:8080"},
+			want: &Input{
+				InputVersion:  "1",
+				Operation:     "GetRegistryHost",
+				Configuration: OperationConfig{Endpoint: "http://localhost:8080"},
+			},
+		},
+		{
+			args: []string{"-operation", "Diagnose"},
+			want: &Input{InputVersion: "1", Operation: "Diagnose"},
+		},
+		{
+			args:        []string{"-operation", "PushContainerImage"},
+			errContains: "no plugin input",
+		},
+		{
+			args:        []string{},
+			errContains: "no plugin input",
+		},
+		{
+			args:  []string{"-input-stdin", "-operation", "GetContainerImages"},
+			stdin: `{"inputVersion": "1", "operation": "Whatever", "payload": {"service": "doge"}}`,
+			want: &Input{
+				InputVersion: "1",
+				Operation:    "GetContainerImages",
+				Payload:      []byte(`{"service": "doge"}`),
+			},
+		},
+		{
+			args:        []string{"-input", `{"inputVersion": "bogus"}`},
+			errContains: "invalid plugin input: invalid inputVersion",
+		},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			got, err := readInput(fs, test.args, strings.NewReader(test.stdin))
+			if test.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errContains) {
+					t.Errorf("got err: %v, that doesn't contain %q", err, test.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEndpointOverride(t *testing.T) {
+	for i, test := range []struct {
+		payloadEndpoint, flagEndpoint string
+		want, errContains             string
+	}{
+		{want: ""},
+		{payloadEndpoint: "https://payload.example.com/", want: "https://payload.example.com"},
+		{flagEndpoint: "https://flag.example.com//", want: "https://flag.example.com"},
+		{
+			payloadEndpoint: "https://payload.example.com",
+			flagEndpoint:    "http://localhost:8080/",
+			want:            "http://localhost:8080",
+		},
+		{flagEndpoint: "localhost:8080", errContains: "invalid endpoint"},
+		{payloadEndpoint: "/just/a/path", errContains: "invalid endpoint"},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			c := OperationConfig{Endpoint: test.payloadEndpoint}
+			c.override(&OperationConfig{Endpoint: test.flagEndpoint})
+
+			ls, err := c.lightsailClient(aws.Config{})
+			if test.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errContains) {
+					t.Errorf("got err: %v, want it to contain %q", err, test.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := aws.ToString(ls.Options().BaseEndpoint); got != test.want {
+				t.Errorf("got BaseEndpoint %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// fakeDockerHost returns the address of a Docker Engine
+// that only responds to pings.
+func fakeDockerHost(t *testing.T) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", "1.45")
+		fmt.Fprint(w, "OK")
+	}))
+	t.Cleanup(srv.Close)
+	return "tcp://" + srv.Listener.Addr().String()
+}
+
+func TestImageEngine(t *testing.T) {
+	ctx := context.Background()
+	dockerHost := fakeDockerHost(t)
+	for _, engine := range []string{"", "docker", "podman"} {
+		c := OperationConfig{Engine: engine, DockerHost: dockerHost}
+		if _, err := c.imageEngine(ctx, nil, nil, nil); err != nil {
+			t.Errorf("engine %q: %v", engine, err)
+		}
+	}
+
+	c := OperationConfig{Engine: "containerd"}
+	if _, err := c.imageEngine(ctx, nil, nil, nil); err == nil || !strings.Contains(err.Error(), `unsupported engine "containerd"`) {
+		t.Errorf("got err: %v", err)
+	}
+
+	c = OperationConfig{DockerHost: dockerHost, MaxConcurrentUploads: 10}
+	if _, err := c.imageEngine(ctx, nil, nil, nil); err != nil {
+		t.Errorf("max concurrent uploads: %v", err)
+	}
+	c.MaxConcurrentUploads = -1
+	if _, err := c.imageEngine(ctx, nil, nil, nil); err == nil || !strings.Contains(err.Error(), "maxConcurrentUploads -1 is invalid") {
+		t.Errorf("got err: %v", err)
+	}
+
+	c = OperationConfig{DockerHost: dockerHost, DockerResponseHeaderTimeoutSeconds: 300, DockerTimeoutSeconds: 7200}
+	if _, err := c.imageEngine(ctx, nil, nil, nil); err != nil {
+		t.Errorf("docker timeouts: %v", err)
+	}
+	c = OperationConfig{DockerHost: dockerHost, DockerResponseHeaderTimeoutSeconds: -1}
+	if _, err := c.imageEngine(ctx, nil, nil, nil); err == nil || !strings.Contains(err.Error(), "dockerResponseHeaderTimeoutSeconds -1 is invalid") {
+		t.Errorf("got err: %v", err)
+	}
+	c = OperationConfig{DockerHost: dockerHost, DockerTimeoutSeconds: -1}
+	if _, err := c.imageEngine(ctx, nil, nil, nil); err == nil || !strings.Contains(err.Error(), "dockerTimeoutSeconds -1 is invalid") {
+		t.Errorf("got err: %v", err)
+	}
+
+	for _, compression := range []string{"", "gzip", "zstd"} {
+		c := OperationConfig{Compression: compression, DockerHost: dockerHost}
+		if _, err := c.imageEngine(ctx, nil, nil, nil); err != nil {
+			t.Errorf("compression %q: %v", compression, err)
+		}
+	}
+	c = OperationConfig{Compression: "lz4", DockerHost: dockerHost}
+	if _, err := c.imageEngine(ctx, nil, nil, nil); err == nil || !strings.Contains(err.Error(), `unsupported compression "lz4"`) {
+		t.Errorf("got err: %v", err)
+	}
+
+	for _, format := range []string{"", "text", "json"} {
+		c := OperationConfig{ProgressFormat: format, DockerHost: dockerHost}
+		if _, err := c.imageEngine(ctx, nil, nil, io.Discard); err != nil {
+			t.Errorf("progress format %q: %v", format, err)
+		}
+	}
+	c = OperationConfig{ProgressFormat: "yaml", DockerHost: dockerHost}
+	if _, err := c.imageEngine(ctx, nil, nil, nil); err == nil || !strings.Contains(err.Error(), `unsupported progress format "yaml"`) {
+		t.Errorf("got err: %v", err)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	blockUntilDone := func(ctx context.Context) error {
+		<-ctx.Done()
+		return fmt.Errorf("push: %w", ctx.Err())
+	}
+
+	c := OperationConfig{TimeoutSeconds: 1}
+	err := c.withTimeout(context.Background(), blockUntilDone)
+	if want := "operation timed out after 1s: push: context deadline exceeded"; err == nil || err.Error() != want {
+		t.Errorf("got err: %v", err)
+		t.Logf("want: %v", want)
+	}
+
+	c = OperationConfig{}
+	err = c.withTimeout(context.Background(), func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); ok {
+			return errors.New("unexpected deadline")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	c = OperationConfig{TimeoutSeconds: 60}
+	opErr := errors.New("something else failed")
+	if err := c.withTimeout(context.Background(), func(context.Context) error { return opErr }); err != opErr {
+		t.Errorf("got err: %v, want: %v", err, opErr)
+	}
+}
+
+// fakeImageSizer knows the sizes of local images, and fails
+// when its context is done, the same as a container engine.
+type fakeImageSizer map[string]int64
+
+func (f fakeImageSizer) ImageSize(ctx context.Context, image string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	size, ok := f[image]
+	if !ok {
+		return 0, fmt.Errorf("no such image: %s", image)
+	}
+	return size, nil
+}
+
+func TestImagesSize(t *testing.T) {
+	dc := fakeImageSizer{"nginx:1": 100, "loaded:latest": 20}
+	// The push may have been cut short by ctx.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := []*cs.PushImageResult{{Image: "nginx:1"}, nil, {Image: "loaded:latest"}}
+	if got := imagesSize(ctx, nil, dc, results); got != 120 {
+		t.Errorf("got size %d, want 120", got)
+	}
+	results = append(results, &cs.PushImageResult{Image: "docker.io/library/nginx:1"})
+	if got := imagesSize(ctx, nil, dc, results); got != 0 {
+		t.Errorf("got size %d, want 0 when an image is unknown", got)
+	}
+}
+
+type fakeRoleAssumer struct {
+	fail bool
+	got  *sts.AssumeRoleInput
+}
+
+func (f *fakeRoleAssumer) AssumeRole(
+	_ context.Context,
+	in *sts.AssumeRoleInput,
+	_ ...func(*sts.Options),
+) (*sts.AssumeRoleOutput, error) {
+	f.got = in
+	if f.fail {
+		return nil, errors.New("AccessDenied: not authorized to perform sts:AssumeRole")
+	}
+	return &sts.AssumeRoleOutput{Credentials: &types.Credentials{
+		AccessKeyId:     aws.String("AKIDASSUMED"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}}, nil
+}
+
+func TestAssumeRole(t *testing.T) {
+	ctx := context.Background()
+	const roleARN = "arn:aws:iam::123456789012:role/pusher"
+
+	c := &OperationConfig{RoleARN: roleARN, ExternalID: "xyzzy"}
+	f := &fakeRoleAssumer{}
+	cfg, err := c.assumeRole(ctx, aws.Config{Region: "us-west-2"}, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(f.got.RoleArn) != roleARN ||
+		aws.ToString(f.got.RoleSessionName) != "lightsailctl" ||
+		aws.ToString(f.got.ExternalId) != "xyzzy" {
+		t.Errorf("got AssumeRole input: %#v", f.got)
+	}
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil || creds.AccessKeyID != "AKIDASSUMED" || cfg.Region != "us-west-2" {
+		t.Errorf("got %#v, %v", creds, err)
+	}
+
+	c = &OperationConfig{RoleARN: roleARN, RoleSessionName: "ci"}
+	_, err = c.assumeRole(ctx, aws.Config{}, &fakeRoleAssumer{fail: true})
+	if want := "could not assume role " + roleARN + ": "; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got err: %v, that doesn't contain %q", err, want)
+	}
+}
+
+func TestValidateRoleARN(t *testing.T) {
+	for arn, wantErr := range map[string]bool{
+		"arn:aws:iam::123456789012:role/pusher":          false,
+		"arn:aws-cn:iam::123456789012:role/path/pusher":  false,
+		"arn:aws:iam::123456789012:user/gollum":          true,
+		"arn:aws:s3:::bucket":                            true,
+		"pusher":                                         true,
+		"arn:aws:sts::123456789012:assumed-role/pusher/": true,
+	} {
+		if err := validateRoleARN(arn); (err != nil) != wantErr {
+			t.Errorf("%s: got err: %v", arn, err)
+		}
+	}
+
+	// Existing flows are unaffected without a role.
+	if _, err := (&OperationConfig{Region: "us-west-2"}).awsConfig(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if _, err := (&OperationConfig{RoleARN: "pusher"}).awsConfig(context.Background()); err == nil ||
+		!strings.Contains(err.Error(), "invalid role ARN") {
+		t.Errorf("got err: %v", err)
+	}
+}
+
+func TestSchemaInfo(t *testing.T) {
+	buf := new(bytes.Buffer)
+	deps := &operationDeps{stdout: buf}
+	if _, err := operations["SchemaInfo"].Handle(context.Background(), nil, &OperationConfig{}, deps); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		MinInputVersion, MaxInputVersion int
+		Operations                       []string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	wantOps := []string{
+		"CreateContainerService",
+		"DeleteContainerImage",
+		"Diagnose",
+		"GetContainerAPIMetadata",
+		"GetContainerImages",
+		"GetContainerLog",
+		"GetContainerServiceDeployments",
+		"GetContainerServices",
+		"GetRegistryHost",
+		"ListOperations",
+		"PushContainerImage",
+		"SchemaInfo",
+		"UpdateDeploymentImage",
+	}
+	if !reflect.DeepEqual(got.Operations, wantOps) {
+		t.Errorf("got operations %q, want %q", got.Operations, wantOps)
+	}
+	for _, name := range got.Operations {
+		if _, ok := operations[name]; !ok {
+			t.Errorf("operation %q is not dispatched", name)
+		}
+	}
+
+	for ver, wantOK := range map[int]bool{
+		got.MinInputVersion - 1: false,
+		got.MinInputVersion:     true,
+		got.MaxInputVersion:     true,
+		got.MaxInputVersion + 1: false,
+	} {
+		_, err := parseInput(strings.NewReader(fmt.Sprintf(`{"inputVersion": "%d"}`, ver)))
+		if (err == nil) != wantOK {
+			t.Errorf("inputVersion %d: got err: %v", ver, err)
+		}
+	}
+
+	_, err := invokeOperation(context.Background(), &Input{Operation: "Bogus"}, nil)
+	if want := `unknown plugin operation: "Bogus"`; err == nil || err.Error() != want {
+		t.Errorf("got err: %v, want %q", err, want)
+	}
+}
+
+func TestRetryConfig(t *testing.T) {
+	ctx := context.Background()
+
+	cfg, err := (&OperationConfig{Region: "us-west-2"}).awsConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RetryMaxAttempts != 0 || cfg.RetryMode != "" {
+		t.Errorf("got defaults changed: %d, %q", cfg.RetryMaxAttempts, cfg.RetryMode)
+	}
+
+	var c OperationConfig
+	if err := json.Unmarshal([]byte(`{"region": "us-west-2", "maxRetries": 0, "retryMode": "adaptive"}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = c.awsConfig(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RetryMaxAttempts != 1 || cfg.RetryMode != aws.RetryModeAdaptive {
+		t.Errorf("got %d, %q", cfg.RetryMaxAttempts, cfg.RetryMode)
+	}
+	throttled := &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Header:     http.Header{"Retry-After": {"7"}},
+		}},
+		Err: errors.New("too many requests"),
+	}}
+	if d, err := cfg.Retryer().RetryDelay(1, throttled); err != nil || d != 7*time.Second {
+		t.Errorf("got retry delay %v, %v, want the one of Retry-After", d, err)
+	}
+
+	for _, payload := range []string{`{"maxRetries": -1}`, `{"retryMode": "reckless"}`} {
+		if err := json.Unmarshal([]byte(payload), &c); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.awsConfig(ctx); err == nil {
+			t.Errorf("%s: unexpectedly succeeded", payload)
+		}
+		c = OperationConfig{}
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	for i, test := range []struct {
+		config   string
+		debugEnv string
+		want     internal.Level
+		wantErr  bool
+	}{
+		{config: `{}`, want: internal.LevelInfo},
+		{config: `{"logLevel": "error"}`, want: internal.LevelError},
+		{config: `{"logLevel": "debug"}`, want: internal.LevelDebug},
+		{config: `{"debug": true}`, want: internal.LevelDebug},
+		{config: `{"debug": true, "logLevel": "error"}`, want: internal.LevelDebug},
+		{config: `{"logLevel": "DEBUG"}`, wantErr: true},
+		{config: `{}`, debugEnv: "1", want: internal.LevelDebug},
+		{config: `{"logLevel": "error"}`, debugEnv: "true", want: internal.LevelDebug},
+		{config: `{"debug": true}`, debugEnv: "0", want: internal.LevelDebug},
+		{config: `{}`, debugEnv: "false", want: internal.LevelInfo},
+		{config: `{}`, debugEnv: "yes please", want: internal.LevelInfo},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			t.Setenv(DebugEnv, test.debugEnv)
+			var c OperationConfig
+			if err := json.Unmarshal([]byte(test.config), &c); err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.logLevel()
+			if test.wantErr {
+				var ie *inputError
+				if !errors.As(err, &ie) {
+					t.Errorf("got err: %v, want an input error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCABundlePEM(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+
+	if _, err := (&OperationConfig{Region: "us-west-2", CABundlePEM: caPEM}).awsConfig(ctx); err != nil {
+		t.Error(err)
+	}
+	if _, err := (&OperationConfig{Region: "us-west-2", CABundlePEM: "bogus"}).awsConfig(ctx); err == nil {
+		t.Error("unexpectedly succeeded with a bogus CA bundle")
+	}
+	_, err = (&OperationConfig{CABundle: "/etc/ca.pem", CABundlePEM: caPEM}).awsConfig(ctx)
+	if want := "mutually exclusive"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got err: %v, that doesn't contain %q", err, want)
+	}
+}
+
+func TestHTTPClient(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "https://lightsail.us-west-2.amazonaws.com/", nil)
+	isFromEnv := func(proxy func(*http.Request) (*url.URL, error)) bool {
+		return reflect.ValueOf(proxy).Pointer() == reflect.ValueOf(http.ProxyFromEnvironment).Pointer()
+	}
+
+	if c, err := (&OperationConfig{}).httpClient(); c != nil || err != nil {
+		t.Errorf("got %v, %v, want the default client", c, err)
+	}
+
+	c, err := (&OperationConfig{DoNotVerifySSL: true}).httpClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := c.GetTransport()
+	if !tr.TLSClientConfig.InsecureSkipVerify {
+		t.Error("TLS verification is not disabled")
+	}
+	if !isFromEnv(tr.Proxy) {
+		t.Error("proxy is not taken from the environment")
+	}
+
+	c, err = (&OperationConfig{ProxyURL: "http://proxy.example.com:3128"}).httpClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr = c.GetTransport()
+	if tr.TLSClientConfig != nil && tr.TLSClientConfig.InsecureSkipVerify {
+		t.Error("TLS verification is disabled")
+	}
+	if got, err := tr.Proxy(req); err != nil || got.String() != "http://proxy.example.com:3128" {
+		t.Errorf("got proxy %v, %v", got, err)
+	}
+
+	for _, bad := range []string{"proxy.example.com:3128", "ftp://proxy.example.com", "http://", "http://[::1"} {
+		if _, err := (&OperationConfig{ProxyURL: bad}).httpClient(); err == nil || !strings.Contains(err.Error(), "invalid proxyUrl") {
+			t.Errorf("%q: got err: %v", bad, err)
+		}
+		if _, err := (&OperationConfig{ProxyURL: bad, DockerHost: "tcp://127.0.0.1:2375"}).imageEngine(context.Background(), nil, nil, nil); err == nil {
+			t.Errorf("%q: image engine was created", bad)
+		}
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get(correlationIDHeader))
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprint(w, `{}`)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	for _, id := range []string{"", "case-12345"} {
+		c := &OperationConfig{Region: "us-west-2", Endpoint: srv.URL, CorrelationID: id}
+		ls, err := c.newLightsailClient(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ls.GetContainerAPIMetadata(ctx, &lightsail.GetContainerAPIMetadataInput{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"", "case-12345"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got headers %q, want %q", got, want)
+	}
+}
+
+func TestUserAgentExtra(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("User-Agent")
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprint(w, `{}`)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	for i, test := range []struct {
+		extra, want string
+	}{
+		{"my-ci/1.2", " my-ci/1.2"},
+		{"jenkins", " jenkins"},
+		{"build_42/v1.0.0-rc.1+sha.5114f85", " build_42/v1.0.0-rc.1+sha.5114f85"},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			c := &OperationConfig{Region: "us-west-2", Endpoint: srv.URL, UserAgentExtra: test.extra}
+			ls, err := c.newLightsailClient(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ls.GetContainerAPIMetadata(ctx, &lightsail.GetContainerAPIMetadataInput{}); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(got, " lightsailctl/") || !strings.HasSuffix(got, test.want) {
+				t.Errorf("got user agent %q, want it to end with %q", got, test.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"my ci", "my-ci/1.2/3", "/1.2", "my-ci/", "ci(1)", "ci;", "ünicode"} {
+		c := &OperationConfig{Region: "us-west-2", UserAgentExtra: bad}
+		_, err := c.awsConfig(ctx)
+		var ie *inputError
+		if !errors.As(err, &ie) || !strings.Contains(err.Error(), "invalid userAgentExtra") {
+			t.Errorf("%q: got err: %v", bad, err)
+		}
+	}
+}
+
+func TestRegistryEndpointOverride(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprint(w, `{"registryLogin": {"registry": "123456789012.dkr.ecr.us-west-2.amazonaws.com", "username": "AWS"}}`)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	for override, want := range map[string]string{
+		"":                          "123456789012.dkr.ecr.us-west-2.amazonaws.com",
+		"localhost:5000":            "localhost:5000",
+		"gateway.example.com/ecr/":  "gateway.example.com/ecr",
+		"http://localhost:5000":     "",
+		"Gateway.Example.COM/UPPER": "",
+		"localhost:5000/with space": "",
+	} {
+		c := &OperationConfig{Region: "us-west-2", Endpoint: srv.URL, RegistryEndpointOverride: override}
+		ls, err := c.newLightsailClient(ctx)
+		if want == "" {
+			var ie *inputError
+			if !errors.As(err, &ie) {
+				t.Errorf("%q: got err: %v, want an input error", override, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ls.CreateContainerServiceRegistryLogin(ctx, &lightsail.CreateContainerServiceRegistryLoginInput{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := aws.ToString(out.RegistryLogin.Registry); got != want {
+			t.Errorf("%q: got registry %q, want %q", override, got, want)
+		}
+	}
+}
+
+func TestOperationHandlers(t *testing.T) {
+	ctx := context.Background()
+	deps := &operationDeps{stdout: io.Discard}
+	for name, op := range operations {
+		if op.handler == nil {
+			t.Errorf("operation %q has no handler", name)
+		}
+	}
+
+	for _, name := range []string{"DeleteContainerImage", "GetContainerImages", "GetRegistryHost"} {
+		_, err := operations[name].Handle(ctx, json.RawMessage(`[]`), &OperationConfig{}, deps)
+		var inErr *inputError
+		if !errors.As(err, &inErr) || !strings.Contains(err.Error(), "unable to parse the input's payload field") {
+			t.Errorf("%s: got err: %v", name, err)
+		}
+	}
+
+	if _, err := invokeOperation(ctx, &Input{Operation: "Bogus"}, nil); err == nil || err.Error() != `unknown plugin operation: "Bogus"` {
+		t.Errorf("got err: %v", err)
+	}
+}
+
+func Example_listOperations() {
+	in := &Input{Operation: "ListOperations"}
+	if _, err := invokeOperation(context.Background(), in, nil); err != nil {
+		fmt.Println(err)
+	}
+
+	// Output:
+	// OPERATION                       REQUIRED PAYLOAD FIELDS
+	// CreateContainerService          service; power; scale
+	// DeleteContainerImage            service; image
+	// Diagnose                        -
+	// GetContainerAPIMetadata         -
+	// GetContainerImages              service
+	// GetContainerLog                 service; containerName
+	// GetContainerServiceDeployments  service
+	// GetContainerServices            -
+	// GetRegistryHost                 -
+	// ListOperations                  -
+	// PushContainerImage              service; image and label or labels, or images, or digest and label or labels with registerOnly
+	// SchemaInfo                      -
+	// UpdateDeploymentImage           service; containerName; image
+}
+
+func Example_deleteContainerImageDryRun() {
+	in := &Input{
+		Operation:     "DeleteContainerImage",
+		Payload:       json.RawMessage(`{"service": "doge", "image": ":doge.www.3"}`),
+		Configuration: OperationConfig{Region: "us-west-2", DryRun: true},
+	}
+	if _, err := invokeOperation(context.Background(), in, nil); err != nil {
+		fmt.Println(err)
+	}
+
+	// Output:
+	// Dry run: image ":doge.www.3" would be deleted from service "doge".
+}
+
+func TestDryRunOutput(t *testing.T) {
+	for i, test := range []struct {
+		operation string
+		payload   string
+		want      string
+	}{
+		{
+			operation: "DeleteContainerImage",
+			payload:   `{"service": "doge", "image": ":doge.www.3"}`,
+			want:      "Dry run: image \":doge.www.3\" would be deleted from service \"doge\".\n",
+		},
+		{
+			operation: "CreateContainerService",
+			payload:   `{"service": "doge", "power": "nano", "scale": 1}`,
+			want:      "Dry run: service \"doge\" would be created with power \"nano\" and scale 1.\n",
+		},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			out := new(bytes.Buffer)
+			cfg := &OperationConfig{Region: "us-west-2", DryRun: true}
+			_, err := operations[test.operation].Handle(context.Background(), json.RawMessage(test.payload), cfg,
+				&operationDeps{stdout: out})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.want {
+				t.Errorf("got output: %q, want: %q", out, test.want)
+			}
+		})
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(configFile, []byte("[profile pusher]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_PROFILE", "pusher")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDSECRETID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secretkey")
+	t.Setenv("AWS_CA_BUNDLE", "")
+	t.Setenv("DOCKER_HOST", "")
+
+	var buf bytes.Buffer
+	c := &OperationConfig{
+		Region:   "eu-west-1",
+		Endpoint: "https://lightsail.example.com",
+		logger:   internal.NewLogger(log.New(&buf, "", 0), internal.LevelDebug),
+	}
+	if _, err := c.awsConfig(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := "effective config: region=eu-west-1 profile=pusher endpoint=https://lightsail.example.com " +
+		"doNotVerifySSL=false caBundle=default dockerHost=default\n"
+	if got := buf.String(); !strings.HasSuffix(got, want) {
+		t.Errorf("got %q, want it to end with %q", got, want)
+	}
+	if strings.Contains(buf.String(), "SECRET") || strings.Contains(buf.String(), "secret") {
+		t.Errorf("credentials are logged: %q", buf.String())
+	}
+
+	buf.Reset()
+	c.logger = internal.NewLogger(log.New(&buf, "", 0), internal.LevelInfo)
+	if _, err := c.awsConfig(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("got %q at info level", buf.String())
+	}
+}
+
+func TestSharedFiles(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh:", err)
+	}
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	config := `[profile proc]
+credential_process = echo '{"Version": 1, "AccessKeyId": "AKIDPROCESS", "SecretAccessKey": "secret"}'
+`
+	if err := os.WriteFile(configFile, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	credsFile := filepath.Join(dir, "credentials")
+	if err := os.WriteFile(credsFile, []byte("[static]\naws_access_key_id = AKIDSTATIC\naws_secret_access_key = secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "nonexistent"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "nonexistent"))
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	ctx := context.Background()
+	for profile, want := range map[string]string{"proc": "AKIDPROCESS", "static": "AKIDSTATIC"} {
+		c := &OperationConfig{
+			Region:          "us-west-2",
+			Profile:         profile,
+			ConfigFiles:     []string{configFile},
+			CredentialFiles: []string{credsFile},
+		}
+		cfg, err := c.awsConfig(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		creds, err := cfg.Credentials.Retrieve(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds.AccessKeyID != want {
+			t.Errorf("%s: got access key %q, want %q", profile, creds.AccessKeyID, want)
+		}
+	}
+
+	c := &OperationConfig{Region: "us-west-2", ConfigFiles: []string{filepath.Join(dir, "missing")}}
+	_, err := c.awsConfig(ctx)
+	var ie *inputError
+	if !errors.As(err, &ie) || !strings.Contains(err.Error(), "invalid configFiles") {
+		t.Errorf("got err: %v", err)
+	}
+}
+
+func TestRegionProfilePrecedence(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(configFile, []byte(`[default]
+region = us-east-2
+[profile env]
+region = ap-south-1
+[profile payload]
+region = eu-north-1
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	for i, test := range []struct {
+		config OperationConfig
+		env    map[string]string
+		want   string
+	}{
+		{
+			want: `region "us-east-2" is from shared config, profile "default" is from shared config`,
+		},
+		{
+			env:  map[string]string{"AWS_PROFILE": "env"},
+			want: `region "ap-south-1" is from shared config, profile "env" is from AWS_PROFILE`,
+		},
+		{
+			env:  map[string]string{"AWS_DEFAULT_PROFILE": "env"},
+			want: `region "ap-south-1" is from shared config, profile "env" is from AWS_DEFAULT_PROFILE`,
+		},
+		{
+			config: OperationConfig{Profile: "payload"},
+			env:    map[string]string{"AWS_PROFILE": "env"},
+			want:   `region "eu-north-1" is from shared config, profile "payload" is from payload`,
+		},
+		{
+			env:  map[string]string{"AWS_PROFILE": "env", "AWS_REGION": "us-west-1"},
+			want: `region "us-west-1" is from AWS_REGION, profile "env" is from AWS_PROFILE`,
+		},
+		{
+			env:  map[string]string{"AWS_DEFAULT_REGION": "us-west-1"},
+			want: `region "us-west-1" is from AWS_DEFAULT_REGION, profile "default" is from shared config`,
+		},
+		{
+			config: OperationConfig{Region: "ca-central-1", Profile: "payload"},
+			env:    map[string]string{"AWS_PROFILE": "env", "AWS_REGION": "us-west-1"},
+			want:   `region "ca-central-1" is from payload, profile "payload" is from payload`,
+		},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_PROFILE", "AWS_DEFAULT_PROFILE"} {
+				t.Setenv(name, test.env[name])
+			}
+			var buf bytes.Buffer
+			c := test.config
+			c.logger = internal.NewLogger(log.New(&buf, "", 0), internal.LevelDebug)
+			if _, err := c.awsConfig(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got, _, _ := strings.Cut(buf.String(), "\n"); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEventsFormat(t *testing.T) {
+	// invoke runs in with os.Stdout and os.Stderr redirected
+	// and returns the types of the events and what went to stderr.
+	invoke := func(in *Input) (types []string, stderr string) {
+		t.Helper()
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		er, ew, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer er.Close()
+		stdout, stderrFile := os.Stdout, os.Stderr
+		os.Stdout, os.Stderr = w, ew
+		defer func() { os.Stdout, os.Stderr = stdout, stderrFile }()
+
+		res, err := invokeOperation(context.Background(), in, nil)
+		in.Configuration.writeResult(in.Operation, res, err)
+		w.Close()
+		ew.Close()
+
+		dec := json.NewDecoder(r)
+		for dec.More() {
+			var ev internal.Event
+			if err := dec.Decode(&ev); err != nil {
+				t.Fatal(err)
+			}
+			if ev.Timestamp.IsZero() {
+				t.Errorf("event has no timestamp: %+v", ev)
+			}
+			if ev.Type == internal.EventConfigResolved && ev.Region != "us-west-2" {
+				t.Errorf("got event %+v", ev)
+			}
+			types = append(types, ev.Type)
+		}
+		b, err := io.ReadAll(er)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return types, string(b)
+	}
+
+	in := &Input{
+		Operation:     "DeleteContainerImage",
+		Payload:       json.RawMessage(`{"service": "doge", "image": ":doge.www.3"}`),
+		Configuration: OperationConfig{Region: "us-west-2", DryRun: true, EventsFormat: "ndjson"},
+	}
+	types, stderr := invoke(in)
+	if want := []string{internal.EventConfigResolved, internal.EventDone}; !reflect.DeepEqual(types, want) {
+		t.Errorf("got events %q, want %q", types, want)
+	}
+	// Messages for users stay out of the events.
+	if want := "Dry run: image \":doge.www.3\" would be deleted from service \"doge\".\n"; stderr != want {
+		t.Errorf("got stderr %q, want %q", stderr, want)
+	}
+
+	// Operations that fail early are done too.
+	in.Operation = "Whatever"
+	if types, _ := invoke(in); !reflect.DeepEqual(types, []string{internal.EventDone}) {
+		t.Errorf("got events %q for an unknown operation", types)
+	}
+
+	in.Configuration.EventsFormat = "xml"
+	if _, err := invokeOperation(context.Background(), in, nil); err == nil || !strings.Contains(err.Error(), "unsupported events format") {
+		t.Errorf("got err: %v", err)
+	}
+}
+
+func TestWriteResult(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := &OperationConfig{events: internal.NewEvents(buf)}
+	res := pushContainerImageResult{Images: []*cs.PushImageResult{
+		{Image: "nginx:latest", Digest: "sha256:10b8cc43", Reference: ":doge.www.12", Region: "us-west-2"},
+	}}
+	c.writeResult("PushContainerImage", res, nil)
+	c.writeResult("PushContainerImage", nil, errors.New("boom"))
+
+	type doneEvent struct {
+		Type, Operation, Error string
+		Result                 json.RawMessage
+	}
+	var got []doneEvent
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var ev doneEvent
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ev)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d events, want 2", len(got))
+	}
+	want := `{"images":[{"image":"nginx:latest","digest":"sha256:10b8cc43","reference":":doge.www.12","region":"us-west-2"}]}`
+	if ev := got[0]; ev.Type != internal.EventDone || ev.Operation != "PushContainerImage" || string(ev.Result) != want {
+		t.Errorf("got event %+v with result %s, want %s", ev, ev.Result, want)
+	}
+	if ev := got[1]; ev.Error != "boom" || ev.Result != nil {
+		t.Errorf("got event %+v with result %s", ev, ev.Result)
+	}
+
+	// Without events, there's nothing to render.
+	(&OperationConfig{}).writeResult("PushContainerImage", res, nil)
+}
@@ -0,0 +1,49 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// ssoLoginHint returns the command that refreshes the SSO session
+// that cfg takes credentials from, or "" if it doesn't use SSO.
+// c.SSOSession names the session when the shared config doesn't
+// tell that it's an SSO profile, e.g. because it's generated elsewhere.
+func (c *OperationConfig) ssoLoginHint(cfg aws.Config, profile string) string {
+	if c.SSOSession != "" {
+		return "aws sso login --sso-session " + c.SSOSession
+	}
+	for _, src := range cfg.ConfigSources {
+		sc, ok := src.(config.SharedConfig)
+		if !ok || sc.SSOSessionName == "" && sc.SSOStartURL == "" {
+			continue
+		}
+		if profile == "" {
+			return "aws sso login"
+		}
+		return "aws sso login --profile " + profile
+	}
+	return ""
+}
+
+// ssoCredentialsProvider adds to credential failures of an SSO profile
+// what to do about them, because SSO tokens expire every few hours
+// and the SDK's errors don't say how to get a new one.
+type ssoCredentialsProvider struct {
+	aws.CredentialsProvider
+	loginHint string
+}
+
+func (p *ssoCredentialsProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.CredentialsProvider.Retrieve(ctx)
+	if err != nil {
+		return creds, fmt.Errorf("%w; the SSO session may have expired, run %q to sign in again", err, p.loginHint)
+	}
+	return creds, nil
+}
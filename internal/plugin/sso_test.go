@@ -0,0 +1,82 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSSOLoginHint(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	err := os.WriteFile(configFile, []byte(`[profile org]
+sso_session = corp
+sso_account_id = 123456789012
+sso_role_name = Pusher
+region = us-west-2
+
+[profile legacy]
+sso_start_url = https://corp.awsapps.com/start
+sso_region = us-east-1
+sso_account_id = 123456789012
+sso_role_name = Pusher
+region = us-west-2
+
+[profile keys]
+aws_access_key_id = AKIDEXAMPLE
+aws_secret_access_key = secret
+region = us-west-2
+
+[sso-session corp]
+sso_start_url = https://corp.awsapps.com/start
+sso_region = us-east-1
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// There is no cached SSO token in an empty home directory.
+	t.Setenv("HOME", dir)
+	t.Setenv("USERPROFILE", dir)
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_PROFILE", "AWS_DEFAULT_PROFILE"} {
+		t.Setenv(name, "")
+	}
+
+	ctx := context.Background()
+	for i, test := range []struct {
+		config   OperationConfig
+		wantHint string
+	}{
+		{config: OperationConfig{Profile: "org"}, wantHint: `run "aws sso login --profile org"`},
+		{config: OperationConfig{Profile: "legacy"}, wantHint: `run "aws sso login --profile legacy"`},
+		{config: OperationConfig{Profile: "org", SSOSession: "corp"}, wantHint: `run "aws sso login --sso-session corp"`},
+		{config: OperationConfig{Profile: "keys"}},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			cfg, err := test.config.awsConfig(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = cfg.Credentials.Retrieve(ctx)
+			if test.wantHint == "" {
+				if err != nil {
+					t.Errorf("got err: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantHint) {
+				t.Errorf("got err: %v, want it to contain %q", err, test.wantHint)
+			}
+			if got := exitCode(err); got != exitAuth {
+				t.Errorf("got exit code %d, want %d", got, exitAuth)
+			}
+		})
+	}
+}
@@ -6,7 +6,10 @@ package internal
 import (
 	"context"
 	"fmt"
-	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/lightsail"
 )
@@ -19,30 +22,112 @@ type ContainerAPIMetadataGetter interface {
 	) (*lightsail.GetContainerAPIMetadataOutput, error)
 }
 
+// CheckForUpdates logs a warning if a newer lightsailctl is available.
+// It's a convenience wrapper of CheckUpdate, which only logs its failures
+// to logger.
+//
+// The warning points to downloadURL, which is usually DownloadURL
+// of the region in use.
+//
+// The check is skipped entirely when NoUpdateCheckEnv is set to a true
+// value, as understood by strconv.ParseBool.
 func CheckForUpdates(
 	ctx context.Context,
-	debugLog *log.Logger,
+	logger *Logger,
 	g ContainerAPIMetadataGetter,
 	inUse Semver,
+	downloadURL string,
 ) {
-	available, err := getLatestLightsailctlVersion(ctx, g)
+	if v, ok := os.LookupEnv(NoUpdateCheckEnv); ok {
+		if disabled, _ := strconv.ParseBool(v); disabled {
+			logger.Debugf("update check is disabled by %s=%s", NoUpdateCheckEnv, v)
+			return
+		}
+	}
+
+	available, outdated, err := CheckUpdate(ctx, g, inUse)
 	if err != nil {
-		debugLog.Print(err.Error())
+		logger.Debugf("%v", err)
 		return
 	}
 
-	if inUse.Less(available) {
-		log.Printf(`WARNING: You are using lightsailctl %s, but %s is available.
-To download, visit https://lightsail.aws.amazon.com/ls/docs/en_us/articles/amazon-lightsail-install-software`,
-			inUse, available)
+	if outdated {
+		logger.Infof(`WARNING: You are using lightsailctl %s, but %s is available.
+To download, visit %s`,
+			inUse, available, downloadURL)
+	}
+}
+
+// CheckUpdate returns the latest available lightsailctl version and
+// whether inUse is outdated by it, see isUpdate.
+// The check is limited by updateCheckTimeout so that it doesn't hold up
+// the actual operation on a slow network.
+func CheckUpdate(
+	ctx context.Context,
+	g ContainerAPIMetadataGetter,
+	inUse Semver,
+) (available Semver, outdated bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, updateCheckTimeout)
+	defer cancel()
+
+	available, err = getLatestLightsailctlVersion(ctx, g)
+	if err != nil {
+		return "", false, err
+	}
+	return available, isUpdate(inUse, available), nil
+}
+
+// isUpdate tells whether available is an update of inUse for its release
+// channel: prereleases are updated by anything newer, be it a prerelease
+// or a release, while releases are only updated by newer releases.
+func isUpdate(inUse, available Semver) bool {
+	if available.IsPrerelease() && !inUse.IsPrerelease() {
+		return false
+	}
+	return inUse.Less(available)
+}
+
+// DownloadURL returns the lightsailctl installation page
+// for the AWS partition that region belongs to.
+// The page of the standard partition is returned for unknown regions.
+func DownloadURL(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "https://docs.amazonaws.cn/en_us/lightsail/latest/userguide/amazon-lightsail-install-software.html"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "https://docs.aws.amazon.com/lightsail/latest/userguide/amazon-lightsail-install-software.html"
+	default:
+		return "https://lightsail.aws.amazon.com/ls/docs/en_us/articles/amazon-lightsail-install-software"
 	}
 }
 
+// NoUpdateCheckEnv is the environment variable that disables CheckForUpdates.
+const NoUpdateCheckEnv = "LIGHTSAILCTL_NO_UPDATE_CHECK"
+
+// updateCheckTimeout may be changed by tests.
+var updateCheckTimeout = 2 * time.Second
+
+// updateCheckRetryDelay is how long getLatestLightsailctlVersion waits
+// before it retries a failed call, it may be changed by tests.
+var updateCheckRetryDelay = 250 * time.Millisecond
+
+// getLatestLightsailctlVersion retries a failed GetContainerAPIMetadata
+// call once, after a short delay, as long as ctx allows. Responses without
+// a valid version aren't retried, since they aren't transient.
 func getLatestLightsailctlVersion(
 	ctx context.Context,
 	g ContainerAPIMetadataGetter,
 ) (Semver, error) {
 	res, err := g.GetContainerAPIMetadata(ctx, &lightsail.GetContainerAPIMetadataInput{})
+	if err != nil && ctx.Err() == nil {
+		t := time.NewTimer(updateCheckRetryDelay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+		case <-t.C:
+			res, err = g.GetContainerAPIMetadata(ctx, &lightsail.GetContainerAPIMetadataInput{})
+		}
+	}
 	if err != nil {
 		return "", fmt.Errorf("could not get latest lightsailctl version: %w", err)
 	}
@@ -4,6 +4,7 @@
 package internal
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/lightsail"
 )
@@ -20,13 +22,16 @@ import (
 type fakeContainerAPIMetadataGetter string
 
 func (f fakeContainerAPIMetadataGetter) GetContainerAPIMetadata(
-	context.Context,
-	*lightsail.GetContainerAPIMetadataInput,
-	...func(*lightsail.Options),
+	ctx context.Context,
+	_ *lightsail.GetContainerAPIMetadataInput,
+	_ ...func(*lightsail.Options),
 ) (*lightsail.GetContainerAPIMetadataOutput, error) {
 	switch {
 	case f == "":
 		return &lightsail.GetContainerAPIMetadataOutput{}, nil
+	case f == "hang":
+		<-ctx.Done()
+		return nil, ctx.Err()
 	case strings.Contains(string(f), "error"):
 		return nil, errors.New(string(f))
 	default:
@@ -42,6 +47,8 @@ func (f fakeContainerAPIMetadataGetter) GetContainerAPIMetadata(
 }
 
 func ExampleCheckForUpdates() {
+	defer func(d time.Duration) { updateCheckRetryDelay = d }(updateCheckRetryDelay)
+	updateCheckRetryDelay = time.Millisecond
 	defer func(w io.Writer, flags int, p string) {
 		log.SetOutput(w)
 		log.SetFlags(flags)
@@ -51,16 +58,19 @@ func ExampleCheckForUpdates() {
 	log.SetOutput(os.Stdout)
 	log.SetFlags(0)
 	log.SetPrefix("[logger] ")
-	debugLog := log.New(log.Writer(), log.Prefix(), log.Flags())
+	logger := NewLogger(log.New(log.Writer(), log.Prefix(), log.Flags()), LevelDebug)
 
 	ctx := context.Background()
 
-	CheckForUpdates(ctx, debugLog, fakeContainerAPIMetadataGetter("1.4.33"), "v1.4.33")
-	CheckForUpdates(ctx, debugLog, fakeContainerAPIMetadataGetter("very bad error occurred"), "v1.4.33")
+	CheckForUpdates(ctx, logger, fakeContainerAPIMetadataGetter("1.4.33"), "v1.4.33", DownloadURL(""))
+	CheckForUpdates(ctx, logger, fakeContainerAPIMetadataGetter("very bad error occurred"), "v1.4.33", DownloadURL(""))
 
 	fmt.Println("now we should get warnings")
-	CheckForUpdates(ctx, debugLog, fakeContainerAPIMetadataGetter("v1.6.11"), "v1.4.33")
-	CheckForUpdates(ctx, debugLog, fakeContainerAPIMetadataGetter("v2.7.3"), "v2.7.3-beta")
+	CheckForUpdates(ctx, logger, fakeContainerAPIMetadataGetter("v1.6.11"), "v1.4.33", DownloadURL(""))
+	CheckForUpdates(ctx, logger, fakeContainerAPIMetadataGetter("v2.7.3"), "v2.7.3-beta", DownloadURL(""))
+
+	CheckForUpdates(ctx, logger, fakeContainerAPIMetadataGetter("v1.6.11"), "v1.4.33", DownloadURL("cn-north-1"))
+	CheckForUpdates(ctx, logger, fakeContainerAPIMetadataGetter("v1.6.11"), "v1.4.33", "https://mirror.example.com/lightsailctl")
 
 	// Output:
 	// [logger] could not get latest lightsailctl version: very bad error occurred
@@ -69,9 +79,142 @@ func ExampleCheckForUpdates() {
 	// To download, visit https://lightsail.aws.amazon.com/ls/docs/en_us/articles/amazon-lightsail-install-software
 	// [logger] WARNING: You are using lightsailctl v2.7.3-beta, but v2.7.3 is available.
 	// To download, visit https://lightsail.aws.amazon.com/ls/docs/en_us/articles/amazon-lightsail-install-software
+	// [logger] WARNING: You are using lightsailctl v1.4.33, but v1.6.11 is available.
+	// To download, visit https://docs.amazonaws.cn/en_us/lightsail/latest/userguide/amazon-lightsail-install-software.html
+	// [logger] WARNING: You are using lightsailctl v1.4.33, but v1.6.11 is available.
+	// To download, visit https://mirror.example.com/lightsailctl
+}
+
+func TestCheckForUpdatesTimeout(t *testing.T) {
+	defer func(d time.Duration) { updateCheckTimeout = d }(updateCheckTimeout)
+	updateCheckTimeout = 10 * time.Millisecond
+
+	defer func(w io.Writer) { log.SetOutput(w) }(log.Writer())
+	stdLog := new(bytes.Buffer)
+	log.SetOutput(stdLog)
+	debugBuf := new(bytes.Buffer)
+	logger := NewLogger(log.New(debugBuf, "", 0), LevelDebug)
+
+	start := time.Now()
+	CheckForUpdates(context.Background(), logger, fakeContainerAPIMetadataGetter("hang"), "v1.4.33", DownloadURL(""))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("update check took %v", elapsed)
+	}
+	if stdLog.Len() != 0 {
+		t.Errorf("unexpected log: %q", stdLog)
+	}
+	if want := "context deadline exceeded"; !strings.Contains(debugBuf.String(), want) {
+		t.Errorf("got debug log %q, that doesn't contain %q", debugBuf, want)
+	}
+}
+
+type countingContainerAPIMetadataGetter struct {
+	calls int
+}
+
+func (c *countingContainerAPIMetadataGetter) GetContainerAPIMetadata(
+	ctx context.Context,
+	in *lightsail.GetContainerAPIMetadataInput,
+	opts ...func(*lightsail.Options),
+) (*lightsail.GetContainerAPIMetadataOutput, error) {
+	c.calls++
+	return fakeContainerAPIMetadataGetter("v9.9.9").GetContainerAPIMetadata(ctx, in, opts...)
+}
+
+func TestCheckForUpdatesDisabled(t *testing.T) {
+	defer func(w io.Writer) { log.SetOutput(w) }(log.Writer())
+	stdLog := new(bytes.Buffer)
+	log.SetOutput(stdLog)
+
+	for i, test := range []struct {
+		value     string
+		wantCalls int
+	}{
+		{value: "1", wantCalls: 0},
+		{value: "true", wantCalls: 0},
+		{value: "false", wantCalls: 1},
+		{value: "bogus", wantCalls: 1},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			t.Setenv(NoUpdateCheckEnv, test.value)
+			stdLog.Reset()
+			debugBuf := new(bytes.Buffer)
+			g := &countingContainerAPIMetadataGetter{}
+
+			CheckForUpdates(context.Background(), NewLogger(log.New(debugBuf, "", 0), LevelDebug), g, "v1.4.33", DownloadURL(""))
+			if g.calls != test.wantCalls {
+				t.Errorf("got %d calls, want %d", g.calls, test.wantCalls)
+			}
+			if test.wantCalls == 0 {
+				if stdLog.Len() != 0 {
+					t.Errorf("unexpected log: %q", stdLog)
+				}
+				if want := "update check is disabled"; !strings.Contains(debugBuf.String(), want) {
+					t.Errorf("got debug log %q, that doesn't contain %q", debugBuf, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckUpdate(t *testing.T) {
+	defer func(d time.Duration) { updateCheckRetryDelay = d }(updateCheckRetryDelay)
+	updateCheckRetryDelay = time.Millisecond
+	ctx := context.Background()
+
+	for i, test := range []struct {
+		latest, inUse Semver
+		wantAvailable Semver
+		wantOutdated  bool
+		wantErr       string
+	}{
+		{latest: "v1.6.11", inUse: "v1.4.33", wantAvailable: "v1.6.11", wantOutdated: true},
+		{latest: "v1.4.33", inUse: "v1.4.33", wantAvailable: "v1.4.33"},
+		{latest: "v1.4.0", inUse: "v1.4.33", wantAvailable: "v1.4.0"},
+		// Prereleases are updated by newer prereleases and releases.
+		{latest: "v2.7.3", inUse: "v2.7.3-beta", wantAvailable: "v2.7.3", wantOutdated: true},
+		{latest: "v2.7.3-beta.2", inUse: "v2.7.3-beta.1", wantAvailable: "v2.7.3-beta.2", wantOutdated: true},
+		{latest: "v2.8.0-beta", inUse: "v2.7.3-beta", wantAvailable: "v2.8.0-beta", wantOutdated: true},
+		{latest: "v2.7.3-beta.1", inUse: "v2.7.3-beta.2", wantAvailable: "v2.7.3-beta.1"},
+		{latest: "v2.7.2", inUse: "v2.7.3-beta", wantAvailable: "v2.7.2"},
+		// Releases are updated by newer releases only.
+		{latest: "v2.8.0-beta", inUse: "v2.7.3", wantAvailable: "v2.8.0-beta"},
+		{latest: "v2.7.3-rc.1", inUse: "v2.7.2", wantAvailable: "v2.7.3-rc.1"},
+		{latest: "v2.7.4", inUse: "v2.7.3", wantAvailable: "v2.7.4", wantOutdated: true},
+		{latest: "network error", inUse: "v1.4.33", wantErr: "could not get latest lightsailctl version: network error"},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			gotErr := ""
+			available, outdated, err := CheckUpdate(ctx, fakeContainerAPIMetadataGetter(test.latest), test.inUse)
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != test.wantErr {
+				t.Errorf("got error %q, want error %q", gotErr, test.wantErr)
+			}
+			if available != test.wantAvailable || outdated != test.wantOutdated {
+				t.Errorf("got (%q, %v), want (%q, %v)", available, outdated, test.wantAvailable, test.wantOutdated)
+			}
+		})
+	}
+}
+
+func TestDownloadURL(t *testing.T) {
+	for region, want := range map[string]string{
+		"":               "https://lightsail.aws.amazon.com/ls/docs/en_us/articles/amazon-lightsail-install-software",
+		"us-east-1":      "https://lightsail.aws.amazon.com/ls/docs/en_us/articles/amazon-lightsail-install-software",
+		"cn-northwest-1": "https://docs.amazonaws.cn/en_us/lightsail/latest/userguide/amazon-lightsail-install-software.html",
+		"us-gov-west-1":  "https://docs.aws.amazon.com/lightsail/latest/userguide/amazon-lightsail-install-software.html",
+	} {
+		if got := DownloadURL(region); got != want {
+			t.Errorf("%q: got %q, want %q", region, got, want)
+		}
+	}
 }
 
 func TestGetLatestLightsailctlVersion(t *testing.T) {
+	defer func(d time.Duration) { updateCheckRetryDelay = d }(updateCheckRetryDelay)
+	updateCheckRetryDelay = time.Millisecond
 	ctx := context.Background()
 
 	for i, c := range []struct {
@@ -120,3 +263,60 @@ func TestGetLatestLightsailctlVersion(t *testing.T) {
 		})
 	}
 }
+
+// flakyContainerAPIMetadataGetter fails the first failures calls,
+// and then responds with f.
+type flakyContainerAPIMetadataGetter struct {
+	f        fakeContainerAPIMetadataGetter
+	failures int
+	calls    int
+}
+
+func (g *flakyContainerAPIMetadataGetter) GetContainerAPIMetadata(
+	ctx context.Context,
+	in *lightsail.GetContainerAPIMetadataInput,
+	opts ...func(*lightsail.Options),
+) (*lightsail.GetContainerAPIMetadataOutput, error) {
+	g.calls++
+	if g.calls <= g.failures {
+		return nil, errors.New("connection reset")
+	}
+	return g.f.GetContainerAPIMetadata(ctx, in, opts...)
+}
+
+func TestGetLatestLightsailctlVersionRetry(t *testing.T) {
+	defer func(d time.Duration) { updateCheckRetryDelay = d }(updateCheckRetryDelay)
+	updateCheckRetryDelay = time.Millisecond
+
+	for i, test := range []struct {
+		g         *flakyContainerAPIMetadataGetter
+		timeout   time.Duration
+		wantVer   Semver
+		wantCalls int
+	}{
+		{g: &flakyContainerAPIMetadataGetter{f: "1.4.1"}, wantVer: "1.4.1", wantCalls: 1},
+		{g: &flakyContainerAPIMetadataGetter{f: "1.4.1", failures: 1}, wantVer: "1.4.1", wantCalls: 2},
+		{g: &flakyContainerAPIMetadataGetter{f: "1.4.1", failures: 2}, wantCalls: 2},
+		// Responses without a valid version are not retried.
+		{g: &flakyContainerAPIMetadataGetter{f: ""}, wantCalls: 1},
+		{g: &flakyContainerAPIMetadataGetter{f: "bogus"}, wantCalls: 1},
+		// Nor are failures when there's no time left for a retry.
+		{g: &flakyContainerAPIMetadataGetter{f: "1.4.1", failures: 1}, timeout: -time.Second, wantCalls: 1},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			ctx := context.Background()
+			if test.timeout != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+			ver, err := getLatestLightsailctlVersion(ctx, test.g)
+			if ver != test.wantVer || (err == nil) != (test.wantVer != "") {
+				t.Errorf("got %q, %v, want %q", ver, err, test.wantVer)
+			}
+			if test.g.calls != test.wantCalls {
+				t.Errorf("got %d calls, want %d", test.g.calls, test.wantCalls)
+			}
+		})
+	}
+}
@@ -4,6 +4,10 @@
 package internal
 
 import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"runtime/debug"
 	"strings"
 
 	"golang.org/x/mod/semver"
@@ -11,16 +15,85 @@ import (
 
 const Version Semver = "v1.0.6"
 
+// Commit and BuildDate describe the build, they are meant to be set with
+//
+//	-ldflags "-X github.com/aws/lightsailctl/internal.Commit=... -X github.com/aws/lightsailctl/internal.BuildDate=..."
+//
+// When they aren't, the version control information that Go embeds
+// into binaries built from a repository checkout is used instead.
+var (
+	Commit    string
+	BuildDate string
+)
+
+// BuildInfo is what VersionInfo returns.
+type BuildInfo struct {
+	Version   Semver `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// VersionInfo returns the version of lightsailctl along with
+// the details of its build that are known.
+func VersionInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = s.Value
+			}
+		}
+	}
+	return info
+}
+
+// String formats b for humans, with the version alone on the first line.
+func (b BuildInfo) String() string {
+	return fmt.Sprintf("%s\ncommit: %s\nbuild date: %s\ngo version: %s",
+		b.Version, orUnknown(b.Commit), orUnknown(b.BuildDate), b.GoVersion)
+}
+
+// MarshalJSON formats b for programs, with all of its fields present,
+// the unknown ones being "unknown" the same as in String.
+func (b BuildInfo) MarshalJSON() ([]byte, error) {
+	type plain BuildInfo
+	b.Commit, b.BuildDate = orUnknown(b.Commit), orUnknown(b.BuildDate)
+	return json.Marshal(plain(b))
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
 type Semver string
 
 func (v Semver) IsValid() bool {
 	return semver.IsValid(v.String())
 }
 
+// Less tells whether v precedes other, where prereleases
+// precede the release they lead up to, e.g. v1.2.0-beta < v1.2.0.
 func (v Semver) Less(other Semver) bool {
 	return semver.Compare(v.String(), other.String()) < 0
 }
 
+// IsPrerelease tells whether v is on the prerelease channel, e.g. v1.2.0-beta.1.
+func (v Semver) IsPrerelease() bool {
+	return semver.Prerelease(v.String()) != ""
+}
+
 func (v Semver) String() string {
 	s := string(v)
 	if s == "" || strings.HasPrefix(s, "v") {
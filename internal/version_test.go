@@ -4,6 +4,9 @@
 package internal_test
 
 import (
+	"encoding/json"
+	"runtime"
+	"strconv"
 	"testing"
 
 	"github.com/aws/lightsailctl/internal"
@@ -15,3 +18,52 @@ func TestVersionGlobalIsValid(t *testing.T) {
 			string(internal.Version))
 	}
 }
+
+func TestVersionInfo(t *testing.T) {
+	defer func(c, d string) { internal.Commit, internal.BuildDate = c, d }(internal.Commit, internal.BuildDate)
+	internal.Commit, internal.BuildDate = "0123abc", "2024-08-01T10:00:00Z"
+
+	info := internal.VersionInfo()
+	if info.Version != internal.Version || info.Commit != "0123abc" ||
+		info.BuildDate != "2024-08-01T10:00:00Z" || info.GoVersion != runtime.Version() {
+		t.Errorf("got %#v", info)
+	}
+
+	want := "v1.0.6\ncommit: 0123abc\nbuild date: 2024-08-01T10:00:00Z\ngo version: go1.22.5"
+	info = internal.BuildInfo{Version: "v1.0.6", Commit: "0123abc", BuildDate: "2024-08-01T10:00:00Z", GoVersion: "go1.22.5"}
+	if got := info.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	want = "v1.0.6\ncommit: unknown\nbuild date: unknown\ngo version: go1.22.5"
+	info = internal.BuildInfo{Version: "v1.0.6", GoVersion: "go1.22.5"}
+	if got := info.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestVersionInfoJSON(t *testing.T) {
+	for i, test := range []struct {
+		info internal.BuildInfo
+		want string
+	}{
+		{
+			info: internal.BuildInfo{Version: "v1.0.6", Commit: "0123abc", BuildDate: "2024-08-01T10:00:00Z", GoVersion: "go1.22.5"},
+			want: `{"version":"v1.0.6","commit":"0123abc","buildDate":"2024-08-01T10:00:00Z","goVersion":"go1.22.5"}`,
+		},
+		{
+			info: internal.BuildInfo{Version: "v1.0.6", GoVersion: "go1.22.5"},
+			want: `{"version":"v1.0.6","commit":"unknown","buildDate":"unknown","goVersion":"go1.22.5"}`,
+		},
+	} {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			got, err := json.Marshal(test.info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
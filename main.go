@@ -5,6 +5,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -19,12 +20,19 @@ func main() {
 
 	pluginPattern := regexp.MustCompile(`^--?plugin$`)
 	getverPattern := regexp.MustCompile(`^--?version$`)
+	jsonPattern := regexp.MustCompile(`^--?json$`)
 
 	switch {
 	case len(os.Args) > 1 && pluginPattern.MatchString(os.Args[1]):
 		pluginMain(os.Args[0]+" "+os.Args[1], os.Args[2:])
+	case len(os.Args) > 2 && getverPattern.MatchString(os.Args[1]) && jsonPattern.MatchString(os.Args[2]):
+		b, err := json.Marshal(internal.VersionInfo())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
 	case len(os.Args) > 1 && getverPattern.MatchString(os.Args[1]):
-		fmt.Println(internal.Version)
+		fmt.Println(internal.VersionInfo())
 	default:
 		log.Fatalf("%s can't be used directly, it is meant to be invoked by AWS CLI", os.Args[0])
 	}